@@ -0,0 +1,446 @@
+// package aggregator answers requests of aggregation gateways with one
+// JSON document merged from responses of several calls
+package aggregator
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"sync"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/metrics"
+	"github.com/osamikoyo/orion/router"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+var (
+	errBodyTooLarge = errors.New("response body is too large")
+	errNotJSON      = errors.New("response is not json")
+	errDependency   = errors.New("dependency failed")
+)
+
+// hopHeaders are not copied from request of client to calls
+var hopHeaders = []string{"Connection", "Upgrade", "Keep-Alive", "Te", "Accept-Encoding", "Content-Length"}
+
+type (
+	// Aggregator fans request out to calls of gateway and merges their responses,
+	// calls wait only for calls they depend on, so independent ones run in parallel
+	Aggregator struct {
+		logger  *logger.Logger
+		gateway *config.Gateway
+		// next serves calls with path, they are routed like requests of clients
+		next   http.Handler
+		client *http.Client
+	}
+
+	// result of call, done is closed when it is ready
+	result struct {
+		// body is the whole response, placeholders of dependent calls read it
+		body  []byte
+		value []byte
+		err   error
+		done  chan struct{}
+	}
+
+	// statusError is returned for call answered with error status
+	statusError struct {
+		status int
+	}
+
+	// callKey marks context of calls, aggregation is not served inside of another one
+	callKey struct{}
+)
+
+func New(gateway *config.Gateway, next http.Handler, logger *logger.Logger) *Aggregator {
+	return &Aggregator{
+		logger:  logger,
+		gateway: gateway,
+		next:    next,
+		client:  &http.Client{},
+	}
+}
+
+func (a *Aggregator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := &a.gateway.Aggregate
+
+	if r.Context().Value(callKey{}) != nil {
+		a.logger.Error("aggregation called from another aggregation",
+			zap.String("prefix", a.gateway.Name),
+			zap.String("path", r.URL.Path))
+
+		writeError(w, http.StatusLoopDetected, "nested aggregation is not supported")
+
+		return
+	}
+
+	body, err := a.readBody(r)
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(r.Context(), callKey{}, true))
+	defer cancel()
+
+	params := requestParams(r)
+
+	results := make(map[string]*result, len(cfg.Calls))
+	for _, call := range cfg.Calls {
+		results[call.Name] = &result{done: make(chan struct{})}
+	}
+
+	var wg sync.WaitGroup
+
+	for i := range cfg.Calls {
+		call := &cfg.Calls[i]
+		res := results[call.Name]
+
+		wg.Go(func() {
+			defer close(res.done)
+
+			res.body, res.value, res.err = a.call(ctx, r, call, params, results, body)
+			a.report(call, res.err)
+
+			// response is failed anyway, so other calls are not needed
+			if res.err != nil && !call.Optional {
+				cancel()
+			}
+		})
+	}
+
+	wg.Wait()
+
+	if call := failedCall(cfg.Calls, results); call != nil {
+		metrics.ErrorRequestTotal.WithLabelValues(r.URL.Path, "aggregate_call").Inc()
+
+		status := http.StatusBadGateway
+		if errors.Is(results[call.Name].err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+
+		writeError(w, status, fmt.Sprintf("call %s failed", call.Name))
+
+		return
+	}
+
+	doc := make(map[string]any)
+	failed := make(map[string]any)
+
+	for _, call := range cfg.Calls {
+		res := results[call.Name]
+		if res.err != nil {
+			failed[call.Name] = reasonOf(res.err)
+
+			continue
+		}
+
+		if res.value == nil {
+			continue
+		}
+
+		if err := merge(doc, call.Into, res.value); err != nil {
+			a.logger.Error("failed merge response of call",
+				zap.String("prefix", a.gateway.Name),
+				zap.String("call", call.Name),
+				zap.Error(err))
+
+			writeError(w, http.StatusBadGateway, fmt.Sprintf("failed merge response of call %s", call.Name))
+
+			return
+		}
+	}
+
+	if cfg.Errors != "" && len(failed) > 0 {
+		if err := put(doc, cfg.Errors, failed); err != nil {
+			a.logger.Error("failed put errors of calls",
+				zap.String("prefix", a.gateway.Name),
+				zap.Error(err))
+		}
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		a.logger.Error("failed encode aggregated document",
+			zap.String("prefix", a.gateway.Name),
+			zap.Error(err))
+
+		writeError(w, http.StatusInternalServerError, "failed encode response")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// readBody reads body of client request when some call forwards it
+func (a *Aggregator) readBody(r *http.Request) ([]byte, error) {
+	forward := false
+	for _, call := range a.gateway.Aggregate.Calls {
+		forward = forward || call.ForwardBody
+	}
+
+	if !forward || r.Body == nil {
+		return nil, nil
+	}
+
+	limit := a.gateway.Aggregate.MaxBodySize
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed read request body: %v", err)
+	}
+
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("request body is too large")
+	}
+
+	return body, nil
+}
+
+// call waits for dependencies and sends call, value is part of response picked by select
+func (a *Aggregator) call(
+	ctx context.Context,
+	r *http.Request,
+	call *config.AggregateCall,
+	params map[string]string,
+	results map[string]*result,
+	body []byte,
+) ([]byte, []byte, error) {
+	for _, dep := range call.DependsOn {
+		select {
+		case <-results[dep].done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+
+		if results[dep].err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", errDependency, dep)
+		}
+	}
+
+	if call.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, call.Timeout)
+		defer cancel()
+	}
+
+	target, err := expand(cmp.Or(call.Path, call.URL), params, results)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var reader io.Reader
+	if call.ForwardBody {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, call.Method, target, reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed build request: %v", err)
+	}
+
+	var (
+		status int
+		resp   []byte
+	)
+
+	if call.Path != "" {
+		// call keeps headers of client, so auth and limits of its route are applied
+		req.Header = r.Header.Clone()
+		for _, name := range hopHeaders {
+			req.Header.Del(name)
+		}
+
+		req.Host = r.Host
+		req.RemoteAddr = r.RemoteAddr
+		req.TLS = r.TLS
+		req.RequestURI = req.URL.RequestURI()
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if ct := r.Header.Get("Content-Type"); call.ForwardBody && ct != "" {
+		req.Header.Set("Content-Type", ct)
+	} else {
+		req.Header.Del("Content-Type")
+	}
+
+	if call.Path != "" {
+		status, resp, err = a.route(req)
+	} else {
+		status, resp, err = a.fetch(req)
+	}
+
+	// gateway of call answers with error status when deadline is exceeded
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, nil, ctxErr
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if status >= http.StatusMultipleChoices {
+		return nil, nil, &statusError{status: status}
+	}
+
+	// empty response like 204 adds nothing to document
+	if len(bytes.TrimSpace(resp)) == 0 {
+		return nil, nil, nil
+	}
+
+	if !json.Valid(resp) {
+		return nil, nil, errNotJSON
+	}
+
+	if call.Select == "" {
+		return resp, resp, nil
+	}
+
+	value := gjson.GetBytes(resp, call.Select)
+	if !value.Exists() {
+		return resp, nil, nil
+	}
+
+	return resp, []byte(value.Raw), nil
+}
+
+// route serves call with handler of gateway, so it is routed like request of client
+func (a *Aggregator) route(req *http.Request) (status int, body []byte, err error) {
+	rb := newResponseBuffer(a.gateway.Aggregate.MaxBodySize)
+
+	// reverse proxy panics with http.ErrAbortHandler when copy of response fails
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("call aborted: %v", p)
+		}
+	}()
+
+	a.next.ServeHTTP(rb, req)
+
+	if rb.overflow {
+		return 0, nil, errBodyTooLarge
+	}
+
+	return rb.statusCode(), rb.body.Bytes(), nil
+}
+
+// fetch sends call to service outside of gateway, headers of client are not sent there
+func (a *Aggregator) fetch(req *http.Request) (int, []byte, error) {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	limit := a.gateway.Aggregate.MaxBodySize
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed read response: %v", err)
+	}
+
+	if int64(len(body)) > limit {
+		return 0, nil, errBodyTooLarge
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+func (a *Aggregator) report(call *config.AggregateCall, err error) {
+	result := "ok"
+
+	switch {
+	case err == nil:
+	case errors.Is(err, context.Canceled):
+		// call was stopped because another required call failed or client left
+		result = "canceled"
+	default:
+		result = "failed"
+
+		a.logger.Warn("aggregation call failed",
+			zap.String("prefix", a.gateway.Name),
+			zap.String("call", call.Name),
+			zap.Bool("optional", call.Optional),
+			zap.Error(err))
+	}
+
+	metrics.AggregateCalls.WithLabelValues(a.gateway.Name, call.Name, result).Inc()
+}
+
+// failedCall returns required call which failed the aggregation, calls
+// canceled after failure of another one are returned only when nothing else failed
+func failedCall(calls []config.AggregateCall, results map[string]*result) *config.AggregateCall {
+	var failed *config.AggregateCall
+
+	for i, call := range calls {
+		err := results[call.Name].err
+		if err == nil || call.Optional {
+			continue
+		}
+
+		if !errors.Is(err, context.Canceled) {
+			return &calls[i]
+		}
+
+		if failed == nil {
+			failed = &calls[i]
+		}
+	}
+
+	return failed
+}
+
+// requestParams returns query parameters of request and parameters of matched route
+func requestParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+
+	for name, values := range r.URL.Query() {
+		params[name] = values[0]
+	}
+
+	if m := router.FromContext(r.Context()); m != nil {
+		maps.Copy(params, m.Params)
+	}
+
+	return params
+}
+
+// reasonOf returns error of call shown to client, transport errors are hidden
+func reasonOf(err error) string {
+	var se *statusError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &se), errors.Is(err, errBodyTooLarge),
+		errors.Is(err, errNotJSON), errors.Is(err, errDependency):
+		return err.Error()
+	default:
+		return "failed"
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	data, _ := json.Marshal(map[string]string{"error": message})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (se *statusError) Error() string {
+	return fmt.Sprintf("status %d", se.status)
+}
@@ -0,0 +1,424 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/router"
+	"go.uber.org/zap"
+)
+
+// testServices answers calls routed by gateway and stores paths of calls
+type testServices struct {
+	calls []string
+	mu    sync.Mutex
+}
+
+func (ts *testServices) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	ts.calls = append(ts.calls, r.URL.RequestURI())
+	ts.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/users/42":
+		w.Write([]byte(`{"id":"42","name":"Ann","team":"core"}`))
+	case r.URL.Path == "/teams/core":
+		w.Write([]byte(`{"title":"Core team"}`))
+	case strings.HasPrefix(r.URL.Path, "/orders"):
+		w.Write([]byte(`{"items":[{"id":1},{"id":2}],"total":2}`))
+	case r.URL.Path == "/empty":
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/text":
+		w.Write([]byte("plain text"))
+	case r.URL.Path == "/slow":
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+
+		w.Write([]byte(`{}`))
+	default:
+		http.Error(w, `{"error":"failed"}`, http.StatusInternalServerError)
+	}
+}
+
+func (ts *testServices) called(path string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, call := range ts.calls {
+		if call == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func serveAggregate(t *testing.T, cfg config.AggregateConfig, target string) (*httptest.ResponseRecorder, *testServices) {
+	t.Helper()
+
+	if cfg.MaxBodySize == 0 {
+		cfg.MaxBodySize = 1 << 20
+	}
+
+	for i := range cfg.Calls {
+		if cfg.Calls[i].Method == "" {
+			cfg.Calls[i].Method = http.MethodGet
+		}
+	}
+
+	gateway := &config.Gateway{Name: "profile", Match: config.MatchConfig{Path: "/profile/{id}"}, Aggregate: cfg}
+	services := &testServices{}
+
+	rtr, err := router.New([]config.Gateway{*gateway})
+	if err != nil {
+		t.Fatalf("failed create router: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if m, ok := rtr.Match(r); ok {
+		r = r.WithContext(router.NewContext(r.Context(), m))
+	}
+
+	rec := httptest.NewRecorder()
+	New(gateway, services, &logger.Logger{Logger: zap.NewNop()}).ServeHTTP(rec, r)
+
+	return rec, services
+}
+
+func decodeDocument(t *testing.T, rec *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+
+	var doc map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("response %q is not JSON object: %v", rec.Body.String(), err)
+	}
+
+	return doc
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name  string
+		calls []config.AggregateCall
+		want  string
+	}{
+		{
+			name: "merge into fields",
+			calls: []config.AggregateCall{
+				{Name: "user", Path: "/users/{id}", Into: "user"},
+				{Name: "orders", Path: "/orders?user={id}", Select: "items", Into: "orders.items"},
+			},
+			want: `{"user":{"id":"42","name":"Ann","team":"core"},"orders":{"items":[{"id":1},{"id":2}]}}`,
+		},
+		{
+			name: "merge into root",
+			calls: []config.AggregateCall{
+				{Name: "user", Path: "/users/{id}"},
+				{Name: "orders", Path: "/orders", Select: "total", Into: "total"},
+			},
+			want: `{"id":"42","name":"Ann","team":"core","total":2}`,
+		},
+		{
+			name: "dependency value",
+			calls: []config.AggregateCall{
+				{Name: "team", Path: "/teams/{user.team}", DependsOn: []string{"user"}, Select: "title", Into: "team"},
+				{Name: "user", Path: "/users/{id}", Select: "name", Into: "name"},
+			},
+			want: `{"name":"Ann","team":"Core team"}`,
+		},
+		{
+			name: "empty response",
+			calls: []config.AggregateCall{
+				{Name: "user", Path: "/users/{id}", Select: "id", Into: "id"},
+				{Name: "empty", Path: "/empty", Into: "empty"},
+			},
+			want: `{"id":"42"}`,
+		},
+		{
+			name: "missing select",
+			calls: []config.AggregateCall{
+				{Name: "user", Path: "/users/{id}", Select: "email", Into: "email"},
+			},
+			want: `{}`,
+		},
+		{
+			name: "optional call failed",
+			calls: []config.AggregateCall{
+				{Name: "user", Path: "/users/{id}", Select: "name", Into: "name"},
+				{Name: "broken", Path: "/broken", Optional: true, Into: "broken"},
+				{Name: "text", Path: "/text", Optional: true, Into: "text"},
+			},
+			want: `{"name":"Ann","errors":{"broken":"status 500","text":"response is not json"}}`,
+		},
+		{
+			name: "dependency of optional call failed",
+			calls: []config.AggregateCall{
+				{Name: "broken", Path: "/broken", Optional: true},
+				{Name: "next", Path: "/teams/{broken.team}", DependsOn: []string{"broken"}, Optional: true},
+			},
+			want: `{"errors":{"broken":"status 500","next":"dependency failed: broken"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _ := serveAggregate(t, config.AggregateConfig{Calls: tt.calls, Errors: "errors"}, "/profile/42")
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+
+			var want map[string]any
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("invalid expected JSON: %v", err)
+			}
+
+			if got := decodeDocument(t, rec); !reflect.DeepEqual(got, want) {
+				t.Errorf("document %s, want %s", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateRequiredFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		calls []config.AggregateCall
+		want  int
+		// skipped is call which must not be sent after failure
+		skipped string
+	}{
+		{
+			name: "failed call",
+			calls: []config.AggregateCall{
+				{Name: "user", Path: "/users/{id}"},
+				{Name: "broken", Path: "/broken"},
+			},
+			want: http.StatusBadGateway,
+		},
+		{
+			name: "dependent call is not sent",
+			calls: []config.AggregateCall{
+				{Name: "broken", Path: "/broken"},
+				{Name: "team", Path: "/teams/core", DependsOn: []string{"broken"}},
+			},
+			want:    http.StatusBadGateway,
+			skipped: "/teams/core",
+		},
+		{
+			name: "timeout",
+			calls: []config.AggregateCall{
+				{Name: "slow", Path: "/slow", Timeout: 20 * time.Millisecond},
+			},
+			want: http.StatusGatewayTimeout,
+		},
+		{
+			name: "failure cancels slow call",
+			calls: []config.AggregateCall{
+				{Name: "slow", Path: "/slow"},
+				{Name: "broken", Path: "/broken"},
+			},
+			want: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := time.Now()
+
+			rec, services := serveAggregate(t, config.AggregateConfig{Calls: tt.calls}, "/profile/42")
+
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+
+			if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+				t.Errorf("aggregation took %s, failed call did not stop it", elapsed)
+			}
+
+			if tt.skipped != "" && services.called(tt.skipped) {
+				t.Errorf("call %s was sent after its dependency failed", tt.skipped)
+			}
+
+			if doc := decodeDocument(t, rec); doc["error"] == nil {
+				t.Errorf("response %s has no error", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAggregateNested(t *testing.T) {
+	gateway := &config.Gateway{
+		Name: "profile",
+		Aggregate: config.AggregateConfig{
+			MaxBodySize: 1 << 20,
+			Calls:       []config.AggregateCall{{Name: "self", Path: "/profile", Method: http.MethodGet}},
+		},
+	}
+
+	var agg *Aggregator
+	agg = New(gateway, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agg.ServeHTTP(w, r)
+	}), &logger.Logger{Logger: zap.NewNop()})
+
+	rec := httptest.NewRecorder()
+	agg.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profile", nil))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502 for call served by nested aggregation", rec.Code)
+	}
+}
+
+func TestAggregateURLCall(t *testing.T) {
+	var header http.Header
+
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Write([]byte(`{"rate":1.5}`))
+	}))
+	defer external.Close()
+
+	gateway := &config.Gateway{
+		Name: "rates",
+		Aggregate: config.AggregateConfig{
+			MaxBodySize: 1 << 20,
+			Calls: []config.AggregateCall{
+				{Name: "rate", URL: external.URL + "/rates?currency={currency}", Method: http.MethodGet, Select: "rate", Into: "rate"},
+			},
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/rates?currency=EUR", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+
+	rec := httptest.NewRecorder()
+	New(gateway, http.NotFoundHandler(), &logger.Logger{Logger: zap.NewNop()}).ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"rate":1.5}` {
+		t.Fatalf("got %d %s, want 200 {\"rate\":1.5}", rec.Code, rec.Body.String())
+	}
+
+	if header.Get("Authorization") != "" {
+		t.Errorf("headers of client were sent outside of gateway")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	results := map[string]*result{
+		"user": {body: []byte(`{"team":"core/a b","id":7}`)},
+	}
+	params := map[string]string{"id": "a/b", "q": "x&y"}
+
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{tmpl: "/users/{id}", want: "/users/a%2Fb"},
+		{tmpl: "/teams/{user.team}/members", want: "/teams/core%2Fa%20b/members"},
+		{tmpl: "/search?q={q}&user={user.id}", want: "/search?q=x%26y&user=7"},
+		{tmpl: "/missing/{unknown}", want: "/missing/"},
+	}
+
+	for _, tt := range tests {
+		got, err := expand(tt.tmpl, params, results)
+		if err != nil {
+			t.Fatalf("failed expand %s: %v", tt.tmpl, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("expand %s = %s, want %s", tt.tmpl, got, tt.want)
+		}
+	}
+
+	if _, err := expand("/users/{id", params, results); err == nil {
+		t.Errorf("unclosed placeholder is expanded")
+	}
+}
+
+func TestExpandDotSegments(t *testing.T) {
+	results := map[string]*result{
+		"user": {body: []byte(`{"team":".."}`)},
+	}
+	params := map[string]string{"up": "..", "dot": ".", "name": "..."}
+
+	tests := []struct {
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{tmpl: "/users/{up}", wantErr: true},
+		{tmpl: "/users/{dot}/orders", wantErr: true},
+		{tmpl: "/teams/{user.team}", wantErr: true},
+		{tmpl: "/users/{name}", want: "/users/..."},
+		{tmpl: "/search?q={up}", want: "/search?q=.."},
+	}
+
+	for _, tt := range tests {
+		got, err := expand(tt.tmpl, params, results)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("expand %s error %v, want error %v", tt.tmpl, err, tt.wantErr)
+		}
+
+		if got != tt.want {
+			t.Errorf("expand %s = %s, want %s", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestPut(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		path    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "new field", doc: `{}`, path: "a.b", value: `1`, want: `{"a":{"b":1}}`},
+		{name: "merge object", doc: `{"a":{"x":1}}`, path: "a", value: `{"y":2}`, want: `{"a":{"x":1,"y":2}}`},
+		{name: "replace value", doc: `{"a":1}`, path: "a", value: `[1]`, want: `{"a":[1]}`},
+		{name: "root", doc: `{"a":1}`, path: "", value: `{"b":2}`, want: `{"a":1,"b":2}`},
+		{name: "root requires object", doc: `{}`, path: "", value: `[1]`, wantErr: true},
+		{name: "parent is not object", doc: `{"a":1}`, path: "a.b", value: `2`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc map[string]any
+			json.Unmarshal([]byte(tt.doc), &doc)
+
+			err := merge(doc, tt.path, []byte(tt.value))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("document %v, want error", doc)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed merge: %v", err)
+			}
+
+			data, _ := json.Marshal(doc)
+
+			var got, want any
+			json.Unmarshal(data, &got)
+			json.Unmarshal([]byte(tt.want), &want)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("document %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// responseBuffer stores response of call served by gateway up to limit,
+// writes never fail, so reverse proxy copies the whole response
+type responseBuffer struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+// expand replaces {call.path} placeholders with values from responses of calls
+// and {name} ones with parameters of request, values are escaped for their place
+func expand(tmpl string, params map[string]string, results map[string]*result) (string, error) {
+	var b strings.Builder
+
+	query := false
+
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start < 0 {
+			b.WriteString(tmpl)
+
+			return b.String(), nil
+		}
+
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed placeholder in %s", tmpl)
+		}
+
+		literal := tmpl[:start]
+		query = query || strings.Contains(literal, "?")
+		b.WriteString(literal)
+
+		value := lookup(tmpl[start+1:start+end], params, results)
+		if query {
+			b.WriteString(url.QueryEscape(value))
+		} else {
+			// dot segments are not escaped and would move path out of its prefix
+			if value == "." || value == ".." {
+				return "", fmt.Errorf("value %q of %s can't be used in path", value, tmpl[start:start+end+1])
+			}
+
+			b.WriteString(url.PathEscape(value))
+		}
+
+		tmpl = tmpl[start+end+1:]
+	}
+}
+
+func lookup(ref string, params map[string]string, results map[string]*result) string {
+	if name, path, ok := strings.Cut(ref, "."); ok {
+		if res, ok := results[name]; ok {
+			return gjson.GetBytes(res.body, path).String()
+		}
+	}
+
+	return params[ref]
+}
+
+// merge puts JSON value at dotted path of document
+func merge(doc map[string]any, path string, raw []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+
+	return put(doc, path, value)
+}
+
+// put sets value at dotted path creating missing objects, object value is merged
+// into existing object at the path and into root of document when path is empty
+func put(doc map[string]any, path string, value any) error {
+	if path == "" {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("value merged into root must be object")
+		}
+
+		maps.Copy(doc, object)
+
+		return nil
+	}
+
+	keys := strings.Split(path, ".")
+
+	parent := doc
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := parent[key].(map[string]any)
+		if !ok {
+			if _, exists := parent[key]; exists {
+				return fmt.Errorf("field %s of %s is not object", key, path)
+			}
+
+			next = make(map[string]any)
+			parent[key] = next
+		}
+
+		parent = next
+	}
+
+	last := keys[len(keys)-1]
+
+	if existing, ok := parent[last].(map[string]any); ok {
+		if object, ok := value.(map[string]any); ok {
+			maps.Copy(existing, object)
+
+			return nil
+		}
+	}
+
+	parent[last] = value
+
+	return nil
+}
+
+func newResponseBuffer(limit int64) *responseBuffer {
+	return &responseBuffer{
+		header: make(http.Header),
+		limit:  limit,
+	}
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+func (rb *responseBuffer) WriteHeader(status int) {
+	// informational responses are followed by the final one
+	if rb.status == 0 && status >= http.StatusOK {
+		rb.status = status
+	}
+}
+
+func (rb *responseBuffer) Write(p []byte) (int, error) {
+	rb.WriteHeader(http.StatusOK)
+
+	if rb.overflow || int64(rb.body.Len()+len(p)) > rb.limit {
+		rb.overflow = true
+
+		return len(p), nil
+	}
+
+	return rb.body.Write(p)
+}
+
+// Flush is noop, response is used only when it is complete
+func (rb *responseBuffer) Flush() {}
+
+func (rb *responseBuffer) statusCode() int {
+	if rb.status == 0 {
+		return http.StatusOK
+	}
+
+	return rb.status
+}
@@ -2,6 +2,7 @@
 package auth
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -23,7 +24,7 @@ func NewAuthMW(cfg *config.Config, logger *logger.Logger) *AuthMW {
 
 func (a *AuthMW) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenStr := r.Header.Get("Authorization")
+		tokenStr := bearerToken(r)
 		if tokenStr == "" {
 			http.Error(w, "empty auth token", http.StatusNonAuthoritativeInfo)
 			return
@@ -37,6 +38,11 @@ func (a *AuthMW) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// only claims of verified token are read by balancers and header rules
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			r = r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims))
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+const testKey = "secret"
+
+func signToken(t *testing.T, key string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+	if err != nil {
+		t.Fatalf("failed sign token: %v", err)
+	}
+
+	return token
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	claims := jwt.MapClaims{"sub": "alice", "groups": []any{"admin", "dev"}}
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("failed build unsigned token: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantSub       string
+	}{
+		{name: "bearer token", authorization: "Bearer " + signToken(t, testKey, claims), wantStatus: http.StatusOK, wantSub: "alice"},
+		{name: "token without scheme", authorization: signToken(t, testKey, claims), wantStatus: http.StatusOK, wantSub: "alice"},
+		{name: "no token", wantStatus: http.StatusNonAuthoritativeInfo},
+		{name: "wrong key", authorization: "Bearer " + signToken(t, "other", claims), wantStatus: http.StatusBadGateway},
+		{name: "unsigned token", authorization: "Bearer " + unsigned, wantStatus: http.StatusBadGateway},
+	}
+
+	mw := NewAuthMW(&config.Config{AuthConfig: config.AuthConfig{Key: testKey}}, &logger.Logger{Logger: zap.NewNop()})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sub string
+			var groups []string
+
+			handler := mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sub = Claim(r, "sub")
+				groups = ClaimValues(r, "groups")
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if sub != tt.wantSub {
+				t.Errorf("claim sub %q, want %q", sub, tt.wantSub)
+			}
+
+			if tt.wantSub != "" && !slices.Equal(groups, []string{"admin", "dev"}) {
+				t.Errorf("claim groups %v, want [admin dev]", groups)
+			}
+		})
+	}
+}
+
+func TestClaimWithoutAuth(t *testing.T) {
+	// token which was not verified by middleware is not trusted
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signToken(t, "forged", jwt.MapClaims{"sub": "admin"}))
+
+	if sub := Claim(r, "sub"); sub != "" {
+		t.Errorf("claim %q read from unverified token", sub)
+	}
+
+	if values := ClaimValues(r, "sub"); values != nil {
+		t.Errorf("claim values %v read from unverified token", values)
+	}
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claim reads claim from jwt token verified by auth middleware,
+// requests of gateways without auth have no claims
+func Claim(r *http.Request, name string) string {
+	value, ok := claim(r, name)
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// ClaimValues reads claim like Claim, array claim like groups
+// is returned as list of its elements
+func ClaimValues(r *http.Request, name string) []string {
+	value, ok := claim(r, name)
+	if !ok {
+		return nil
+	}
+
+	list, ok := value.([]any)
+	if !ok {
+		return []string{fmt.Sprint(value)}
+	}
+
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		values = append(values, fmt.Sprint(item))
+	}
+
+	return values
+}
+
+// claimsKey stores claims of verified token in context of request
+type claimsKey struct{}
+
+func claim(r *http.Request, name string) (any, bool) {
+	claims, ok := r.Context().Value(claimsKey{}).(jwt.MapClaims)
+	if !ok {
+		return nil, false
+	}
+
+	value, ok := claims[name]
+
+	return value, ok
+}
+
+// bearerToken returns token of Authorization header with or without Bearer scheme
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
@@ -0,0 +1,227 @@
+// circuit breaker for gateways and their targets
+package breaker
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/metrics"
+	"go.uber.org/zap"
+)
+
+// gatewayLabel is used as target label of breaker which covers the whole gateway
+const gatewayLabel = "*"
+
+type (
+	State int
+
+	// Outcome is result of request admitted by breaker
+	Outcome int
+
+	// DoneFunc must be called once with outcome of admitted request
+	DoneFunc func(outcome Outcome)
+
+	// Breaker stops requests to upstream after failures and
+	// lets a few probe requests through when open state is over
+	Breaker struct {
+		prefix string
+		target string
+		cfg    config.CircuitBreakerConfig
+		logger *logger.Logger
+
+		state    State
+		openedAt time.Time
+		// consecutive stores failures in a row
+		consecutive int
+		// windowStart, total and failed describe current error rate window
+		windowStart time.Time
+		total       int
+		failed      int
+		// probes is count of admitted half-open requests,
+		// successes is count of finished successful ones
+		probes    int
+		successes int
+
+		mu sync.Mutex
+	}
+)
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+const (
+	OutcomeSuccess Outcome = iota
+	OutcomeFailure
+	// OutcomeSkipped means upstream was not called, it is not counted
+	OutcomeSkipped
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// NewBreaker creates closed breaker, empty target means breaker of the whole gateway
+func NewBreaker(prefix, target string, cfg config.CircuitBreakerConfig, logger *logger.Logger) *Breaker {
+	if target == "" {
+		target = gatewayLabel
+	}
+
+	metrics.CircuitBreakerState.WithLabelValues(prefix, target).Set(float64(StateClosed))
+
+	return &Breaker{
+		prefix:      prefix,
+		target:      target,
+		cfg:         cfg,
+		logger:      logger,
+		windowStart: time.Now(),
+	}
+}
+
+// Allow admits request, when breaker is open it returns false
+// and time after which client may retry
+func (b *Breaker) Allow() (DoneFunc, time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+
+	if b.state == StateOpen {
+		if wait := b.cfg.OpenDuration - now.Sub(b.openedAt); wait > 0 {
+			return nil, wait, false
+		}
+
+		b.setState(StateHalfOpen, now)
+	}
+
+	halfOpen := b.state == StateHalfOpen
+	if halfOpen {
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return nil, time.Second, false
+		}
+
+		b.probes++
+	}
+
+	var once sync.Once
+
+	return func(outcome Outcome) {
+		once.Do(func() {
+			b.done(outcome, halfOpen)
+		})
+	}, 0, true
+}
+
+func (b *Breaker) done(outcome Outcome, halfOpen bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+
+	// state was changed while request was in flight
+	if halfOpen != (b.state == StateHalfOpen) {
+		return
+	}
+
+	if halfOpen {
+		switch outcome {
+		case OutcomeSkipped:
+			b.probes--
+		case OutcomeFailure:
+			b.setState(StateOpen, now)
+		case OutcomeSuccess:
+			b.successes++
+			if b.successes >= b.cfg.HalfOpenRequests {
+				b.setState(StateClosed, now)
+			}
+		}
+
+		return
+	}
+
+	if outcome == OutcomeSkipped {
+		return
+	}
+
+	if now.Sub(b.windowStart) > b.cfg.Window {
+		b.windowStart = now
+		b.total, b.failed = 0, 0
+	}
+
+	b.total++
+
+	if outcome == OutcomeSuccess {
+		b.consecutive = 0
+
+		return
+	}
+
+	b.failed++
+	b.consecutive++
+
+	if b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures {
+		b.setState(StateOpen, now)
+
+		return
+	}
+
+	if b.cfg.ErrorRate > 0 && b.total >= b.cfg.MinRequests &&
+		b.failed*100 >= b.cfg.ErrorRate*b.total {
+		b.setState(StateOpen, now)
+	}
+}
+
+// setState must be called with locked mutex
+func (b *Breaker) setState(state State, now time.Time) {
+	if b.state == state {
+		return
+	}
+
+	b.logger.Warn("circuit breaker state changed",
+		zap.String("prefix", b.prefix),
+		zap.String("target", b.target),
+		zap.String("from", b.state.String()),
+		zap.String("to", state.String()))
+
+	b.state = state
+	b.probes, b.successes = 0, 0
+	b.consecutive = 0
+	b.windowStart = now
+	b.total, b.failed = 0, 0
+
+	if state == StateOpen {
+		b.openedAt = now
+	}
+
+	metrics.CircuitBreakerState.WithLabelValues(b.prefix, b.target).Set(float64(state))
+	metrics.CircuitBreakerTransitions.WithLabelValues(b.prefix, b.target, state.String()).Inc()
+}
+
+// RetryAfter formats wait duration as seconds for Retry-After header
+func RetryAfter(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
+
+// OpenError is returned when request is rejected by open breaker
+type OpenError struct {
+	// Wait is time after which client may retry
+	Wait time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return "circuit breaker is open"
+}
@@ -0,0 +1,243 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+func newTestBreaker(cfg config.CircuitBreakerConfig) *Breaker {
+	return NewBreaker("test", "", cfg, &logger.Logger{Logger: zap.NewNop()})
+}
+
+// finish admits request and reports its outcome
+func finish(t *testing.T, b *Breaker, outcome Outcome) {
+	t.Helper()
+
+	done, _, ok := b.Allow()
+	if !ok {
+		t.Fatalf("request rejected in state %s", b.state)
+	}
+
+	done(outcome)
+}
+
+func TestBreakerOpens(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.CircuitBreakerConfig
+		outcomes []Outcome
+		want     State
+	}{
+		{
+			name:     "consecutive failures",
+			cfg:      config.CircuitBreakerConfig{ConsecutiveFailures: 3, Window: time.Minute},
+			outcomes: []Outcome{OutcomeFailure, OutcomeFailure, OutcomeFailure},
+			want:     StateOpen,
+		},
+		{
+			name:     "success resets consecutive failures",
+			cfg:      config.CircuitBreakerConfig{ConsecutiveFailures: 3, Window: time.Minute},
+			outcomes: []Outcome{OutcomeFailure, OutcomeFailure, OutcomeSuccess, OutcomeFailure, OutcomeFailure},
+			want:     StateClosed,
+		},
+		{
+			name:     "skipped requests are not counted",
+			cfg:      config.CircuitBreakerConfig{ConsecutiveFailures: 2, Window: time.Minute},
+			outcomes: []Outcome{OutcomeFailure, OutcomeSkipped, OutcomeSkipped, OutcomeSuccess, OutcomeFailure},
+			want:     StateClosed,
+		},
+		{
+			name:     "error rate",
+			cfg:      config.CircuitBreakerConfig{ErrorRate: 50, MinRequests: 4, Window: time.Minute},
+			outcomes: []Outcome{OutcomeSuccess, OutcomeFailure, OutcomeSuccess, OutcomeFailure},
+			want:     StateOpen,
+		},
+		{
+			name:     "error rate below min requests",
+			cfg:      config.CircuitBreakerConfig{ErrorRate: 50, MinRequests: 4, Window: time.Minute},
+			outcomes: []Outcome{OutcomeFailure, OutcomeFailure, OutcomeFailure},
+			want:     StateClosed,
+		},
+		{
+			name:     "error rate not reached",
+			cfg:      config.CircuitBreakerConfig{ErrorRate: 50, MinRequests: 4, Window: time.Minute},
+			outcomes: []Outcome{OutcomeSuccess, OutcomeSuccess, OutcomeSuccess, OutcomeFailure},
+			want:     StateClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBreaker(tt.cfg)
+
+			for _, outcome := range tt.outcomes {
+				finish(t, b, outcome)
+			}
+
+			if b.state != tt.want {
+				t.Errorf("state %s, want %s", b.state, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerOpenRejects(t *testing.T) {
+	b := newTestBreaker(config.CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenDuration:        time.Hour,
+		HalfOpenRequests:    1,
+	})
+
+	finish(t, b, OutcomeFailure)
+
+	_, wait, ok := b.Allow()
+	if ok {
+		t.Fatalf("request admitted by open breaker")
+	}
+
+	if wait <= 0 || wait > time.Hour {
+		t.Errorf("wait %s, want in (0, 1h]", wait)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes []Outcome
+		want     State
+	}{
+		{
+			name:     "probes succeed",
+			outcomes: []Outcome{OutcomeSuccess, OutcomeSuccess},
+			want:     StateClosed,
+		},
+		{
+			name:     "probe fails",
+			outcomes: []Outcome{OutcomeSuccess, OutcomeFailure},
+			want:     StateOpen,
+		},
+		{
+			name:     "skipped probe frees its slot",
+			outcomes: []Outcome{OutcomeSkipped, OutcomeSuccess, OutcomeSuccess},
+			want:     StateClosed,
+		},
+		{
+			name:     "not enough probes",
+			outcomes: []Outcome{OutcomeSuccess},
+			want:     StateHalfOpen,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBreaker(config.CircuitBreakerConfig{
+				ConsecutiveFailures: 1,
+				Window:              time.Minute,
+				OpenDuration:        time.Millisecond,
+				HalfOpenRequests:    2,
+			})
+
+			finish(t, b, OutcomeFailure)
+			time.Sleep(2 * time.Millisecond)
+
+			for _, outcome := range tt.outcomes {
+				finish(t, b, outcome)
+			}
+
+			if b.state != tt.want {
+				t.Errorf("state %s, want %s", b.state, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpenLimitsProbes(t *testing.T) {
+	b := newTestBreaker(config.CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenDuration:        time.Millisecond,
+		HalfOpenRequests:    2,
+	})
+
+	finish(t, b, OutcomeFailure)
+	time.Sleep(2 * time.Millisecond)
+
+	for range 2 {
+		if _, _, ok := b.Allow(); !ok {
+			t.Fatalf("probe rejected")
+		}
+	}
+
+	if _, _, ok := b.Allow(); ok {
+		t.Errorf("probe admitted over half open limit")
+	}
+}
+
+func TestBreakerDoneOnce(t *testing.T) {
+	b := newTestBreaker(config.CircuitBreakerConfig{ConsecutiveFailures: 2, Window: time.Minute})
+
+	done, _, ok := b.Allow()
+	if !ok {
+		t.Fatalf("request rejected")
+	}
+
+	done(OutcomeFailure)
+	done(OutcomeFailure)
+
+	if b.state != StateClosed {
+		t.Errorf("state %s, outcome reported twice", b.state)
+	}
+}
+
+func TestBreakerStaleOutcome(t *testing.T) {
+	b := newTestBreaker(config.CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		Window:              time.Minute,
+		OpenDuration:        time.Millisecond,
+		HalfOpenRequests:    1,
+	})
+
+	// request admitted in closed state finishes after breaker was opened
+	stale, _, _ := b.Allow()
+
+	finish(t, b, OutcomeFailure)
+	time.Sleep(2 * time.Millisecond)
+
+	probe, _, ok := b.Allow()
+	if !ok {
+		t.Fatalf("probe rejected")
+	}
+
+	stale(OutcomeFailure)
+
+	if b.state != StateHalfOpen {
+		t.Fatalf("state %s after stale outcome, want %s", b.state, StateHalfOpen)
+	}
+
+	probe(OutcomeSuccess)
+
+	if b.state != StateClosed {
+		t.Errorf("state %s, want %s", b.state, StateClosed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{wait: 0, want: 1},
+		{wait: 300 * time.Millisecond, want: 1},
+		{wait: 1500 * time.Millisecond, want: 2},
+		{wait: 30 * time.Second, want: 30},
+	}
+
+	for _, tt := range tests {
+		if got := RetryAfter(tt.wait); got != tt.want {
+			t.Errorf("RetryAfter(%s) = %d, want %d", tt.wait, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package breaker
+
+import (
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+)
+
+// gatewayBreakers stores breaker of gateway and breakers of its targets
+type gatewayBreakers struct {
+	gateway *Breaker
+	targets map[string]*Breaker
+}
+
+// CircuitBreakers stores breakers of every gateway with enabled circuit breaker
+type CircuitBreakers struct {
+	gateways map[string]*gatewayBreakers
+}
+
+func NewCircuitBreakers(cfg *config.Config, logger *logger.Logger) *CircuitBreakers {
+	gateways := make(map[string]*gatewayBreakers)
+
+	for _, gateway := range cfg.Gateways {
+		if !gateway.CircuitBreaker.Use {
+			continue
+		}
+
+		gb := &gatewayBreakers{
+			gateway: NewBreaker(gateway.Name, "", gateway.CircuitBreaker, logger),
+			targets: make(map[string]*Breaker, len(gateway.AllTargets())),
+		}
+
+		for _, target := range gateway.AllTargets() {
+			gb.targets[target.Url] = NewBreaker(gateway.Name, target.Url, gateway.CircuitBreaker, logger)
+		}
+
+		gateways[gateway.Name] = gb
+	}
+
+	return &CircuitBreakers{
+		gateways: gateways,
+	}
+}
+
+// Gateway returns breaker of named gateway or nil when circuit breaker is disabled
+func (cb *CircuitBreakers) Gateway(name string) *Breaker {
+	gb, ok := cb.gateways[name]
+	if !ok {
+		return nil
+	}
+
+	return gb.gateway
+}
+
+// Target returns breaker of target or nil when circuit breaker is disabled
+func (cb *CircuitBreakers) Target(name, target string) *Breaker {
+	gb, ok := cb.gateways[name]
+	if !ok {
+		return nil
+	}
+
+	return gb.targets[target]
+}
@@ -2,11 +2,14 @@
 package cache
 
 import (
+	"bufio"
+	"net"
 	"net/http"
 
 	"github.com/osamikoyo/orion/config"
 	"github.com/osamikoyo/orion/logger"
 	"github.com/osamikoyo/orion/selfcach"
+	"github.com/osamikoyo/orion/stream"
 	"go.uber.org/zap"
 )
 
@@ -22,6 +25,7 @@ func NewCache(sc *selfcach.Cache, logger *logger.Logger, cfg *config.Config) *Ca
 	return &Cache{
 		logger: logger,
 		cfg:    cfg,
+		cache:  sc,
 	}
 }
 
@@ -29,6 +33,12 @@ func NewCache(sc *selfcach.Cache, logger *logger.Logger, cfg *config.Config) *Ca
 func (c *Cache) Middleware(next http.Handler) http.Handler {
 	// return handler
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// streams have no end, so they are never cached
+		if stream.IsRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		key := r.URL.Path
 
 		// try to get cache for url
@@ -48,6 +58,13 @@ func (c *Cache) Middleware(next http.Handler) http.Handler {
 
 		wr := &responseWriter{ResponseWriter: w}
 		next.ServeHTTP(wr, r)
+
+		// upgraded and event stream responses are not cached even
+		// when request did not say it waits for stream
+		if wr.stream {
+			return
+		}
+
 		c.cache.Set(key, wr.body)
 	})
 }
@@ -56,10 +73,47 @@ func (c *Cache) Middleware(next http.Handler) http.Handler {
 type responseWriter struct {
 	http.ResponseWriter
 	body []byte
+	// stream is true when response turned out to be stream
+	stream      bool
+	wroteHeader bool
+}
+
+// http.ResponseWriter realization
+func (rw *responseWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.stream = stream.IsResponse(status, rw.Header())
+	}
+
+	rw.ResponseWriter.WriteHeader(status)
 }
 
 // io.Writer realization
 func (rw *responseWriter) Write(b []byte) (int, error) {
-	rw.body = append(rw.body, b...)
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if !rw.stream {
+		rw.body = append(rw.body, b...)
+	}
+
 	return rw.ResponseWriter.Write(b)
 }
+
+// Unwrap() allows http.ResponseController to reach original writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// http.Flusher realization
+func (rw *responseWriter) Flush() {
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// http.Hijacker realization
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.stream = true
+
+	return http.NewResponseController(rw.ResponseWriter).Hijack()
+}
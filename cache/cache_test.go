@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/selfcach"
+	"go.uber.org/zap"
+)
+
+// newTestCache serves next through cache middleware, returned wait blocks
+// until middleware finished every request, hijacked ones too
+func newTestCache(t *testing.T, next http.Handler) (*httptest.Server, *selfcach.Cache, func()) {
+	t.Helper()
+
+	log := &logger.Logger{Logger: zap.NewNop()}
+
+	sc := selfcach.NewCache(log, time.Hour, time.Hour)
+	t.Cleanup(sc.StopCleanup)
+
+	mw := NewCache(sc, log, &config.Config{}).Middleware(next)
+
+	var wg sync.WaitGroup
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wg.Add(1)
+		defer wg.Done()
+
+		mw.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, sc, wg.Wait
+}
+
+func TestCacheResponse(t *testing.T) {
+	calls := 0
+
+	server, sc, _ := newTestCache(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("users"))
+	}))
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(server.URL + "/users")
+		if err != nil {
+			t.Fatalf("failed get: %v", err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != "users" {
+			t.Errorf("body %q, want %q", body, "users")
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+
+	if value, ok := sc.Get("/users"); !ok || string(value) != "users" {
+		t.Errorf("cached %q, want %q", value, "users")
+	}
+}
+
+func TestCacheEventStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+	}{
+		{name: "stream request", accept: "text/event-stream"},
+		// response is found to be stream only by its content type
+		{name: "stream response", accept: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := make(chan struct{})
+
+			server, sc, wait := newTestCache(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+
+				for i := 0; i < 2; i++ {
+					io.WriteString(w, "data: event\n\n")
+
+					if err := http.NewResponseController(w).Flush(); err != nil {
+						t.Errorf("failed flush: %v", err)
+					}
+
+					// the next event is sent only when client got this one
+					select {
+					case <-next:
+					case <-time.After(time.Second):
+						return
+					}
+				}
+			}))
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("failed get: %v", err)
+			}
+			defer resp.Body.Close()
+
+			reader := bufio.NewReader(resp.Body)
+
+			for i := 0; i < 2; i++ {
+				line, err := reader.ReadString('\n')
+				if err != nil || line != "data: event\n" {
+					t.Fatalf("event %d is %q, %v, want flushed event", i, line, err)
+				}
+
+				reader.ReadString('\n')
+				next <- struct{}{}
+			}
+
+			io.Copy(io.Discard, reader)
+			wait()
+
+			if _, ok := sc.Get("/events"); ok {
+				t.Errorf("event stream is cached")
+			}
+		})
+	}
+}
+
+func TestCacheUpgrade(t *testing.T) {
+	server, sc, wait := newTestCache(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+
+		// echo one line back
+		line, _ := rw.ReadString('\n')
+		rw.WriteString(line)
+		rw.Flush()
+	}))
+
+	tests := []struct {
+		name    string
+		headers string
+	}{
+		{name: "upgrade request", headers: "Connection: Upgrade\r\nUpgrade: echo\r\n"},
+		// plain request is still hijacked by handler, it must not be cached
+		{name: "plain request", headers: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+			if err != nil {
+				t.Fatalf("failed dial: %v", err)
+			}
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(time.Second))
+			io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: orion\r\n"+tt.headers+"\r\n")
+
+			reader := bufio.NewReader(conn)
+
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("failed read response: %v", err)
+			}
+
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+			}
+
+			io.WriteString(conn, "ping\n")
+
+			if line, err := reader.ReadString('\n'); err != nil || line != "ping\n" {
+				t.Fatalf("got %q, %v, want echo of ping", line, err)
+			}
+		})
+	}
+
+	wait()
+
+	if _, ok := sc.Get("/ws"); ok {
+		t.Errorf("upgraded connection is cached")
+	}
+}
@@ -0,0 +1,92 @@
+// package resolves address of client behind trusted proxies
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver finds client address, X-Forwarded-For is used
+// only when request came from trusted proxy
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver parses trusted proxies, every proxy is ip or cidr
+func NewResolver(proxies []string) (*Resolver, error) {
+	trusted := make([]*net.IPNet, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+
+		_, ipnet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("failed parse trusted proxy %s: %v", proxy, err)
+		}
+
+		trusted = append(trusted, ipnet)
+	}
+
+	return &Resolver{
+		trusted: trusted,
+	}, nil
+}
+
+// ClientIP returns address of client
+func (res *Resolver) ClientIP(r *http.Request) string {
+	remote := RemoteIP(r)
+
+	if !res.IsTrusted(remote) {
+		return remote
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	// walk from the closest hop and skip our own proxies
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+
+		if !res.IsTrusted(ip) {
+			return ip
+		}
+	}
+
+	return remote
+}
+
+// IsTrusted returns true when addr belongs to trusted proxy
+func (res *Resolver) IsTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipnet := range res.trusted {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RemoteIP returns address of the peer which sent request
+func RemoteIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return remote
+}
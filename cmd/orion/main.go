@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/osamikoyo/orion/config"
@@ -67,9 +68,40 @@ func main() {
 
 	logger.Info("orion successfully started!")
 
+	// SIGHUP reloads settings which can be changed without restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			cfg, err := config.NewConfig(cfgpath)
+			if err != nil {
+				logger.Error("failed to reload config",
+					zap.String("path", cfgpath),
+					zap.Error(err))
+
+				continue
+			}
+
+			if err := server.Reload(cfg); err != nil {
+				logger.Error("failed to apply reloaded config", zap.Error(err))
+
+				continue
+			}
+
+			logger.Info("config reloaded", zap.String("path", cfgpath))
+		}
+	}()
+
 	<-ctx.Done()
 
-	if err = server.Shutdown(ctx); err != nil {
+	// signal context is already done, so shutdown gets its own deadline
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+
+	logger.Info("shutting down orion", zap.Duration("timeout", cfg.ShutdownTimeout))
+
+	if err = server.Shutdown(shutdownCtx); err != nil {
 		logger.Error("failed to shutdown orion", zap.Error(err))
 	}
 
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+type (
+	// AggregateConfig describes gateway which fans request out to calls and
+	// answers with one JSON document merged from their responses
+	AggregateConfig struct {
+		Calls []AggregateCall `yaml:"calls" validate:"omitempty,dive"`
+		// Errors is field of document which lists failed optional calls,
+		// they are only logged when it is empty
+		Errors string `yaml:"errors"`
+		// MaxBodySize limits response of every call
+		MaxBodySize int64 `yaml:"max_body_size" validate:"min=0"`
+	}
+
+	// AggregateCall is one call of aggregation, calls are sent in parallel
+	// unless they wait for responses of calls they depend on
+	AggregateCall struct {
+		Name string `yaml:"name" validate:"required"`
+		// Path is routed like request of client, so it is served by gateway of
+		// matched route with all its policies, URL is used for services outside
+		// of gateway. Both are templates where {name} is route parameter and
+		// {call.path} is gjson path in response of call from depends_on
+		Path   string `yaml:"path" validate:"omitempty,startswith=/"`
+		URL    string `yaml:"url"`
+		Method string `yaml:"method" validate:"omitempty,oneof=GET POST PUT PATCH DELETE"`
+		// ForwardBody sends body of client request with call
+		ForwardBody bool     `yaml:"forward_body"`
+		DependsOn   []string `yaml:"depends_on"`
+		// Timeout limits call, request timeout of gateway limits the whole aggregation
+		Timeout time.Duration `yaml:"timeout" validate:"min=0"`
+		// Optional call may fail, document is returned without its value
+		Optional bool `yaml:"optional"`
+		// Select is gjson path of value taken from response, the whole response by default
+		Select string `yaml:"select"`
+		// Into is dotted path of field where value is put,
+		// object value is merged into root of document when it is empty
+		Into string `yaml:"into"`
+	}
+)
+
+// IsAggregate returns true for gateway which answers with responses of calls instead of targets
+func (g *Gateway) IsAggregate() bool {
+	return len(g.Aggregate.Calls) > 0
+}
+
+// TemplateRefs returns names inside of {} placeholders of template
+func TemplateRefs(tmpl string) ([]string, error) {
+	var refs []string
+
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start < 0 {
+			return refs, nil
+		}
+
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in %s", tmpl)
+		}
+
+		refs = append(refs, tmpl[start+1:start+end])
+		tmpl = tmpl[start+end+1:]
+	}
+}
+
+func validateAggregate(g *Gateway) error {
+	if !g.IsAggregate() {
+		if g.Aggregate.Errors != "" {
+			return fmt.Errorf("errors field requires calls")
+		}
+
+		return nil
+	}
+
+	if len(g.Targets) > 0 || len(g.Pools) > 0 {
+		return fmt.Errorf("targets and pools can not be used with calls")
+	}
+
+	calls := make(map[string]*AggregateCall, len(g.Aggregate.Calls))
+	for i, call := range g.Aggregate.Calls {
+		if calls[call.Name] != nil {
+			return fmt.Errorf("duplicate call name %s", call.Name)
+		}
+
+		calls[call.Name] = &g.Aggregate.Calls[i]
+	}
+
+	for _, call := range g.Aggregate.Calls {
+		if err := validateCall(&call, calls); err != nil {
+			return fmt.Errorf("invalid call %s: %v", call.Name, err)
+		}
+	}
+
+	// calls are visited in depth, call seen again on the current path closes a cycle
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	state := make(map[string]int, len(calls))
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("calls have dependency cycle through %s", name)
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range calls[name].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, call := range g.Aggregate.Calls {
+		if err := visit(call.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateCall(call *AggregateCall, calls map[string]*AggregateCall) error {
+	if (call.Path == "") == (call.URL == "") {
+		return fmt.Errorf("exactly one of path and url is required")
+	}
+
+	if call.URL != "" {
+		scheme, _, _ := strings.Cut(call.URL, "://")
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("url %s must have http or https scheme", call.URL)
+		}
+	}
+
+	for _, dep := range call.DependsOn {
+		if dep == call.Name {
+			return fmt.Errorf("call depends on itself")
+		}
+
+		if calls[dep] == nil {
+			return fmt.Errorf("depends on unknown call %s", dep)
+		}
+	}
+
+	refs, err := TemplateRefs(call.Path + call.URL)
+	if err != nil {
+		return err
+	}
+
+	// values of responses are available only after calls listed in depends_on
+	for _, ref := range refs {
+		name, _, ok := strings.Cut(ref, ".")
+		if ok && calls[name] != nil && !slices.Contains(call.DependsOn, name) {
+			return fmt.Errorf("placeholder {%s} requires %s in depends_on", ref, name)
+		}
+	}
+
+	return nil
+}
@@ -15,16 +15,93 @@ const (
 	DefaultAddr               = ":8080"
 	DefaultProto              = "http"
 	DefaultRequestTimeout     = 30 * time.Second
+	DefaultShutdownTimeout    = 30 * time.Second
+	DefaultStreamIdleTimeout  = 5 * time.Minute
 	DefaultHealthCheckTimeout = 5 * time.Second
+	DefaultConnectTimeout     = 5 * time.Second
+	DefaultIdleConnTimeout    = 90 * time.Second
+	DefaultMaxIdleConns       = 64
+	DefaultKeepAlive          = 30 * time.Second
+	DefaultProbeTimeout       = 2 * time.Second
+	DefaultHealthCheckRise    = 2
+	DefaultHealthCheckFall    = 3
+	DefaultBaseEjection       = 30 * time.Second
+	DefaultMaxEjection        = 5 * time.Minute
+	DefaultMaxEjectedPercent  = 50
+	DefaultLatencyFactor      = 3.0
+	DefaultBreakerFailures    = 5
+	DefaultBreakerErrorRate   = 50
+	DefaultBreakerMinRequests = 20
+	DefaultBreakerWindow      = 10 * time.Second
+	DefaultBreakerOpen        = 30 * time.Second
+	DefaultBreakerHalfOpen    = 1
+	DefaultRetryBackoffBase   = 25 * time.Millisecond
+	DefaultRetryBackoffMax    = 250 * time.Millisecond
+	DefaultRetryMaxBodySize   = 64 << 10
+	DefaultRetryBudgetPercent = 20
+	DefaultRetryBudgetMinRPS  = 10
+	DefaultHedgeDelay         = 100 * time.Millisecond
+	DefaultHedgePercentile    = 95
+	DefaultHedgeBudget        = 10
+	DefaultMirrorPercent      = 100
+	DefaultMirrorMaxBodySize  = 64 << 10
+	DefaultMirrorMaxInFlight  = 100
+	DefaultAggregateMaxBody   = 1 << 20
+	DefaultTranscodeMaxBody   = 4 << 20
 	DefaultLoadBalancer       = "wrr"
+	DefaultForwardedMode      = "append"
 	DefaultRateLimitMaxReq    = 100
 	DefaultCORSMaxAge         = 86400
+	DefaultHashReplicas       = 160
+	DefaultEWMADecay          = 10 * time.Second
+	DefaultSlowStartMinWeight = 10
+	DefaultSlowStartAggr      = 1.0
 )
 
+// UpstreamTLS describes tls connection from gateway to target
+type UpstreamTLS struct {
+	// CA is bundle used to verify target certificate instead of system pool
+	CA   string `yaml:"ca" validate:"omitempty,file"`
+	Cert string `yaml:"cert" validate:"omitempty,required_with=Key,file"`
+	Key  string `yaml:"key" validate:"omitempty,required_with=Cert,file"`
+	// ServerName overrides SNI and name used for certificate verification
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// HealthCheckConfig describes active health probe of target
+type HealthCheckConfig struct {
+	// Type is kind of probe: http, https, tcp or grpc
+	Type string      `yaml:"type" validate:"omitempty,oneof=http https tcp grpc"`
+	TLS  UpstreamTLS `yaml:"tls"`
+	// GRPCService is service name sent in grpc.health.v1.Health/Check
+	GRPCService string `yaml:"grpc_service"`
+
+	Method  string            `yaml:"method" validate:"omitempty,oneof=GET HEAD POST OPTIONS"`
+	Headers map[string]string `yaml:"headers"`
+	// ExpectedStatus stores accepted status codes and ranges like "200-299"
+	ExpectedStatus []string `yaml:"expected_status"`
+	BodyContains   string   `yaml:"body_contains"`
+	BodyRegex      string   `yaml:"body_regex"`
+	// JSONPath is gjson path which must exist in response body,
+	// if JSONValue is set value by the path must be equal to it
+	JSONPath  string `yaml:"json_path"`
+	JSONValue string `yaml:"json_value"`
+	// Rise is count of consecutive successful probes to mark target healthy
+	Rise int `yaml:"rise" validate:"min=0"`
+	// Fall is count of consecutive failed probes to mark target unhealthy
+	Fall     int           `yaml:"fall" validate:"min=0"`
+	Timeout  time.Duration `yaml:"timeout" validate:"min=0"`
+	Interval time.Duration `yaml:"interval" validate:"min=0"`
+	Jitter   time.Duration `yaml:"jitter" validate:"min=0"`
+}
+
 type Target struct {
-	Url            string `yaml:"url" validate:"required,url"`
-	Weight         int    `yaml:"weight" validate:"min=1"`
-	HealthEndpoint string `yaml:"health_endpoint" validate:"omitempty"`
+	// Url is host:port or url with http, https, h2c or unix scheme
+	Url            string            `yaml:"url" validate:"required"`
+	Weight         int               `yaml:"weight" validate:"min=1"`
+	HealthEndpoint string            `yaml:"health_endpoint" validate:"omitempty"`
+	HealthCheck    HealthCheckConfig `yaml:"health_check"`
 }
 
 type TLS struct {
@@ -32,12 +109,298 @@ type TLS struct {
 	Key  string `yaml:"key" validate:"omitempty,required_with=Cert,file"`
 }
 
-type Gateway struct {
-	Prefix  string   `yaml:"prefix" validate:"required,startswith=/"`
+// HashConfig describes how iphash balancer builds the key of request
+type HashConfig struct {
+	// Key is source of the key: client ip, header, cookie or jwt claim
+	Key  string `yaml:"key" validate:"omitempty,oneof=ip header cookie claim"`
+	Name string `yaml:"name"`
+	// TrustedProxies stores ips and cidrs allowed to set X-Forwarded-For
+	TrustedProxies []string `yaml:"trusted_proxies" validate:"omitempty,dive,cidr|ip"`
+	// Replicas is count of virtual nodes for every target on the ring
+	Replicas int `yaml:"replicas" validate:"min=0"`
+}
+
+// SlowStartConfig describes ramp up of target weight after target becomes healthy
+type SlowStartConfig struct {
+	// Window is duration of ramp up, zero disables slow start
+	Window time.Duration `yaml:"window" validate:"omitempty,min=1s"`
+	// MinWeightPercent is share of weight given to target at the beginning of window,
+	// it is pointer so explicit zero is told apart from unset value
+	MinWeightPercent *int `yaml:"min_weight_percent" validate:"omitempty,min=0,max=100"`
+	// Aggression is curve of ramp up: 1 is linear, bigger values raise weight faster
+	// at the start of window, smaller ones keep it low longer
+	Aggression float64 `yaml:"aggression" validate:"min=0"`
+}
+
+// BalancerConfig stores load balancing settings of gateway,
+// empty values are taken from global config
+type BalancerConfig struct {
+	Alg  string     `yaml:"alg" validate:"omitempty,oneof=roundrobin rr wrr leastconn iphash p2c ewma"`
+	Hash HashConfig `yaml:"hash"`
+	// EWMADecay is time window of latency average for ewma balancer
+	EWMADecay time.Duration   `yaml:"ewma_decay" validate:"omitempty,min=1s"`
+	SlowStart SlowStartConfig `yaml:"slow_start"`
+}
+
+// OutlierConfig describes ejection of targets by results of live traffic,
+// zero thresholds disable corresponding check
+type OutlierConfig struct {
+	Consecutive5xx int `yaml:"consecutive_5xx" validate:"min=0"`
+	// ConsecutiveErrors counts connection errors to target
+	ConsecutiveErrors int `yaml:"consecutive_errors" validate:"min=0"`
+	// ConsecutiveSlow counts responses slower than LatencyFactor times
+	// median latency of other targets of gateway
+	ConsecutiveSlow int     `yaml:"consecutive_slow" validate:"min=0"`
+	LatencyFactor   float64 `yaml:"latency_factor" validate:"omitempty,gt=1"`
+	// MinLatency keeps jitter of fast targets from ejecting them,
+	// responses faster than it are never counted as slow
+	MinLatency time.Duration `yaml:"min_latency" validate:"min=0"`
+	// BaseEjection is doubled on every next ejection of the same target up to MaxEjection
+	BaseEjection      time.Duration `yaml:"base_ejection" validate:"min=0"`
+	MaxEjection       time.Duration `yaml:"max_ejection" validate:"min=0"`
+	MaxEjectedPercent int           `yaml:"max_ejected_percent" validate:"min=0,max=100"`
+}
+
+// CircuitBreakerConfig describes circuit breakers of gateway and each of its targets
+type CircuitBreakerConfig struct {
+	Use bool `yaml:"use"`
+	// ConsecutiveFailures opens breaker after so many failed requests in a row
+	ConsecutiveFailures int `yaml:"consecutive_failures" validate:"min=0"`
+	// ErrorRate opens breaker when percent of failed requests in Window is reached,
+	// it is checked only after MinRequests requests in Window
+	ErrorRate   int           `yaml:"error_rate" validate:"min=0,max=100"`
+	MinRequests int           `yaml:"min_requests" validate:"min=0"`
+	Window      time.Duration `yaml:"window" validate:"min=0"`
+	// OpenDuration is time before breaker lets probe requests through
+	OpenDuration time.Duration `yaml:"open_duration" validate:"min=0"`
+	// HalfOpenRequests is count of probe requests which must succeed to close breaker
+	HalfOpenRequests int `yaml:"half_open_requests" validate:"min=0"`
+}
+
+// RetryConfig describes retries of failed upstream requests
+type RetryConfig struct {
+	// Attempts is max count of attempts including the first one, 0 and 1 disable retries
+	Attempts int `yaml:"attempts" validate:"min=0,max=10"`
+	// On stores retried conditions: connect-error, timeout, 502, 503, 504
+	On            []string      `yaml:"on" validate:"omitempty,dive,oneof=connect-error timeout 502 503 504"`
+	PerTryTimeout time.Duration `yaml:"per_try_timeout" validate:"min=0"`
+	BackoffBase   time.Duration `yaml:"backoff_base" validate:"min=0"`
+	BackoffMax    time.Duration `yaml:"backoff_max" validate:"min=0"`
+	// NonIdempotent enables retries of POST and PATCH requests
+	NonIdempotent bool `yaml:"non_idempotent"`
+	// MaxBodySize limits request body buffered for replay, bigger requests are not retried
+	MaxBodySize int64 `yaml:"max_body_size" validate:"min=0"`
+}
+
+// RetryBudgetConfig limits retries of all gateways to share of requests
+type RetryBudgetConfig struct {
+	// Percent is max count of retries per 100 requests
+	Percent int `yaml:"percent" validate:"min=0,max=100"`
+	// MinPerSecond is count of retries allowed regardless of percent
+	MinPerSecond int `yaml:"min_per_second" validate:"min=0"`
+}
+
+// HedgeConfig describes hedged GET requests, copy of slow request is sent
+// to another target and the first response is used
+type HedgeConfig struct {
+	Use bool `yaml:"use"`
+	// Delay before copy of request is sent, when it is zero
+	// Percentile of observed latency of gateway is used
+	Delay      time.Duration `yaml:"delay" validate:"min=0"`
+	Percentile int           `yaml:"percentile" validate:"min=0,max=100"`
+	// Budget is max count of hedged requests per 100 requests
+	Budget int `yaml:"budget" validate:"min=0,max=100"`
+}
+
+// StreamConfig describes long-lived websocket and streaming connections,
+// request timeout of gateway is not applied to them
+type StreamConfig struct {
+	// FlushInterval is period of response flushes, negative value flushes
+	// after every write, event streams and responses of unknown length
+	// are always flushed immediately
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// IdleTimeout closes stream without traffic in both directions
+	IdleTimeout time.Duration `yaml:"idle_timeout" validate:"min=0"`
+	// MaxLifetime closes stream regardless of traffic, zero means no limit
+	MaxLifetime time.Duration `yaml:"max_lifetime" validate:"min=0"`
+	// MaxConcurrent limits open streams of gateway, zero means no limit
+	MaxConcurrent int `yaml:"max_concurrent" validate:"min=0"`
+}
+
+// GRPCConfig describes gateway of grpc targets, requests are sent over HTTP/2
+// and balanced one by one, so calls of one client connection are spread over targets
+type GRPCConfig struct {
+	Use bool `yaml:"use"`
+	// Web translates grpc-web requests of browsers to grpc
+	Web bool `yaml:"web"`
+	// Descriptor is descriptor set compiled with --include_imports, REST calls
+	// are transcoded to unary grpc calls by google.api.http rules of its methods
+	Descriptor string `yaml:"descriptor" validate:"omitempty,file"`
+	// MaxBodySize limits JSON body of transcoded REST call and response of grpc target to it
+	MaxBodySize int64 `yaml:"max_body_size" validate:"min=0"`
+}
+
+// TimeoutConfig describes timeouts of requests to targets of gateway
+type TimeoutConfig struct {
+	// Connect limits dialing of target
+	Connect time.Duration `yaml:"connect" validate:"min=0"`
+	// ResponseHeader limits waiting for response headers after request is sent
+	ResponseHeader time.Duration `yaml:"response_header" validate:"min=0"`
+	// Idle is time after which unused connection to target is closed
+	Idle time.Duration `yaml:"idle" validate:"min=0"`
+	// Request is deadline of the whole request including retries, request_timeout by default
+	Request time.Duration `yaml:"request" validate:"min=0"`
+}
+
+// TransportConfig describes pool of connections to targets of gateway,
+// idle connections are closed after timeout.idle
+type TransportConfig struct {
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host" validate:"min=0"`
+	// KeepAlive is interval of tcp keep-alive probes, negative disables them
+	KeepAlive time.Duration `yaml:"keep_alive"`
+	// HTTP2 makes gateway speak HTTP/2 to targets, plain text targets must support h2c
+	HTTP2 bool `yaml:"http2"`
+	// DNSRefresh is interval after which idle connections are closed,
+	// so names of targets are resolved again, zero disables it
+	DNSRefresh time.Duration `yaml:"dns_refresh" validate:"min=0"`
+}
+
+// RewriteConfig describes how path of request is changed before proxying,
+// part of path matched by route is stripped first, then regex or path template is applied
+// and add_prefix is prepended to the result
+type RewriteConfig struct {
+	StripPrefix bool   `yaml:"strip_prefix"`
+	AddPrefix   string `yaml:"add_prefix" validate:"omitempty,startswith=/"`
+	// Regex is matched against path, Replacement may use $1 and ${name} groups
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+	// Path is template of new path, {name} is replaced with named group of regex
+	// or route parameter and {path} with path after stripping
+	Path string `yaml:"path" validate:"omitempty,startswith=/"`
+}
+
+// HeaderRules describes changes of headers, values are templates with
+// {client_ip}, {route}, {request_id}, {claim.name} and {env.NAME} placeholders
+type HeaderRules struct {
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+	Remove []string          `yaml:"remove"`
+}
+
+// ForwardedConfig describes X-Forwarded-* headers sent to targets
+type ForwardedConfig struct {
+	// Mode is append, overwrite or off, in append mode headers are
+	// kept only when request came from trusted proxy
+	Mode           string   `yaml:"mode" validate:"omitempty,oneof=append overwrite off"`
+	TrustedProxies []string `yaml:"trusted_proxies" validate:"omitempty,dive,cidr|ip"`
+}
+
+// HeadersConfig describes headers policy, global rules are applied before rules of gateway
+type HeadersConfig struct {
+	Request   HeaderRules     `yaml:"request"`
+	Response  HeaderRules     `yaml:"response"`
+	Forwarded ForwardedConfig `yaml:"forwarded"`
+	// HopByHop stores extra hop-by-hop headers removed from requests and responses,
+	// standard ones and ones listed in Connection are always removed
+	HopByHop []string `yaml:"hop_by_hop"`
+}
+
+// MatchConfig describes route of gateway besides prefix, every set predicate must match
+type MatchConfig struct {
+	// Path matches the whole path, like prefix it may contain {name} and * segments
+	Path string `yaml:"path" validate:"omitempty,startswith=/"`
+	// PathRegex is anchored at the beginning of path, named groups become route parameters
+	PathRegex string `yaml:"path_regex"`
+	// Hosts are matched with Host header and SNI with server name of tls connection,
+	// "*.example.com" matches any subdomain
+	Hosts   []string `yaml:"hosts"`
+	SNI     []string `yaml:"sni"`
+	Methods []string `yaml:"methods" validate:"omitempty,dive,oneof=GET HEAD POST PUT PATCH DELETE OPTIONS CONNECT TRACE"`
+	// Headers and Query store required values, "*" requires only presence
+	Headers map[string]string `yaml:"headers"`
+	Query   map[string]string `yaml:"query"`
+	// Priority orders routes, routes with bigger priority are matched first
+	Priority int `yaml:"priority"`
+}
+
+// MirrorConfig describes copying of requests to shadow targets, responses
+// of shadow targets are discarded and only compared with primary ones
+type MirrorConfig struct {
+	Use bool `yaml:"use"`
+	// Targets stores urls of shadow targets, copies are spread between them by round robin
+	Targets []string `yaml:"targets"`
+	// Percent is share of requests copied to shadow targets
+	Percent int `yaml:"percent" validate:"min=0,max=100"`
+	// MaxBodySize limits copied request body, requests with bigger body are not mirrored
+	MaxBodySize int64 `yaml:"max_body_size" validate:"min=0"`
+	// Timeout limits shadow request, request timeout of gateway is used by default
+	Timeout time.Duration `yaml:"timeout" validate:"min=0"`
+	// MaxInFlight limits shadow requests in progress, requests over limit are not mirrored
+	MaxInFlight int `yaml:"max_in_flight" validate:"min=0"`
+	// LogMismatch logs requests which shadow response status differs from primary one
+	LogMismatch bool `yaml:"log_mismatch"`
+}
+
+// PoolConfig is named group of targets of gateway with its own balancer and health state
+type PoolConfig struct {
+	Name    string   `yaml:"name" validate:"required"`
 	Targets []Target `yaml:"targets" validate:"min=1,dive"`
-	Auth    bool     `yaml:"auth"`
-	Cache   bool     `yaml:"cache"`
-	Rate    bool     `yaml:"rate"`
+	// Weight is percent of requests sent to pool, weights of pools sum to 100
+	Weight int `yaml:"weight" validate:"min=0,max=100"`
+	// Balancer of gateway is used when alg is not set
+	Balancer BalancerConfig `yaml:"balancer"`
+}
+
+// SplitRule sends matching requests to pool regardless of weights
+type SplitRule struct {
+	Pool string `yaml:"pool" validate:"required"`
+	// Source is where value is read from: header, cookie or jwt claim
+	Source string `yaml:"source" validate:"oneof=header cookie claim"`
+	Name   string `yaml:"name" validate:"required"`
+	// Values stores accepted values, any non empty value matches when it is empty
+	Values []string `yaml:"values"`
+}
+
+// SplitConfig describes how requests are divided between pools of gateway
+type SplitConfig struct {
+	// Sticky keeps client in the same pool, key of client is built
+	// like hash key of balancer from ip, header, cookie or jwt claim
+	Sticky bool   `yaml:"sticky"`
+	Key    string `yaml:"key" validate:"omitempty,oneof=ip header cookie claim"`
+	Name   string `yaml:"name"`
+	// Rules are checked in order before weights
+	Rules []SplitRule `yaml:"rules" validate:"omitempty,dive"`
+}
+
+type Gateway struct {
+	// Name identifies gateway in logs and metrics, it is prefix or path of route by default
+	Name    string      `yaml:"name"`
+	Prefix  string      `yaml:"prefix" validate:"omitempty,startswith=/"`
+	Match   MatchConfig `yaml:"match"`
+	Targets []Target    `yaml:"targets" validate:"omitempty,dive"`
+	// Pools replace targets when traffic is split between groups of targets
+	Pools    []PoolConfig   `yaml:"pools" validate:"omitempty,dive"`
+	Split    SplitConfig    `yaml:"split"`
+	Auth     bool           `yaml:"auth"`
+	Cache    bool           `yaml:"cache"`
+	Rate     bool           `yaml:"rate"`
+	Balancer BalancerConfig `yaml:"balancer"`
+	Outlier  OutlierConfig  `yaml:"outlier"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	Retry          RetryConfig          `yaml:"retry"`
+	Hedge          HedgeConfig          `yaml:"hedge"`
+	Timeout        TimeoutConfig        `yaml:"timeout"`
+	Transport      TransportConfig      `yaml:"transport"`
+	// TLS is used for connections to https targets of gateway
+	TLS     UpstreamTLS   `yaml:"tls"`
+	Rewrite RewriteConfig `yaml:"rewrite"`
+	Headers HeadersConfig `yaml:"headers"`
+	Mirror  MirrorConfig  `yaml:"mirror"`
+	Stream  StreamConfig  `yaml:"stream"`
+	GRPC    GRPCConfig    `yaml:"grpc"`
+	// Aggregate replaces targets, gateway answers with document merged from responses of calls
+	Aggregate AggregateConfig `yaml:"aggregate"`
 }
 
 type WafConfig struct {
@@ -65,13 +428,16 @@ type Config struct {
 	Addr               string             `yaml:"addr" env:"GATEWAY_ADDR"`
 	Proto              string             `yaml:"proto" env:"GATEWAY_PROTO" validate:"oneof=http http3"`
 	RequestTimeout     time.Duration      `yaml:"request_timeout" env:"GATEWAY_REQ_TIMEOUT" validate:"min=1s"`
-	LoadBalancerAlg    string             `yaml:"balancer" env:"GATEWAY_BALANCER" validate:"oneof=roundrobin wrr leastconn iphash"`
+	ShutdownTimeout    time.Duration      `yaml:"shutdown_timeout" env:"GATEWAY_SHUTDOWN_TIMEOUT" validate:"min=0"`
+	LoadBalancerAlg    string             `yaml:"balancer" env:"GATEWAY_BALANCER" validate:"oneof=roundrobin rr wrr leastconn iphash p2c ewma"`
 	TLS                TLS                `yaml:"tls"`
 	WAF                WafConfig          `yaml:"waf"`
 	AuthConfig         AuthConfig         `yaml:"auth"`
 	HealthCheckTimeout time.Duration      `yaml:"hc_timeout" env:"GATEWAY_HC_TIMEOUT" validate:"min=1s"`
 	CORS               CORSConfig         `yaml:"cors"`
 	RateLimiting       RateLimitingConfig `yaml:"rate_limiting"`
+	RetryBudget        RetryBudgetConfig  `yaml:"retry_budget"`
+	Headers            HeadersConfig      `yaml:"headers"`
 	Gateways           []Gateway          `yaml:"gateways"`
 
 	filePath string
@@ -98,6 +464,8 @@ func NewConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse env variables: %v", err)
 	}
 
+	cfg.applyGatewayDefaults()
+
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %v", err)
 	}
@@ -115,6 +483,9 @@ func (c *Config) applyDefaults() {
 	if c.RequestTimeout == 0 {
 		c.RequestTimeout = DefaultRequestTimeout
 	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = DefaultShutdownTimeout
+	}
 	if c.HealthCheckTimeout == 0 {
 		c.HealthCheckTimeout = DefaultHealthCheckTimeout
 	}
@@ -127,6 +498,224 @@ func (c *Config) applyDefaults() {
 	if c.CORS.MaxAge == 0 {
 		c.CORS.MaxAge = DefaultCORSMaxAge
 	}
+	if c.RetryBudget.Percent == 0 {
+		c.RetryBudget.Percent = DefaultRetryBudgetPercent
+	}
+	if c.RetryBudget.MinPerSecond == 0 {
+		c.RetryBudget.MinPerSecond = DefaultRetryBudgetMinRPS
+	}
+	if c.Headers.Forwarded.Mode == "" {
+		c.Headers.Forwarded.Mode = DefaultForwardedMode
+	}
+}
+
+// applyGatewayDefaults fills gateway settings from global ones,
+// so it must be called after env variables are parsed
+func (c *Config) applyGatewayDefaults() {
+	for i := range c.Gateways {
+		if gateway := &c.Gateways[i]; gateway.Name == "" {
+			gateway.Name = defaultName(gateway)
+		}
+
+		balancer := &c.Gateways[i].Balancer
+		balancer.applyDefaults(c.LoadBalancerAlg)
+
+		// targets of grpc gateway speak only HTTP/2 and are probed by grpc health service
+		grpc := c.Gateways[i].GRPC.Use
+		if grpc {
+			c.Gateways[i].Transport.HTTP2 = true
+		}
+
+		if g := &c.Gateways[i].GRPC; g.MaxBodySize == 0 {
+			g.MaxBodySize = DefaultTranscodeMaxBody
+		}
+
+		for j := range c.Gateways[i].Pools {
+			pool := &c.Gateways[i].Pools[j]
+
+			if pool.Balancer.Alg == "" {
+				pool.Balancer = *balancer
+			} else {
+				pool.Balancer.applyDefaults(balancer.Alg)
+			}
+
+			for k := range pool.Targets {
+				pool.Targets[k].HealthCheck.applyDefaults(c.HealthCheckTimeout, grpc)
+			}
+		}
+
+		if split := &c.Gateways[i].Split; split.Key == "" {
+			split.Key = "ip"
+		}
+
+		outlier := &c.Gateways[i].Outlier
+		if outlier.BaseEjection == 0 {
+			outlier.BaseEjection = DefaultBaseEjection
+		}
+		if outlier.MaxEjection == 0 {
+			outlier.MaxEjection = max(DefaultMaxEjection, outlier.BaseEjection)
+		}
+		if outlier.MaxEjectedPercent == 0 {
+			outlier.MaxEjectedPercent = DefaultMaxEjectedPercent
+		}
+		if outlier.LatencyFactor == 0 {
+			outlier.LatencyFactor = DefaultLatencyFactor
+		}
+
+		if breaker := &c.Gateways[i].CircuitBreaker; breaker.Use {
+			if breaker.ConsecutiveFailures == 0 && breaker.ErrorRate == 0 {
+				breaker.ConsecutiveFailures = DefaultBreakerFailures
+				breaker.ErrorRate = DefaultBreakerErrorRate
+			}
+			if breaker.MinRequests == 0 {
+				breaker.MinRequests = DefaultBreakerMinRequests
+			}
+			if breaker.Window == 0 {
+				breaker.Window = DefaultBreakerWindow
+			}
+			if breaker.OpenDuration == 0 {
+				breaker.OpenDuration = DefaultBreakerOpen
+			}
+			if breaker.HalfOpenRequests == 0 {
+				breaker.HalfOpenRequests = DefaultBreakerHalfOpen
+			}
+		}
+
+		if retry := &c.Gateways[i].Retry; retry.Attempts > 1 {
+			if len(retry.On) == 0 {
+				retry.On = []string{"connect-error", "502", "503", "504"}
+			}
+			if retry.BackoffBase == 0 {
+				retry.BackoffBase = DefaultRetryBackoffBase
+			}
+			if retry.BackoffMax == 0 {
+				retry.BackoffMax = max(DefaultRetryBackoffMax, retry.BackoffBase)
+			}
+			if retry.MaxBodySize == 0 {
+				retry.MaxBodySize = DefaultRetryMaxBodySize
+			}
+		}
+
+		timeout := &c.Gateways[i].Timeout
+		if timeout.Connect == 0 {
+			timeout.Connect = DefaultConnectTimeout
+		}
+		if timeout.Idle == 0 {
+			timeout.Idle = DefaultIdleConnTimeout
+		}
+		if timeout.Request == 0 {
+			timeout.Request = c.RequestTimeout
+		}
+
+		forwarded := &c.Gateways[i].Headers.Forwarded
+		if forwarded.Mode == "" {
+			forwarded.Mode = c.Headers.Forwarded.Mode
+		}
+		if len(forwarded.TrustedProxies) == 0 {
+			forwarded.TrustedProxies = c.Headers.Forwarded.TrustedProxies
+		}
+
+		transport := &c.Gateways[i].Transport
+		if transport.MaxIdleConnsPerHost == 0 {
+			transport.MaxIdleConnsPerHost = DefaultMaxIdleConns
+		}
+		if transport.KeepAlive == 0 {
+			transport.KeepAlive = DefaultKeepAlive
+		}
+
+		if hedge := &c.Gateways[i].Hedge; hedge.Use {
+			if hedge.Percentile == 0 {
+				hedge.Percentile = DefaultHedgePercentile
+			}
+			if hedge.Budget == 0 {
+				hedge.Budget = DefaultHedgeBudget
+			}
+		}
+
+		if stream := &c.Gateways[i].Stream; stream.IdleTimeout == 0 {
+			stream.IdleTimeout = DefaultStreamIdleTimeout
+		}
+
+		if mirror := &c.Gateways[i].Mirror; mirror.Use {
+			if mirror.Percent == 0 {
+				mirror.Percent = DefaultMirrorPercent
+			}
+			if mirror.MaxBodySize == 0 {
+				mirror.MaxBodySize = DefaultMirrorMaxBodySize
+			}
+			if mirror.Timeout == 0 {
+				mirror.Timeout = timeout.Request
+			}
+			if mirror.MaxInFlight == 0 {
+				mirror.MaxInFlight = DefaultMirrorMaxInFlight
+			}
+		}
+
+		if aggregate := &c.Gateways[i].Aggregate; aggregate.MaxBodySize == 0 {
+			aggregate.MaxBodySize = DefaultAggregateMaxBody
+		}
+
+		for j := range c.Gateways[i].Aggregate.Calls {
+			if call := &c.Gateways[i].Aggregate.Calls[j]; call.Method == "" {
+				call.Method = "GET"
+			}
+		}
+
+		for j := range c.Gateways[i].Targets {
+			c.Gateways[i].Targets[j].HealthCheck.applyDefaults(c.HealthCheckTimeout, grpc)
+		}
+	}
+}
+
+func (b *BalancerConfig) applyDefaults(alg string) {
+	if b.Alg == "" {
+		b.Alg = alg
+	}
+	if b.Hash.Key == "" {
+		b.Hash.Key = "ip"
+	}
+	if b.Hash.Replicas == 0 {
+		b.Hash.Replicas = DefaultHashReplicas
+	}
+	if b.EWMADecay == 0 {
+		b.EWMADecay = DefaultEWMADecay
+	}
+	if b.SlowStart.Window > 0 {
+		if b.SlowStart.MinWeightPercent == nil {
+			minWeight := DefaultSlowStartMinWeight
+			b.SlowStart.MinWeightPercent = &minWeight
+		}
+		if b.SlowStart.Aggression == 0 {
+			b.SlowStart.Aggression = DefaultSlowStartAggr
+		}
+	}
+}
+
+func (hc *HealthCheckConfig) applyDefaults(interval time.Duration, grpc bool) {
+	if hc.Type == "" && grpc {
+		hc.Type = "grpc"
+	}
+	if hc.Type == "" {
+		hc.Type = "http"
+	}
+	if hc.Method == "" {
+		hc.Method = "GET"
+	}
+	if len(hc.ExpectedStatus) == 0 {
+		hc.ExpectedStatus = []string{"200-299"}
+	}
+	if hc.Rise == 0 {
+		hc.Rise = DefaultHealthCheckRise
+	}
+	if hc.Fall == 0 {
+		hc.Fall = DefaultHealthCheckFall
+	}
+	if hc.Interval == 0 {
+		hc.Interval = interval
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = min(DefaultProbeTimeout, hc.Interval)
+	}
 }
 
 func (c *Config) Validate() error {
@@ -142,9 +731,62 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("tls.cert and tls.key are required for https")
 	}
 
+	if err := validateRoutes(c.Gateways); err != nil {
+		return err
+	}
+
 	for _, g := range c.Gateways {
 		if g.Auth && c.AuthConfig.Key == "" {
-			return fmt.Errorf("auth.key is required when auth=true in gateway %s", g.Prefix)
+			return fmt.Errorf("auth.key is required when auth=true in gateway %s", g.Name)
+		}
+
+		if err := validateClaims(&g); err != nil {
+			return fmt.Errorf("invalid gateway %s: %v", g.Name, err)
+		}
+
+		if len(g.Targets) == 0 && len(g.Pools) == 0 && !g.IsAggregate() {
+			return fmt.Errorf("targets or pools are required in gateway %s", g.Name)
+		}
+
+		for _, t := range g.AllTargets() {
+			if _, err := ParseEndpoint(t.Url); err != nil {
+				return fmt.Errorf("invalid target in gateway %s: %v", g.Name, err)
+			}
+
+			if err := validateHealthCheck(&t.HealthCheck); err != nil {
+				return fmt.Errorf("invalid health_check of target %s in gateway %s: %v", t.Url, g.Name, err)
+			}
+		}
+
+		if err := validateRewrite(&g.Rewrite); err != nil {
+			return fmt.Errorf("invalid rewrite in gateway %s: %v", g.Name, err)
+		}
+
+		hash := g.Balancer.Hash
+		if hash.Key != "" && hash.Key != "ip" && hash.Name == "" {
+			return fmt.Errorf("balancer.hash.name is required for balancer.hash.key=%s in gateway %s", hash.Key, g.Name)
+		}
+
+		if (g.GRPC.Web || g.GRPC.Descriptor != "") && !g.GRPC.Use {
+			return fmt.Errorf("grpc.use is required for grpc.web and grpc.descriptor in gateway %s", g.Name)
+		}
+
+		if g.Mirror.Use && len(g.Mirror.Targets) == 0 {
+			return fmt.Errorf("mirror.targets are required when mirror.use=true in gateway %s", g.Name)
+		}
+
+		for _, url := range g.Mirror.Targets {
+			if _, err := ParseEndpoint(url); err != nil {
+				return fmt.Errorf("invalid mirror target in gateway %s: %v", g.Name, err)
+			}
+		}
+
+		if err := validatePools(&g); err != nil {
+			return fmt.Errorf("invalid pools in gateway %s: %v", g.Name, err)
+		}
+
+		if err := validateAggregate(&g); err != nil {
+			return fmt.Errorf("invalid aggregate in gateway %s: %v", g.Name, err)
 		}
 	}
 
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBalancerDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		global  string
+		gateway BalancerConfig
+		pool    BalancerConfig
+		// want and wantPool are algorithms of gateway and its pool
+		want     string
+		wantPool string
+	}{
+		{
+			name:     "global algorithm",
+			global:   "leastconn",
+			want:     "leastconn",
+			wantPool: "leastconn",
+		},
+		{
+			name:     "gateway algorithm",
+			global:   "leastconn",
+			gateway:  BalancerConfig{Alg: "ewma"},
+			want:     "ewma",
+			wantPool: "ewma",
+		},
+		{
+			name:     "pool algorithm",
+			global:   "leastconn",
+			gateway:  BalancerConfig{Alg: "ewma"},
+			pool:     BalancerConfig{Alg: "iphash"},
+			want:     "ewma",
+			wantPool: "iphash",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				LoadBalancerAlg: tt.global,
+				Gateways: []Gateway{{
+					Prefix:   "/test",
+					Balancer: tt.gateway,
+					Pools: []PoolConfig{{
+						Name:     "stable",
+						Targets:  []Target{{Url: "http://a"}},
+						Weight:   100,
+						Balancer: tt.pool,
+					}},
+				}},
+			}
+
+			cfg.applyGatewayDefaults()
+
+			gateway := cfg.Gateways[0]
+			if gateway.Balancer.Alg != tt.want {
+				t.Errorf("gateway alg %s, want %s", gateway.Balancer.Alg, tt.want)
+			}
+
+			pool := gateway.Pools[0].Balancer
+			if pool.Alg != tt.wantPool {
+				t.Errorf("pool alg %s, want %s", pool.Alg, tt.wantPool)
+			}
+
+			if pool.Hash.Replicas != DefaultHashReplicas || pool.EWMADecay != DefaultEWMADecay {
+				t.Errorf("pool balancer defaults are not set: %+v", pool)
+			}
+		})
+	}
+}
+
+func TestSlowStartDefaults(t *testing.T) {
+	zero := 0
+
+	tests := []struct {
+		name      string
+		slowStart SlowStartConfig
+		want      int
+	}{
+		{name: "unset minimum weight", slowStart: SlowStartConfig{Window: time.Minute}, want: DefaultSlowStartMinWeight},
+		{name: "zero minimum weight", slowStart: SlowStartConfig{Window: time.Minute, MinWeightPercent: &zero}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := BalancerConfig{SlowStart: tt.slowStart}
+			b.applyDefaults("roundrobin")
+
+			if got := b.SlowStart.MinWeightPercent; got == nil || *got != tt.want {
+				t.Errorf("minimum weight %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Endpoint is address of target parsed from its url,
+// bare host:port is treated as http target
+type Endpoint struct {
+	// Scheme is http, https, h2c or unix
+	Scheme string
+	// Host is host:port of target, it is localhost for unix socket
+	Host string
+	// Socket is path of unix socket
+	Socket string
+}
+
+// ParseEndpoint parses url of target, host may contain {id} placeholder
+func ParseEndpoint(raw string) (Endpoint, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		scheme, rest = "http", raw
+	}
+
+	switch scheme {
+	case "http", "https", "h2c":
+		// path of target url is not used, requests keep their own path
+		host, _, _ := strings.Cut(rest, "/")
+		if host == "" {
+			return Endpoint{}, fmt.Errorf("host is empty in target url %s", raw)
+		}
+
+		return Endpoint{Scheme: scheme, Host: host}, nil
+	case "unix":
+		if !strings.HasPrefix(rest, "/") {
+			return Endpoint{}, fmt.Errorf("socket path must be absolute in target url %s", raw)
+		}
+
+		return Endpoint{Scheme: scheme, Host: "localhost", Socket: rest}, nil
+	default:
+		return Endpoint{}, fmt.Errorf("unknown scheme %s in target url %s", scheme, raw)
+	}
+}
+
+// URLScheme returns scheme of requests sent to endpoint
+func (e Endpoint) URLScheme() string {
+	if e.Scheme == "https" {
+		return "https"
+	}
+
+	return "http"
+}
+
+// Addr returns network and address used to dial endpoint
+func (e Endpoint) Addr() (string, string) {
+	if e.Scheme == "unix" {
+		return "unix", e.Socket
+	}
+
+	return "tcp", e.Host
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// AllTargets returns targets of gateway and targets of its pools
+func (g *Gateway) AllTargets() []Target {
+	targets := g.Targets
+	for _, pool := range g.Pools {
+		targets = slices.Concat(targets, pool.Targets)
+	}
+
+	return targets
+}
+
+// PoolWeights returns weights of pools by name
+func (g *Gateway) PoolWeights() map[string]int {
+	weights := make(map[string]int, len(g.Pools))
+	for _, pool := range g.Pools {
+		weights[pool.Name] = pool.Weight
+	}
+
+	return weights
+}
+
+func validatePools(g *Gateway) error {
+	if len(g.Pools) == 0 {
+		if len(g.Split.Rules) > 0 {
+			return fmt.Errorf("split rules require pools")
+		}
+
+		return nil
+	}
+
+	if len(g.Targets) > 0 {
+		return fmt.Errorf("targets and pools can not be used together")
+	}
+
+	names := make(map[string]bool, len(g.Pools))
+	for _, pool := range g.Pools {
+		if names[pool.Name] {
+			return fmt.Errorf("duplicate pool name %s", pool.Name)
+		}
+
+		names[pool.Name] = true
+	}
+
+	if err := ValidatePoolWeights(g.Pools, g.PoolWeights()); err != nil {
+		return err
+	}
+
+	for _, rule := range g.Split.Rules {
+		if !slices.ContainsFunc(g.Pools, func(pool PoolConfig) bool { return pool.Name == rule.Pool }) {
+			return fmt.Errorf("split rule refers to unknown pool %s", rule.Pool)
+		}
+	}
+
+	for _, pool := range g.Pools {
+		hash := pool.Balancer.Hash
+		if hash.Key != "" && hash.Key != "ip" && hash.Name == "" {
+			return fmt.Errorf("balancer.hash.name is required for balancer.hash.key=%s in pool %s", hash.Key, pool.Name)
+		}
+	}
+
+	if g.Split.Sticky && g.Split.Key != "ip" && g.Split.Name == "" {
+		return fmt.Errorf("split.name is required for split.key=%s", g.Split.Key)
+	}
+
+	return nil
+}
+
+// ValidatePoolWeights checks that weights are set for every pool and sum to 100
+func ValidatePoolWeights(pools []PoolConfig, weights map[string]int) error {
+	if len(weights) != len(pools) {
+		return fmt.Errorf("weights must be set for every pool")
+	}
+
+	sum := 0
+	for _, pool := range pools {
+		weight, ok := weights[pool.Name]
+		if !ok {
+			return fmt.Errorf("weight of pool %s is not set", pool.Name)
+		}
+
+		if weight < 0 {
+			return fmt.Errorf("weight of pool %s is negative", pool.Name)
+		}
+
+		sum += weight
+	}
+
+	if sum != 100 {
+		return fmt.Errorf("weights of pools sum to %d instead of 100", sum)
+	}
+
+	return nil
+}
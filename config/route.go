@@ -0,0 +1,242 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// paramName matches name of {name} path segment
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type (
+	// PathSegment is one segment of path pattern
+	PathSegment struct {
+		// Literal is matched as is when segment is neither parameter nor wildcard
+		Literal string
+		// Param is name of {name} segment, it matches any single segment
+		Param    string
+		Wildcard bool
+	}
+
+	// PathPattern is parsed prefix or path of route
+	PathPattern []PathSegment
+)
+
+// ParsePathPattern splits path into segments, empty segments are skipped
+func ParsePathPattern(path string) (PathPattern, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %s must start with /", path)
+	}
+
+	var pattern PathPattern
+
+	params := make(map[string]bool)
+
+	for _, part := range strings.Split(path, "/") {
+		switch {
+		case part == "":
+			continue
+		case part == "*":
+			pattern = append(pattern, PathSegment{Wildcard: true})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if !paramName.MatchString(name) {
+				return nil, fmt.Errorf("invalid parameter %s in path %s", part, path)
+			}
+
+			if params[name] {
+				return nil, fmt.Errorf("duplicate parameter %s in path %s", part, path)
+			}
+
+			params[name] = true
+			pattern = append(pattern, PathSegment{Param: name})
+		case strings.ContainsAny(part, "{}*"):
+			return nil, fmt.Errorf("segment %s of path %s must be literal, {name} or *", part, path)
+		default:
+			pattern = append(pattern, PathSegment{Literal: part})
+		}
+	}
+
+	return pattern, nil
+}
+
+// String returns pattern with unnamed parameters, so patterns
+// which differ only by names of parameters are equal
+func (p PathPattern) String() string {
+	var sb strings.Builder
+
+	for _, segment := range p {
+		sb.WriteByte('/')
+
+		switch {
+		case segment.Wildcard:
+			sb.WriteByte('*')
+		case segment.Param != "":
+			sb.WriteString("{}")
+		default:
+			sb.WriteString(segment.Literal)
+		}
+	}
+
+	if sb.Len() == 0 {
+		return "/"
+	}
+
+	return sb.String()
+}
+
+// defaultName names gateway by its route, hosts and methods are included,
+// so gateways which share path and differ by them get distinct names
+func defaultName(g *Gateway) string {
+	name := cmp.Or(g.Prefix, g.Match.Path, g.Match.PathRegex)
+
+	if len(g.Match.Hosts) > 0 {
+		name = strings.Join(g.Match.Hosts, ",") + name
+	}
+
+	if len(g.Match.Methods) > 0 {
+		name = strings.Join(g.Match.Methods, ",") + " " + name
+	}
+
+	return name
+}
+
+// validateRoutes checks routes of gateways and reports ones which match
+// the same requests with the same priority, since only order of gateways
+// in config would choose between them. Overlapping regular expressions
+// are not detected
+func validateRoutes(gateways []Gateway) error {
+	keys := make([]string, len(gateways))
+	names := make(map[string]bool, len(gateways))
+
+	for i := range gateways {
+		g := &gateways[i]
+
+		key, err := routeKey(g)
+		if err != nil {
+			return fmt.Errorf("invalid route of gateway %s: %v", g.Name, err)
+		}
+
+		if names[g.Name] {
+			return fmt.Errorf("duplicate gateway name %s, set name of gateways which share route", g.Name)
+		}
+
+		names[g.Name] = true
+
+		for j := 0; j < i; j++ {
+			other := &gateways[j]
+
+			if keys[j] == key &&
+				overlaps(g.Match.Hosts, other.Match.Hosts) &&
+				overlaps(g.Match.SNI, other.Match.SNI) &&
+				overlaps(g.Match.Methods, other.Match.Methods) {
+				return fmt.Errorf("route of gateway %s conflicts with gateway %s, set different match.priority", g.Name, other.Name)
+			}
+		}
+
+		keys[i] = key
+	}
+
+	return nil
+}
+
+// routeKey validates route of gateway and returns key which is
+// equal for routes with the same path and predicates of values
+func routeKey(g *Gateway) (string, error) {
+	match := &g.Match
+
+	var path string
+
+	switch {
+	case countSet(g.Prefix, match.Path, match.PathRegex) != 1:
+		return "", fmt.Errorf("exactly one of prefix, match.path and match.path_regex is required")
+	case g.Prefix != "":
+		pattern, err := ParsePathPattern(g.Prefix)
+		if err != nil {
+			return "", err
+		}
+
+		path = "prefix " + pattern.String()
+	case match.Path != "":
+		pattern, err := ParsePathPattern(match.Path)
+		if err != nil {
+			return "", err
+		}
+
+		path = "path " + pattern.String()
+	default:
+		if _, err := regexp.Compile(match.PathRegex); err != nil {
+			return "", fmt.Errorf("invalid path_regex: %v", err)
+		}
+
+		path = "regex " + match.PathRegex
+	}
+
+	for _, host := range slices.Concat(match.Hosts, match.SNI) {
+		if err := validateHostPattern(host); err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%d %s %s %s", match.Priority, path,
+		predicatesKey(match.Headers, strings.ToLower),
+		predicatesKey(match.Query, nil)), nil
+}
+
+func validateHostPattern(host string) error {
+	if host == "" || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return fmt.Errorf("invalid host %q, only leading \"*.\" wildcard is allowed", host)
+	}
+
+	return nil
+}
+
+// predicatesKey returns sorted predicates, normalize is applied to names when set
+func predicatesKey(predicates map[string]string, normalize func(string) string) string {
+	pairs := make([]string, 0, len(predicates))
+
+	for name, value := range predicates {
+		if normalize != nil {
+			name = normalize(name)
+		}
+
+		pairs = append(pairs, name+"="+value)
+	}
+
+	slices.Sort(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+// overlaps returns true when both lists are empty or have common value,
+// route with values is more specific than route without them
+func overlaps(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+
+	for _, value := range a {
+		if slices.ContainsFunc(b, func(other string) bool {
+			return strings.EqualFold(value, other)
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func countSet(values ...string) int {
+	count := 0
+
+	for _, value := range values {
+		if value != "" {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRoutes(t *testing.T) {
+	tests := []struct {
+		name     string
+		gateways []Gateway
+		// wantErr is part of error, empty when routes are valid
+		wantErr string
+	}{
+		{
+			name: "different prefixes",
+			gateways: []Gateway{
+				{Name: "users", Prefix: "/users"},
+				{Name: "orders", Prefix: "/orders"},
+			},
+		},
+		{
+			name: "same prefix",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/users"},
+				{Name: "b", Prefix: "/users/"},
+			},
+			wantErr: "route of gateway b conflicts with gateway a",
+		},
+		{
+			name: "params differ only by name",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/users/{id}"},
+				{Name: "b", Prefix: "/users/{name}"},
+			},
+			wantErr: "conflicts",
+		},
+		{
+			name: "different priority",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/users"},
+				{Name: "b", Prefix: "/users", Match: MatchConfig{Priority: 1}},
+			},
+		},
+		{
+			name: "prefix and exact path",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/users"},
+				{Name: "b", Match: MatchConfig{Path: "/users"}},
+			},
+		},
+		{
+			name: "different methods",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/users", Match: MatchConfig{Methods: []string{"GET"}}},
+				{Name: "b", Prefix: "/users", Match: MatchConfig{Methods: []string{"POST"}}},
+			},
+		},
+		{
+			name: "overlapping methods",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/users", Match: MatchConfig{Methods: []string{"GET", "POST"}}},
+				{Name: "b", Prefix: "/users", Match: MatchConfig{Methods: []string{"POST"}}},
+			},
+			wantErr: "conflicts",
+		},
+		{
+			name: "route with methods is more specific",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/users"},
+				{Name: "b", Prefix: "/users", Match: MatchConfig{Methods: []string{"POST"}}},
+			},
+		},
+		{
+			name: "hosts differ by case",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/", Match: MatchConfig{Hosts: []string{"API.example.com"}}},
+				{Name: "b", Prefix: "/", Match: MatchConfig{Hosts: []string{"api.example.com"}}},
+			},
+			wantErr: "conflicts",
+		},
+		{
+			name: "headers differ by name case",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/", Match: MatchConfig{Headers: map[string]string{"X-Beta": "1"}}},
+				{Name: "b", Prefix: "/", Match: MatchConfig{Headers: map[string]string{"x-beta": "1"}}},
+			},
+			wantErr: "conflicts",
+		},
+		{
+			name: "different query",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/", Match: MatchConfig{Query: map[string]string{"v": "1"}}},
+				{Name: "b", Prefix: "/", Match: MatchConfig{Query: map[string]string{"v": "2"}}},
+			},
+		},
+		{
+			name: "duplicate name",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/users"},
+				{Name: "a", Prefix: "/orders"},
+			},
+			wantErr: "duplicate gateway name a",
+		},
+		{
+			name: "prefix and path",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/users", Match: MatchConfig{Path: "/users"}},
+			},
+			wantErr: "exactly one of prefix",
+		},
+		{
+			name: "invalid host",
+			gateways: []Gateway{
+				{Name: "a", Prefix: "/", Match: MatchConfig{Hosts: []string{"api.*.com"}}},
+			},
+			wantErr: "invalid host",
+		},
+		{
+			name: "invalid regex",
+			gateways: []Gateway{
+				{Name: "a", Match: MatchConfig{PathRegex: "("}},
+			},
+			wantErr: "invalid path_regex",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRoutes(tt.gateways)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		gateways []Gateway
+		want     []string
+		// wantErr is set when names are still not unique
+		wantErr bool
+	}{
+		{
+			name: "prefix",
+			gateways: []Gateway{
+				{Prefix: "/users"},
+				{Match: MatchConfig{Path: "/me"}},
+			},
+			want: []string{"/users", "/me"},
+		},
+		{
+			name: "hosts and methods",
+			gateways: []Gateway{
+				{Prefix: "/users", Match: MatchConfig{Methods: []string{"GET"}}},
+				{Prefix: "/users", Match: MatchConfig{Methods: []string{"POST"}}},
+				{Prefix: "/users", Match: MatchConfig{Hosts: []string{"api.example.com"}}},
+			},
+			want: []string{"GET /users", "POST /users", "api.example.com/users"},
+		},
+		{
+			name: "set name is kept",
+			gateways: []Gateway{
+				{Name: "stable", Prefix: "/users"},
+				{Prefix: "/users", Match: MatchConfig{Priority: 1}},
+			},
+			want: []string{"stable", "/users"},
+		},
+		{
+			name: "same routes with different headers",
+			gateways: []Gateway{
+				{Prefix: "/users"},
+				{Prefix: "/users", Match: MatchConfig{Headers: map[string]string{"x-beta": "1"}}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Gateways: tt.gateways}
+			cfg.applyGatewayDefaults()
+
+			err := validateRoutes(cfg.Gateways)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "set name") {
+					t.Errorf("got error %v, want duplicate name error", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i, want := range tt.want {
+				if got := cfg.Gateways[i].Name; got != want {
+					t.Errorf("gateway %d is named %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
@@ -14,3 +17,103 @@ func validateStartsWith(fl validator.FieldLevel) bool {
 	}
 	return strings.HasPrefix(value, prefix)
 }
+
+func validateRewrite(rw *RewriteConfig) error {
+	if rw.Regex == "" {
+		if rw.Replacement != "" {
+			return fmt.Errorf("replacement requires regex")
+		}
+
+		return nil
+	}
+
+	if _, err := regexp.Compile(rw.Regex); err != nil {
+		return fmt.Errorf("invalid regex: %v", err)
+	}
+
+	return nil
+}
+
+// validateClaims checks that jwt claims are used only by gateways with auth,
+// claims are read from token verified by auth middleware
+func validateClaims(g *Gateway) error {
+	if g.Auth {
+		return nil
+	}
+
+	if g.Balancer.Hash.Key == "claim" {
+		return fmt.Errorf("balancer.hash.key=claim requires auth")
+	}
+
+	for _, pool := range g.Pools {
+		if pool.Balancer.Hash.Key == "claim" {
+			return fmt.Errorf("balancer.hash.key=claim of pool %s requires auth", pool.Name)
+		}
+	}
+
+	if g.Split.Key == "claim" {
+		return fmt.Errorf("split.key=claim requires auth")
+	}
+
+	for _, rule := range g.Split.Rules {
+		if rule.Source == "claim" {
+			return fmt.Errorf("split rule with source=claim requires auth")
+		}
+	}
+
+	for _, rules := range []HeaderRules{g.Headers.Request, g.Headers.Response} {
+		for _, values := range []map[string]string{rules.Set, rules.Add} {
+			for name, value := range values {
+				if strings.Contains(value, "{claim.") {
+					return fmt.Errorf("claim placeholder of header %s requires auth", name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateHealthCheck(hc *HealthCheckConfig) error {
+	for _, status := range hc.ExpectedStatus {
+		if _, _, err := ParseStatusRange(status); err != nil {
+			return err
+		}
+	}
+
+	if hc.BodyRegex != "" {
+		if _, err := regexp.Compile(hc.BodyRegex); err != nil {
+			return fmt.Errorf("invalid body_regex: %v", err)
+		}
+	}
+
+	if hc.Interval > 0 && hc.Timeout > hc.Interval {
+		return fmt.Errorf("timeout must not be bigger than interval")
+	}
+
+	return nil
+}
+
+// ParseStatusRange parses status code like "200" or range like "200-299"
+func ParseStatusRange(value string) (int, int, error) {
+	from, to, isRange := strings.Cut(value, "-")
+	if !isRange {
+		to = from
+	}
+
+	low, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status %q", value)
+	}
+
+	high, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status %q", value)
+	}
+
+	if low < 100 || high > 599 || low > high {
+		return 0, 0, fmt.Errorf("invalid status range %q", value)
+	}
+
+	return low, high, nil
+}
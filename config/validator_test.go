@@ -0,0 +1,75 @@
+package config
+
+import "testing"
+
+func TestValidateClaims(t *testing.T) {
+	tests := []struct {
+		name    string
+		gateway Gateway
+		wantErr bool
+	}{
+		{
+			name:    "hash by ip",
+			gateway: Gateway{Balancer: BalancerConfig{Hash: HashConfig{Key: "ip"}}},
+		},
+		{
+			name:    "hash by claim",
+			gateway: Gateway{Balancer: BalancerConfig{Hash: HashConfig{Key: "claim", Name: "sub"}}},
+			wantErr: true,
+		},
+		{
+			name:    "hash by claim with auth",
+			gateway: Gateway{Auth: true, Balancer: BalancerConfig{Hash: HashConfig{Key: "claim", Name: "sub"}}},
+		},
+		{
+			name: "pool hash by claim",
+			gateway: Gateway{Pools: []PoolConfig{
+				{Name: "stable", Balancer: BalancerConfig{Hash: HashConfig{Key: "claim", Name: "sub"}}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "sticky split by claim",
+			gateway: Gateway{Split: SplitConfig{Sticky: true, Key: "claim", Name: "sub"}},
+			wantErr: true,
+		},
+		{
+			name:    "split rule by claim",
+			gateway: Gateway{Split: SplitConfig{Rules: []SplitRule{{Pool: "canary", Source: "claim", Name: "beta"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "split rule by header",
+			gateway: Gateway{Split: SplitConfig{Rules: []SplitRule{{Pool: "canary", Source: "header", Name: "X-Beta"}}}},
+		},
+		{
+			name: "claim in request header",
+			gateway: Gateway{Headers: HeadersConfig{
+				Request: HeaderRules{Set: map[string]string{"X-User": "{claim.sub}"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "claim in response header",
+			gateway: Gateway{Headers: HeadersConfig{
+				Response: HeaderRules{Add: map[string]string{"X-User": "user {claim.sub}"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "claim in header with auth",
+			gateway: Gateway{Auth: true, Headers: HeadersConfig{
+				Request: HeaderRules{Set: map[string]string{"X-User": "{claim.sub}"}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateClaims(&tt.gateway)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
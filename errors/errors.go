@@ -6,4 +6,5 @@ var (
 	ErrNoHealthyTargets = errors.New("no healthy targets available")
 	ErrUnknownAlg       = errors.New("unknown load balancer algorithm")
 	ErrPrefixNotFound   = errors.New("prefix not found")
+	ErrRouteNotFound    = errors.New("route not found")
 )
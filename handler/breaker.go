@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/osamikoyo/orion/breaker"
+	"github.com/osamikoyo/orion/loadbalancer"
+	"github.com/osamikoyo/orion/metrics"
+	"go.uber.org/zap"
+)
+
+// selectTarget balances request inside pool of gateway and skips targets with
+// open circuit breaker, breaker.OpenError is returned when all tried targets are open
+func (h *Handler) selectTarget(r *http.Request, name, pool string) (string, loadbalancer.DoneFunc, error) {
+	attempts := 1
+	if gateway, ok := h.gateways[name]; ok {
+		attempts = len(gateway.AllTargets())
+	}
+
+	var wait time.Duration
+
+	for i := 0; i < attempts; i++ {
+		target, done, err := h.loadbalancer.Balance(r, pool)
+		if err != nil {
+			h.logger.Error("failed balance",
+				zap.String("path", r.URL.Path),
+				zap.Error(err))
+
+			return "", nil, err
+		}
+
+		tb := h.breakers.Target(name, target)
+		if tb == nil {
+			return target, done, nil
+		}
+
+		breakerDone, targetWait, ok := tb.Allow()
+		if ok {
+			return target, func(info loadbalancer.DoneInfo) {
+				breakerDone(outcomeOf(info))
+				done(info)
+			}, nil
+		}
+
+		// release target which was not used
+		done(loadbalancer.DoneInfo{})
+
+		if wait == 0 || targetWait < wait {
+			wait = targetWait
+		}
+	}
+
+	h.logger.Warn("circuit breakers of all tried targets are open",
+		zap.String("gateway", name))
+
+	return "", nil, &breaker.OpenError{Wait: wait}
+}
+
+// rejectOpen fails request fast when circuit breaker is open
+func (h *Handler) rejectOpen(w http.ResponseWriter, r *http.Request, name string, wait time.Duration) {
+	h.logger.Warn("circuit breaker is open",
+		zap.String("gateway", name),
+		zap.Duration("retry_after", wait))
+
+	w.Header().Set("Retry-After", strconv.Itoa(breaker.RetryAfter(wait)))
+	http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+
+	metrics.ErrorRequestTotal.WithLabelValues(r.URL.Path, "circuit_open").Inc()
+}
+
+func outcomeOf(info loadbalancer.DoneInfo) breaker.Outcome {
+	switch {
+	// client left, so result says nothing about upstream
+	case errors.Is(info.Err, context.Canceled):
+		return breaker.OutcomeSkipped
+	case info.Err != nil || info.Status >= http.StatusInternalServerError:
+		return breaker.OutcomeFailure
+	case info.Status == 0:
+		return breaker.OutcomeSkipped
+	default:
+		return breaker.OutcomeSuccess
+	}
+}
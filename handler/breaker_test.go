@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/osamikoyo/orion/breaker"
+	"github.com/osamikoyo/orion/loadbalancer"
+)
+
+func TestOutcomeOf(t *testing.T) {
+	tests := []struct {
+		name string
+		info loadbalancer.DoneInfo
+		want breaker.Outcome
+	}{
+		{name: "success", info: loadbalancer.DoneInfo{Status: 200}, want: breaker.OutcomeSuccess},
+		{name: "client error", info: loadbalancer.DoneInfo{Status: 404}, want: breaker.OutcomeSuccess},
+		{name: "server error", info: loadbalancer.DoneInfo{Status: 503}, want: breaker.OutcomeFailure},
+		{name: "connection error", info: loadbalancer.DoneInfo{Err: fmt.Errorf("connection refused")}, want: breaker.OutcomeFailure},
+		{name: "deadline", info: loadbalancer.DoneInfo{Err: context.DeadlineExceeded}, want: breaker.OutcomeFailure},
+		{name: "canceled by client", info: loadbalancer.DoneInfo{Err: fmt.Errorf("proxy: %w", context.Canceled)}, want: breaker.OutcomeSkipped},
+		{name: "not sent", info: loadbalancer.DoneInfo{}, want: breaker.OutcomeSkipped},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outcomeOf(tt.info); got != tt.want {
+				t.Errorf("outcome %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
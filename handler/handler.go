@@ -2,19 +2,25 @@
 package handler
 
 import (
+	"context"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 
+	"github.com/osamikoyo/orion/aggregator"
 	"github.com/osamikoyo/orion/auth"
+	"github.com/osamikoyo/orion/breaker"
 	"github.com/osamikoyo/orion/cache"
 	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/errors"
 	"github.com/osamikoyo/orion/loadbalancer"
 	"github.com/osamikoyo/orion/logger"
 	"github.com/osamikoyo/orion/metrics"
 	"github.com/osamikoyo/orion/proxy"
 	"github.com/osamikoyo/orion/rate"
+	"github.com/osamikoyo/orion/router"
 	"github.com/osamikoyo/orion/selfcach"
+	"github.com/osamikoyo/orion/stream"
 	"go.uber.org/zap"
 )
 
@@ -29,13 +35,24 @@ type (
 		loadbalancer *loadbalancer.LoadBalancer
 		cfg          *config.Config
 		logger       *logger.Logger
-		// mws stores middlewares for each prefix
+		// mws stores middlewares for each gateway by name
 		mws map[string][]Middleware
+		// gateways stores gateway config by name
+		gateways map[string]*config.Gateway
+		router   *router.Router
+		breakers *breaker.CircuitBreakers
+		// aggregators serve aggregation gateways by name instead of proxy
+		aggregators map[string]*aggregator.Aggregator
 	}
 )
 
 // cunstructor for Handler
-func NewHandler(proxy *proxy.ProxyMW, loadbalancer *loadbalancer.LoadBalancer, logger *logger.Logger, cfg *config.Config) *Handler {
+func NewHandler(proxy *proxy.ProxyMW, loadbalancer *loadbalancer.LoadBalancer, logger *logger.Logger, cfg *config.Config) (*Handler, error) {
+	router, err := router.New(cfg.Gateways)
+	if err != nil {
+		return nil, err
+	}
+
 	// create selfcache and cache middleware
 	sc := selfcach.NewCache(logger, time.Hour, 3*time.Hour)
 	cache := cache.NewCache(sc, logger, cfg)
@@ -48,8 +65,11 @@ func NewHandler(proxy *proxy.ProxyMW, loadbalancer *loadbalancer.LoadBalancer, l
 
 	// create mws map
 	mws := make(map[string][]Middleware)
+	gateways := make(map[string]*config.Gateway, len(cfg.Gateways))
+
+	for i, gateway := range cfg.Gateways {
+		gateways[gateway.Name] = &cfg.Gateways[i]
 
-	for _, gateway := range cfg.Gateways {
 		// itarate every gateway and its middlewares
 
 		var mwArr []Middleware
@@ -67,16 +87,29 @@ func NewHandler(proxy *proxy.ProxyMW, loadbalancer *loadbalancer.LoadBalancer, l
 			mwArr = append(mwArr, rate.RateLimitMiddleware)
 		}
 
-		mws[gateway.Prefix] = mwArr
+		mws[gateway.Name] = mwArr
 	}
 
-	return &Handler{
+	h := &Handler{
 		proxy:        proxy,
 		loadbalancer: loadbalancer,
 		cfg:          cfg,
 		mws:          mws,
 		logger:       logger,
+		gateways:     gateways,
+		router:       router,
+		breakers:     breaker.NewCircuitBreakers(cfg, logger),
+		aggregators:  make(map[string]*aggregator.Aggregator),
 	}
+
+	// calls with path are routed by handler like requests of clients
+	for _, gateway := range gateways {
+		if gateway.IsAggregate() {
+			h.aggregators[gateway.Name] = aggregator.New(gateway, h, logger)
+		}
+	}
+
+	return h, nil
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -90,45 +123,114 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		metrics.RequestDuration.WithLabelValues(path).Observe(float64(time.Since(now).Seconds()))
 	}()
 
-	// get target
-	target, err := h.loadbalancer.Balance(r)
-	if err != nil {
-		h.logger.Error("failed balance",
+	match, ok := h.router.Match(r)
+	if !ok {
+		h.logger.Error("failed match route",
+			zap.String("host", r.Host),
 			zap.String("path", r.URL.Path),
-			zap.Error(err))
+			zap.Error(errors.ErrRouteNotFound))
 
-		http.Error(w, "failed balance targets", http.StatusBadGateway)
+		http.Error(w, "route not found", http.StatusNotFound)
+
+		metrics.ErrorRequestTotal.WithLabelValues(path, "no_gateway").Inc()
 
 		return
 	}
 
-	prefix := "/" + strings.Split(r.URL.Path, "/")[1]
+	gateway := match.Gateway
+	name := gateway.Name
 
-	var (
-		mws []Middleware
-		ok  bool
-	)
+	// fail fast when the whole gateway is broken
+	gatewayDone := breaker.DoneFunc(func(breaker.Outcome) {})
+	if gb := h.breakers.Gateway(name); gb != nil {
+		bdone, wait, ok := gb.Allow()
+		if !ok {
+			h.rejectOpen(w, r, name, wait)
+
+			return
+		}
 
-	// get mws by prefix
-	mws, ok = h.mws[prefix]
+		gatewayDone = bdone
+	}
+
+	// rewrite rules and targets read parameters of matched route
+	r = r.WithContext(router.NewContext(r.Context(), match))
+
+	// deadline covers middlewares, every attempt and copy of response,
+	// it is lifted for streams which are limited by idle timeout and lifetime instead,
+	// grpc clients may send deadline of call which only shortens gateway one
+	timeout := gateway.Timeout.Request
+	if grpcTimeout, ok := proxy.GRPCTimeout(r); ok && gateway.GRPC.Use {
+		if timeout == 0 {
+			timeout = grpcTimeout
+		} else {
+			timeout = min(timeout, grpcTimeout)
+		}
+	}
+
+	if timeout > 0 {
+		withTimeout := context.WithTimeout
+		if stream.IsRequest(r) {
+			withTimeout = proxy.WithStreamDeadline
+		}
+
+		ctx, cancel := withTimeout(r.Context(), timeout)
+		defer cancel()
+
+		r = r.WithContext(ctx)
+	}
+
+	// get mws by gateway name
+	mws, ok := h.mws[name]
 	if !ok {
 		h.logger.Error("failed load mws",
-			zap.String("prefix", prefix))
+			zap.String("gateway", name))
 
 		mws = nil
 	}
 
-	// get proxy handler
-	proxymw := h.proxy.Middleware(target)
+	// proxy reports outcome of the last attempt, the fallback below
+	// only releases gateway breaker when upstream was not called at all
+	var once sync.Once
+	report := func(info loadbalancer.DoneInfo) {
+		once.Do(func() {
+			gatewayDone(outcomeOf(info))
+		})
+	}
+	defer report(loadbalancer.DoneInfo{})
+
+	// every attempt of request is balanced inside the same pool, it is selected
+	// after middlewares, so split rules see claims verified by auth
+	var (
+		pool     string
+		poolOnce sync.Once
+	)
+
+	// get proxy handler, aggregation gateway answers itself with responses of its calls
+	var proxymw http.Handler
+	if agg, ok := h.aggregators[name]; ok {
+		proxymw = agg
+	} else {
+		proxymw = h.proxy.Middleware(proxy.Upstream{
+			Gateway: gateway,
+			Select: func(r *http.Request) (string, loadbalancer.DoneFunc, error) {
+				poolOnce.Do(func() {
+					pool = h.loadbalancer.SelectPool(r, name)
+				})
+
+				return h.selectTarget(r, name, pool)
+			},
+			Done: report,
+		})
+	}
 
 	for _, mw := range mws {
 		//set proxy wm with every mws
-		proxymw = mw(proxymw).(http.HandlerFunc)
+		proxymw = mw(proxymw)
 	}
 
 	h.logger.Info("request was successfully setuped",
-		zap.String("target", target),
-		zap.String("prefix", prefix))
+		zap.String("gateway", name))
 
 	proxymw.ServeHTTP(w, r)
 }
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/loadbalancer"
+	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/proxy"
+	"go.uber.org/zap"
+)
+
+// newTestTarget starts target which answers with its name and path of request
+func newTestTarget(t *testing.T, name string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// newTestHandler loads yaml config like gateway does and returns
+// handler built from it with balancer and proxy of the same config
+func newTestHandler(t *testing.T, yaml string) http.Handler {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("failed write config: %v", err)
+	}
+
+	cfg, err := config.NewConfig(path)
+	if err != nil {
+		t.Fatalf("failed load config: %v", err)
+	}
+
+	log := &logger.Logger{Logger: zap.NewNop()}
+
+	lb, cancel, err := loadbalancer.NewLoadBalancer(cfg, log)
+	if err != nil {
+		t.Fatalf("failed create load balancer: %v", err)
+	}
+	t.Cleanup(cancel)
+
+	pmw, err := proxy.NewProxyMW(cfg, log)
+	if err != nil {
+		t.Fatalf("failed create proxy: %v", err)
+	}
+
+	h, err := NewHandler(pmw, lb, log, cfg)
+	if err != nil {
+		t.Fatalf("failed create handler: %v", err)
+	}
+
+	return h
+}
+
+func TestHandlerRouting(t *testing.T) {
+	h := newTestHandler(t, fmt.Sprintf(`
+balancer: rr
+gateways:
+  - name: api
+    prefix: /api
+    targets: [{url: %q, weight: 1}]
+  - name: users
+    prefix: /api/v2/users
+    targets: [{url: %q, weight: 1}]
+    rewrite: {strip_prefix: true}
+  - name: admin
+    prefix: /api/v2/users
+    match: {hosts: [admin.example.com]}
+    targets: [{url: %q, weight: 1}]
+  - name: create
+    prefix: /api/v2/users
+    match: {methods: [POST]}
+    targets: [{url: %q, weight: 1}]
+  - name: books
+    prefix: /api/{version}/books
+    targets: [{url: %q, weight: 1}]
+    rewrite: {strip_prefix: true, add_prefix: /books}
+`,
+		newTestTarget(t, "api"),
+		newTestTarget(t, "users"),
+		newTestTarget(t, "admin"),
+		newTestTarget(t, "create"),
+		newTestTarget(t, "books"),
+	))
+
+	tests := []struct {
+		name       string
+		method     string
+		host       string
+		path       string
+		wantStatus int
+		want       string
+	}{
+		{name: "nested prefix", method: "GET", path: "/api/v2/users/7", wantStatus: http.StatusOK, want: "users /7"},
+		{name: "nested prefix itself", method: "GET", path: "/api/v2/users", wantStatus: http.StatusOK, want: "users /"},
+		{name: "shorter prefix", method: "GET", path: "/api/v1/orders", wantStatus: http.StatusOK, want: "api /api/v1/orders"},
+		{name: "prefix matches whole segments", method: "GET", path: "/api/v2/usersx", wantStatus: http.StatusOK, want: "api /api/v2/usersx"},
+		{name: "same prefix by host", method: "GET", host: "admin.example.com", path: "/api/v2/users/7", wantStatus: http.StatusOK, want: "admin /api/v2/users/7"},
+		{name: "same prefix by method", method: "POST", path: "/api/v2/users", wantStatus: http.StatusOK, want: "create /api/v2/users"},
+		{name: "matched prefix is stripped", method: "GET", path: "/api/v3/books/dune", wantStatus: http.StatusOK, want: "books /books/dune"},
+		{name: "no route", method: "GET", path: "/orders", wantStatus: http.StatusNotFound, want: "route not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := strings.TrimSpace(rec.Body.String()); rec.Code != tt.wantStatus || got != tt.want {
+				t.Errorf("got %d %q, want %d %q", rec.Code, got, tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerPoolSplit(t *testing.T) {
+	h := newTestHandler(t, fmt.Sprintf(`
+balancer: rr
+gateways:
+  - name: api
+    prefix: /api/v2
+    pools:
+      - name: stable
+        weight: 100
+        targets: [{url: %q, weight: 1}]
+      - name: canary
+        weight: 0
+        targets: [{url: %q, weight: 1}]
+    split:
+      rules:
+        - {pool: canary, source: header, name: X-Canary, values: ["true"]}
+`,
+		newTestTarget(t, "stable"),
+		newTestTarget(t, "canary"),
+	))
+
+	tests := []struct {
+		name   string
+		canary string
+		want   string
+	}{
+		{name: "weight of pool", want: "stable /api/v2/users"},
+		{name: "header rule", canary: "true", want: "canary /api/v2/users"},
+		{name: "other header value", canary: "false", want: "stable /api/v2/users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// every attempt must stay in pool selected for request
+			for i := 0; i < 5; i++ {
+				req := httptest.NewRequest("GET", "/api/v2/users", nil)
+				if tt.canary != "" {
+					req.Header.Set("X-Canary", tt.canary)
+				}
+
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				if got := rec.Body.String(); rec.Code != http.StatusOK || got != tt.want {
+					t.Fatalf("request %d got %d %q, want %q", i, rec.Code, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerOpenBreaker(t *testing.T) {
+	var calls atomic.Int64
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(target.Close)
+
+	h := newTestHandler(t, fmt.Sprintf(`
+balancer: rr
+gateways:
+  - name: api
+    prefix: /api/v2/users
+    targets: [{url: %q, weight: 1}]
+    circuit_breaker: {use: true, consecutive_failures: 2, open_duration: 1m}
+`, target.URL))
+
+	// failures of target open breaker
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v2/users/7", nil))
+
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "" {
+			t.Fatalf("request %d got %d with Retry-After %q, want response of target", i, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v2/users/7", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status of open breaker %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After %q, want 60", got)
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("target called %d times, want 2", got)
+	}
+}
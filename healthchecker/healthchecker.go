@@ -3,83 +3,254 @@ package healthchecker
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"net/http"
+	"io"
+	"math/rand/v2"
 	"sync"
+	"time"
 
 	"github.com/osamikoyo/orion/config"
 	"github.com/osamikoyo/orion/logger"
 	"go.uber.org/zap"
 )
 
-// health checker stores components to create health check
-type HealthChecker struct {
-	// healthEndPoints stores endpoint for health request for every target
-	healthEndPoints map[string]string
-	logger          *logger.Logger
-}
+type (
+	// prober checks health of one target
+	prober interface {
+		Probe(ctx context.Context) error
+	}
+
+	// targetKey identifies target of gateway, the same url may be
+	// a target of several gateways with different probes
+	targetKey struct {
+		gateway string
+		url     string
+	}
+
+	// targetState stores prober and consecutive results of target
+	targetState struct {
+		gateway string
+		url     string
+		cfg     config.HealthCheckConfig
+		prober  prober
+
+		healthy   bool
+		successes int
+		failures  int
+	}
+
+	// health checker stores components to create health check
+	HealthChecker struct {
+		// targets stores probe state for every target of every gateway
+		targets map[targetKey]*targetState
+		logger  *logger.Logger
+		mu      sync.Mutex
+	}
+)
 
 // NewHealthChecker create HealtchChecker and parse targets in map
-func NewHealthChecker(cfg *config.Config, logger *logger.Logger) *HealthChecker {
-	// create healthEndPoints map
-	health := make(map[string]string)
+func NewHealthChecker(cfg *config.Config, logger *logger.Logger) (*HealthChecker, error) {
+	targets := make(map[targetKey]*targetState)
 
 	// parse every gateway
 	for _, gateway := range cfg.Gateways {
-		for _, target := range gateway.Targets {
-			health[target.Url] = target.HealthEndpoint
+		for _, target := range gateway.AllTargets() {
+			state := &targetState{
+				gateway: gateway.Name,
+				url:     target.Url,
+				cfg:     target.HealthCheck,
+				healthy: true,
+			}
+
+			if state.cfg.Interval <= 0 {
+				state.cfg.Interval = cfg.HealthCheckTimeout
+			}
+			if state.cfg.Timeout <= 0 {
+				state.cfg.Timeout = config.DefaultProbeTimeout
+			}
+
+			prober, err := newProber(target, gateway.TLS)
+			if err != nil {
+				logger.Error("failed create health prober",
+					zap.String("gateway", gateway.Name),
+					zap.String("target", target.Url),
+					zap.Error(err))
+
+				return nil, fmt.Errorf("failed create health prober for %s: %v", target.Url, err)
+			}
+
+			state.prober = prober
+			targets[targetKey{gateway: gateway.Name, url: target.Url}] = state
 		}
 	}
 
 	return &HealthChecker{
-		healthEndPoints: health,
-		logger:          logger,
+		targets: targets,
+		logger:  logger,
+	}, nil
+}
+
+// newProber creates prober of kind configured for target,
+// tls of gateway is used when probe has no own tls settings
+func newProber(target config.Target, gatewayTLS config.UpstreamTLS) (prober, error) {
+	switch target.HealthCheck.Type {
+	case "tcp":
+		return newTCPProber(target)
+	case "grpc":
+		return newGRPCProber(target, gatewayTLS)
+	case "", "http", "https":
+		return newHTTPProber(target, gatewayTLS)
+	default:
+		return nil, fmt.Errorf("unknown probe type %s", target.HealthCheck.Type)
 	}
 }
 
-// Check starts health check for every target in config
-// and give map with targets and their health
-func (hc *HealthChecker) Check(_ context.Context) map[string]bool {
-	// create result map
-	health := make(map[string]bool)
-
-	// set sync variables
-	var (
-		wg sync.WaitGroup
-		mu sync.Mutex
-	)
-
-	// send http request on every health endpoint
-	for target, endpoint := range hc.healthEndPoints {
-		// start request gourutine every iteration
-		wg.Go(func() {
-			path := fmt.Sprintf("http://%s%s", target, endpoint)
+// probeTLS builds tls config of probe, gateway tls is used
+// when probe has no own settings
+func probeTLS(target config.Target, gatewayTLS config.UpstreamTLS) (*tls.Config, error) {
+	if target.HealthCheck.TLS != (config.UpstreamTLS{}) {
+		return target.HealthCheck.TLS.Build()
+	}
 
-			hc.logger.Info("forming healthcheck request",
-				zap.String("target", target))
-			// create http request with context
-			_, err := http.Get(path)
-			if err != nil {
-				// if unhealthy
-				hc.logger.Warn("unhealthy service", zap.String("url", path))
+	return gatewayTLS.Build()
+}
 
-				mu.Lock()
+// Run probes every target with its own interval until ctx is done
+// and sends health of all targets to output when any of them changes,
+// health is keyed by name of gateway and url of target
+func (hc *HealthChecker) Run(ctx context.Context, output chan<- map[string]map[string]bool) {
+	var wg sync.WaitGroup
 
-				health[target] = false
+	defer hc.close()
 
-				mu.Unlock()
-			} else {
-				// if healthy
-				mu.Lock()
+	for _, state := range hc.targets {
+		wg.Go(func() {
+			for {
+				wait := state.cfg.Interval
+				if state.cfg.Jitter > 0 {
+					wait += rand.N(state.cfg.Jitter)
+				}
 
-				health[target] = true
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
 
-				mu.Unlock()
+				if !hc.probeAndUpdate(ctx, state) {
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case output <- hc.snapshot():
+				}
 			}
 		})
 	}
 
 	wg.Wait()
+}
+
+// Check starts health check for every target in config
+// and give health of targets by gateway name and target url
+func (hc *HealthChecker) Check(ctx context.Context) map[string]map[string]bool {
+	var wg sync.WaitGroup
+
+	for _, state := range hc.targets {
+		wg.Go(func() {
+			hc.probeAndUpdate(ctx, state)
+		})
+	}
+
+	wg.Wait()
+
+	return hc.snapshot()
+}
+
+func (hc *HealthChecker) snapshot() map[string]map[string]bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	health := make(map[string]map[string]bool)
+	for key, state := range hc.targets {
+		if health[key.gateway] == nil {
+			health[key.gateway] = make(map[string]bool)
+		}
+
+		health[key.gateway][key.url] = state.healthy
+	}
 
 	return health
 }
+
+// probeAndUpdate probes target and applies rise and fall thresholds,
+// it returns true when health of target was changed
+func (hc *HealthChecker) probeAndUpdate(ctx context.Context, state *targetState) bool {
+	err := hc.probe(ctx, state)
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if err != nil {
+		state.failures++
+		state.successes = 0
+
+		hc.logger.Warn("health probe failed",
+			zap.String("gateway", state.gateway),
+			zap.String("target", state.url),
+			zap.Int("failures", state.failures),
+			zap.Error(err))
+
+		if state.healthy && state.failures >= state.cfg.Fall {
+			state.healthy = false
+
+			hc.logger.Warn("unhealthy service",
+				zap.String("gateway", state.gateway),
+				zap.String("target", state.url))
+
+			return true
+		}
+
+		return false
+	}
+
+	state.successes++
+	state.failures = 0
+
+	if !state.healthy && state.successes >= state.cfg.Rise {
+		state.healthy = true
+
+		hc.logger.Info("service is healthy again",
+			zap.String("gateway", state.gateway),
+			zap.String("target", state.url))
+
+		return true
+	}
+
+	return false
+}
+
+// probe runs prober of target with timeout
+func (hc *HealthChecker) probe(ctx context.Context, state *targetState) error {
+	ctx, cancel := context.WithTimeout(ctx, state.cfg.Timeout)
+	defer cancel()
+
+	return state.prober.Probe(ctx)
+}
+
+// close releases connections held by probers
+func (hc *HealthChecker) close() {
+	for _, state := range hc.targets {
+		if closer, ok := state.prober.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				hc.logger.Warn("failed close health prober",
+					zap.String("gateway", state.gateway),
+					zap.String("target", state.url),
+					zap.Error(err))
+			}
+		}
+	}
+}
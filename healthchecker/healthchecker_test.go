@@ -0,0 +1,243 @@
+package healthchecker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+// sequenceProber returns results in turn, nil is a successful probe
+type sequenceProber struct {
+	results []error
+	next    int
+}
+
+func (sp *sequenceProber) Probe(ctx context.Context) error {
+	err := sp.results[sp.next%len(sp.results)]
+	sp.next++
+
+	return err
+}
+
+func newTestHealthChecker() *HealthChecker {
+	return &HealthChecker{
+		targets: make(map[targetKey]*targetState),
+		logger:  &logger.Logger{Logger: zap.NewNop()},
+	}
+}
+
+// newTestHealthServer answers health requests by path
+func newTestHealthServer(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("ok"))
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"status":"up","checks":{"db":"down"}}`))
+		case "/redirect":
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestProbeThresholds(t *testing.T) {
+	fail := errors.New("probe failed")
+
+	tests := []struct {
+		name    string
+		rise    int
+		fall    int
+		results []error
+		// healthy stores health of target after every probe
+		healthy []bool
+	}{
+		{
+			name:    "single failure",
+			rise:    1,
+			fall:    1,
+			results: []error{fail, nil},
+			healthy: []bool{false, true},
+		},
+		{
+			name:    "fall threshold",
+			rise:    1,
+			fall:    3,
+			results: []error{fail, fail, fail},
+			healthy: []bool{true, true, false},
+		},
+		{
+			name:    "success resets failures",
+			rise:    1,
+			fall:    2,
+			results: []error{fail, nil, fail, nil},
+			healthy: []bool{true, true, true, true},
+		},
+		{
+			name:    "rise threshold",
+			rise:    2,
+			fall:    1,
+			results: []error{fail, nil, nil, nil},
+			healthy: []bool{false, false, true, true},
+		},
+		{
+			name:    "failure resets successes",
+			rise:    2,
+			fall:    1,
+			results: []error{fail, nil, fail, nil, nil},
+			healthy: []bool{false, false, false, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := newTestHealthChecker()
+
+			state := &targetState{
+				gateway: "api",
+				url:     "target",
+				cfg:     config.HealthCheckConfig{Rise: tt.rise, Fall: tt.fall, Timeout: time.Second},
+				prober:  &sequenceProber{results: tt.results},
+				healthy: true,
+			}
+			hc.targets[targetKey{gateway: state.gateway, url: state.url}] = state
+
+			healthy := true
+
+			for i, want := range tt.healthy {
+				changed := hc.probeAndUpdate(context.Background(), state)
+
+				if got := hc.snapshot()["api"]["target"]; got != want {
+					t.Fatalf("probe %d: healthy %v, want %v", i, got, want)
+				}
+
+				if changed != (healthy != want) {
+					t.Errorf("probe %d: changed %v, want %v", i, changed, healthy != want)
+				}
+
+				healthy = want
+			}
+		})
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	addr := newTestHealthServer(t)
+
+	tests := []struct {
+		name     string
+		endpoint string
+		cfg      config.HealthCheckConfig
+		wantErr  bool
+	}{
+		{name: "default status", endpoint: "/ok"},
+		{name: "unexpected status", endpoint: "/fail", wantErr: true},
+		{name: "status in range", endpoint: "/redirect", cfg: config.HealthCheckConfig{ExpectedStatus: []string{"200-299", "300-399"}}},
+		{name: "status out of range", endpoint: "/redirect", cfg: config.HealthCheckConfig{ExpectedStatus: []string{"200-299"}}, wantErr: true},
+		{name: "single status", endpoint: "/fail", cfg: config.HealthCheckConfig{ExpectedStatus: []string{"503"}}},
+		{name: "body contains", endpoint: "/ok", cfg: config.HealthCheckConfig{BodyContains: "ok"}},
+		{name: "body does not contain", endpoint: "/ok", cfg: config.HealthCheckConfig{BodyContains: "up"}, wantErr: true},
+		{name: "body regex", endpoint: "/json", cfg: config.HealthCheckConfig{BodyRegex: `"status":\s*"up"`}},
+		{name: "body regex mismatch", endpoint: "/json", cfg: config.HealthCheckConfig{BodyRegex: `^ok$`}, wantErr: true},
+		{name: "json path", endpoint: "/json", cfg: config.HealthCheckConfig{JSONPath: "checks.db"}},
+		{name: "json path missing", endpoint: "/json", cfg: config.HealthCheckConfig{JSONPath: "checks.cache"}, wantErr: true},
+		{name: "json value", endpoint: "/json", cfg: config.HealthCheckConfig{JSONPath: "status", JSONValue: "up"}},
+		{name: "json value mismatch", endpoint: "/json", cfg: config.HealthCheckConfig{JSONPath: "checks.db", JSONValue: "up"}, wantErr: true},
+		{name: "json body of non json", endpoint: "/ok", cfg: config.HealthCheckConfig{JSONPath: "status"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Method = http.MethodGet
+
+			hp, err := newHTTPProber(config.Target{Url: addr, HealthEndpoint: tt.endpoint, HealthCheck: tt.cfg}, config.UpstreamTLS{})
+			if err != nil {
+				t.Fatalf("failed create prober: %v", err)
+			}
+
+			err = hp.Probe(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("probe error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHealthCheckerGateways(t *testing.T) {
+	addr := newTestHealthServer(t)
+
+	// the same target is probed by every gateway with its own probe
+	cfg := &config.Config{
+		Gateways: []config.Gateway{
+			{
+				Name: "ok",
+				Targets: []config.Target{
+					{Url: addr, HealthEndpoint: "/ok", HealthCheck: config.HealthCheckConfig{Rise: 1, Fall: 1}},
+				},
+			},
+			{
+				Name: "fail",
+				Targets: []config.Target{
+					{Url: addr, HealthEndpoint: "/fail", HealthCheck: config.HealthCheckConfig{Rise: 1, Fall: 1}},
+				},
+			},
+		},
+	}
+
+	hc, err := NewHealthChecker(cfg, &logger.Logger{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("failed create health checker: %v", err)
+	}
+	defer hc.close()
+
+	health := hc.Check(context.Background())
+
+	if healthy, ok := health["ok"][addr]; !ok || !healthy {
+		t.Errorf("target of gateway ok is healthy %v, want true", healthy)
+	}
+
+	if healthy, ok := health["fail"][addr]; !ok || healthy {
+		t.Errorf("target of gateway fail is healthy %v, want false", healthy)
+	}
+}
+
+func TestHealthCheckerInvalidProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.HealthCheckConfig
+	}{
+		{name: "body regex", cfg: config.HealthCheckConfig{BodyRegex: "("}},
+		{name: "expected status", cfg: config.HealthCheckConfig{ExpectedStatus: []string{"2xx"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Gateways: []config.Gateway{{
+					Name:    "test",
+					Targets: []config.Target{{Url: "localhost:1", HealthEndpoint: "/health", HealthCheck: tt.cfg}},
+				}},
+			}
+
+			// config is not validated, so error comes from prober itself
+			if _, err := NewHealthChecker(cfg, &logger.Logger{Logger: zap.NewNop()}); err == nil {
+				t.Errorf("health checker is created with invalid probe")
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package healthchecker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/osamikoyo/orion/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpcProber calls standard grpc.health.v1.Health/Check of target
+type grpcProber struct {
+	service string
+	conn    *grpc.ClientConn
+	client  healthpb.HealthClient
+}
+
+func newGRPCProber(target config.Target, gatewayTLS config.UpstreamTLS) (*grpcProber, error) {
+	cfg := target.HealthCheck
+
+	endpoint, err := config.ParseEndpoint(target.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := insecure.NewCredentials()
+
+	if cfg.TLS != (config.UpstreamTLS{}) || endpoint.Scheme == "https" {
+		tlsCfg, err := probeTLS(target, gatewayTLS)
+		if err != nil {
+			return nil, err
+		}
+
+		creds = credentials.NewTLS(tlsCfg)
+	}
+
+	// grpc resolves unix:// targets itself
+	addr := endpoint.Host
+	if endpoint.Scheme == "unix" {
+		addr = target.Url
+	}
+
+	// connection is established lazily on the first probe
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed create grpc client: %v", err)
+	}
+
+	return &grpcProber{
+		service: cfg.GRPCService,
+		conn:    conn,
+		client:  healthpb.NewHealthClient(conn),
+	}, nil
+}
+
+func (gp *grpcProber) Probe(ctx context.Context) error {
+	resp, err := gp.client.Check(ctx, &healthpb.HealthCheckRequest{Service: gp.service})
+	if err != nil {
+		return err
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service %q is %s", gp.service, resp.GetStatus())
+	}
+
+	return nil
+}
+
+func (gp *grpcProber) Close() error {
+	return gp.conn.Close()
+}
@@ -0,0 +1,172 @@
+package healthchecker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/tidwall/gjson"
+)
+
+// maxBodySize limits part of health response body used for matching
+const maxBodySize = 64 << 10
+
+type (
+	statusRange struct {
+		low  int
+		high int
+	}
+
+	// httpProber sends http or https request and checks status and body
+	httpProber struct {
+		url       string
+		cfg       config.HealthCheckConfig
+		expected  []statusRange
+		bodyRegex *regexp.Regexp
+		client    *http.Client
+	}
+)
+
+func newHTTPProber(target config.Target, gatewayTLS config.UpstreamTLS) (*httpProber, error) {
+	cfg := target.HealthCheck
+
+	endpoint, err := config.ParseEndpoint(target.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := endpoint.URLScheme()
+	if cfg.Type == "https" {
+		scheme = "https"
+	}
+
+	transport := &http.Transport{}
+
+	if scheme == "https" {
+		tlsCfg, err := probeTLS(target, gatewayTLS)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig = tlsCfg
+	}
+
+	switch endpoint.Scheme {
+	case "unix":
+		network, addr := endpoint.Addr()
+
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+
+			return dialer.DialContext(ctx, network, addr)
+		}
+	case "h2c":
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	hp := &httpProber{
+		url:    fmt.Sprintf("%s://%s%s", scheme, endpoint.Host, target.HealthEndpoint),
+		cfg:    cfg,
+		client: &http.Client{Transport: transport},
+	}
+
+	for _, status := range cfg.ExpectedStatus {
+		low, high, err := config.ParseStatusRange(status)
+		if err != nil {
+			return nil, err
+		}
+
+		hp.expected = append(hp.expected, statusRange{low: low, high: high})
+	}
+
+	if len(hp.expected) == 0 {
+		hp.expected = []statusRange{{low: 200, high: 299}}
+	}
+
+	if cfg.BodyRegex != "" {
+		hp.bodyRegex, err = regexp.Compile(cfg.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_regex: %v", err)
+		}
+	}
+
+	return hp, nil
+}
+
+func (hp *httpProber) Probe(ctx context.Context) error {
+	// create http request with context
+	req, err := http.NewRequestWithContext(ctx, hp.cfg.Method, hp.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed create request: %v", err)
+	}
+
+	for key, value := range hp.cfg.Headers {
+		if http.CanonicalHeaderKey(key) == "Host" {
+			req.Host = value
+
+			continue
+		}
+
+		req.Header.Set(key, value)
+	}
+
+	resp, err := hp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !hp.statusExpected(resp.StatusCode) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if hp.cfg.BodyContains == "" && hp.bodyRegex == nil && hp.cfg.JSONPath == "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("failed read body: %v", err)
+	}
+
+	return hp.matchBody(body)
+}
+
+func (hp *httpProber) statusExpected(status int) bool {
+	for _, r := range hp.expected {
+		if status >= r.low && status <= r.high {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (hp *httpProber) matchBody(body []byte) error {
+	if hp.cfg.BodyContains != "" && !bytes.Contains(body, []byte(hp.cfg.BodyContains)) {
+		return fmt.Errorf("body does not contain %q", hp.cfg.BodyContains)
+	}
+
+	if hp.bodyRegex != nil && !hp.bodyRegex.Match(body) {
+		return fmt.Errorf("body does not match %q", hp.bodyRegex.String())
+	}
+
+	if hp.cfg.JSONPath != "" {
+		value := gjson.GetBytes(body, hp.cfg.JSONPath)
+		if !value.Exists() {
+			return fmt.Errorf("json path %q not found", hp.cfg.JSONPath)
+		}
+
+		if hp.cfg.JSONValue != "" && value.String() != hp.cfg.JSONValue {
+			return fmt.Errorf("json path %q is %q, expected %q", hp.cfg.JSONPath, value.String(), hp.cfg.JSONValue)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package healthchecker
+
+import (
+	"context"
+	"net"
+
+	"github.com/osamikoyo/orion/config"
+)
+
+// tcpProber considers target healthy when tcp connection can be opened
+type tcpProber struct {
+	network string
+	addr    string
+	dialer  net.Dialer
+}
+
+func newTCPProber(target config.Target) (*tcpProber, error) {
+	endpoint, err := config.ParseEndpoint(target.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	network, addr := endpoint.Addr()
+
+	return &tcpProber{
+		network: network,
+		addr:    addr,
+	}, nil
+}
+
+func (tp *tcpProber) Probe(ctx context.Context) error {
+	conn, err := tp.dialer.DialContext(ctx, tp.network, tp.addr)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
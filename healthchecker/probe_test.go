@@ -0,0 +1,177 @@
+package healthchecker
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestTCPProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// address of closed listener refuses connections
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listen: %v", err)
+	}
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "listening", url: listener.Addr().String()},
+		{name: "listening with scheme", url: "http://" + listener.Addr().String()},
+		{name: "refused", url: closed.Addr().String(), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp, err := newTCPProber(config.Target{Url: tt.url})
+			if err != nil {
+				t.Fatalf("failed create prober: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err = tp.Probe(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("probe error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGRPCProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listen: %v", err)
+	}
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("orion.Users", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("orion.Orders", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	go server.Serve(listener)
+	defer server.Stop()
+
+	tests := []struct {
+		name    string
+		service string
+		wantErr bool
+	}{
+		// empty name asks for health of whole server
+		{name: "server", service: ""},
+		{name: "serving", service: "orion.Users"},
+		{name: "not serving", service: "orion.Orders", wantErr: true},
+		{name: "unknown", service: "orion.Payments", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := config.Target{
+				Url:         listener.Addr().String(),
+				HealthCheck: config.HealthCheckConfig{Type: "grpc", GRPCService: tt.service},
+			}
+
+			gp, err := newGRPCProber(target, config.UpstreamTLS{})
+			if err != nil {
+				t.Fatalf("failed create prober: %v", err)
+			}
+			defer gp.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err = gp.Probe(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("probe error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPSProbe(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// certificate of test server is trusted by its own bundle
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(ca, certPEM, 0o600); err != nil {
+		t.Fatalf("failed write ca: %v", err)
+	}
+
+	addr := strings.TrimPrefix(server.URL, "https://")
+
+	tests := []struct {
+		name       string
+		url        string
+		probeTLS   config.UpstreamTLS
+		gatewayTLS config.UpstreamTLS
+		wantErr    bool
+	}{
+		{name: "https target", url: "https://" + addr, gatewayTLS: config.UpstreamTLS{CA: ca}},
+		{name: "https probe of plain url", url: addr, gatewayTLS: config.UpstreamTLS{CA: ca}},
+		{name: "probe tls over gateway tls", url: "https://" + addr, probeTLS: config.UpstreamTLS{CA: ca}, gatewayTLS: config.UpstreamTLS{ServerName: "other.test"}},
+		{name: "unknown authority", url: "https://" + addr, wantErr: true},
+		{name: "wrong server name", url: "https://" + addr, gatewayTLS: config.UpstreamTLS{CA: ca, ServerName: "other.test"}, wantErr: true},
+		{name: "insecure", url: "https://" + addr, probeTLS: config.UpstreamTLS{InsecureSkipVerify: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := config.Target{
+				Url:            tt.url,
+				HealthEndpoint: "/health",
+				HealthCheck:    config.HealthCheckConfig{Type: "https", Method: http.MethodGet, TLS: tt.probeTLS},
+			}
+
+			hp, err := newHTTPProber(target, tt.gatewayTLS)
+			if err != nil {
+				t.Fatalf("failed create prober: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err = hp.Probe(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("probe error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package loadbalancer
+
+import (
+	"net/http"
+
+	"github.com/osamikoyo/orion/auth"
+	"github.com/osamikoyo/orion/clientip"
+	"github.com/osamikoyo/orion/config"
+)
+
+// keyExtractor builds the hash key of request
+type keyExtractor struct {
+	key      string
+	name     string
+	resolver *clientip.Resolver
+}
+
+func newKeyExtractor(cfg config.HashConfig) (*keyExtractor, error) {
+	resolver, err := clientip.NewResolver(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyExtractor{
+		key:      cfg.Key,
+		name:     cfg.Name,
+		resolver: resolver,
+	}, nil
+}
+
+// Extract returns key of request, client ip is used
+// when configured source is missing in request
+func (ke *keyExtractor) Extract(r *http.Request) string {
+	switch ke.key {
+	case "header":
+		if value := r.Header.Get(ke.name); value != "" {
+			return value
+		}
+	case "cookie":
+		if cookie, err := r.Cookie(ke.name); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	case "claim":
+		if value := auth.Claim(r, ke.name); value != "" {
+			return value
+		}
+	}
+
+	return ke.resolver.ClientIP(r)
+}
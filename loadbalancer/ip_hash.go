@@ -0,0 +1,141 @@
+package loadbalancer
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/errors"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+type (
+	ringNode struct {
+		hash   uint64
+		target int
+	}
+
+	// HashBalancer pins requests with the same key to the same target
+	// using consistent hash ring, adding or removing target remaps
+	// only keys of its ring segments
+	HashBalancer struct {
+		logger    *logger.Logger
+		prefix    string
+		nodes     []ringNode
+		targets   []string
+		health    []bool
+		extractor *keyExtractor
+		mu        sync.RWMutex
+	}
+)
+
+func initHash(gateway *config.Gateway, logger *logger.Logger) (*HashBalancer, error) {
+	extractor, err := newKeyExtractor(gateway.Balancer.Hash)
+	if err != nil {
+		logger.Error("failed create hash key extractor",
+			zap.String("prefix", gateway.Name),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	replicas := gateway.Balancer.Hash.Replicas
+	if replicas <= 0 {
+		replicas = config.DefaultHashReplicas
+	}
+
+	hb := &HashBalancer{
+		logger:    logger,
+		prefix:    gateway.Name,
+		nodes:     make([]ringNode, 0, replicas*len(gateway.Targets)),
+		targets:   make([]string, len(gateway.Targets)),
+		health:    make([]bool, len(gateway.Targets)),
+		extractor: extractor,
+	}
+
+	for i, target := range gateway.Targets {
+		hb.targets[i] = target.Url
+		hb.health[i] = true
+
+		for replica := 0; replica < replicas; replica++ {
+			hb.nodes = append(hb.nodes, ringNode{
+				hash:   hashKey(target.Url + "#" + strconv.Itoa(replica)),
+				target: i,
+			})
+		}
+	}
+
+	sort.Slice(hb.nodes, func(i, j int) bool {
+		return hb.nodes[i].hash < hb.nodes[j].hash
+	})
+
+	return hb, nil
+}
+
+func (hb *HashBalancer) SetHealthInfo(healthy map[string]bool) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	changed := false
+
+	for i, url := range hb.targets {
+		isHealth, ok := healthy[url]
+		if !ok {
+			isHealth = true
+		}
+
+		if hb.health[i] != isHealth {
+			changed = true
+		}
+
+		hb.health[i] = isHealth
+	}
+
+	if changed {
+		hb.logger.Info("health status updated",
+			zap.String("prefix", hb.prefix))
+	}
+}
+
+func (hb *HashBalancer) SelectTarget(sel *Selection) (string, DoneFunc, error) {
+	hb.mu.RLock()
+	defer hb.mu.RUnlock()
+
+	if len(hb.nodes) == 0 {
+		return "", nil, errors.ErrNoHealthyTargets
+	}
+
+	hash := hashKey(hb.extractor.Extract(sel.Request))
+	start := sort.Search(len(hb.nodes), func(i int) bool {
+		return hb.nodes[i].hash >= hash
+	})
+
+	// walk clockwise to the first node of healthy target
+	for i := 0; i < len(hb.nodes); i++ {
+		node := hb.nodes[(start+i)%len(hb.nodes)]
+
+		if hb.health[node.target] {
+			return hb.targets[node.target], noopDone, nil
+		}
+	}
+
+	return "", nil, errors.ErrNoHealthyTargets
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	// fnv spreads close strings badly, so mix bits once more
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
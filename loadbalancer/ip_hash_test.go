@@ -0,0 +1,207 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/errors"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+func newTestHash(t *testing.T, hash config.HashConfig, urls ...string) *HashBalancer {
+	t.Helper()
+
+	gateway := &config.Gateway{
+		Prefix:   "/test",
+		Balancer: config.BalancerConfig{Alg: "iphash", Hash: hash},
+	}
+	for _, url := range urls {
+		gateway.Targets = append(gateway.Targets, config.Target{Url: url, Weight: 1})
+	}
+
+	hb, err := initHash(gateway, &logger.Logger{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("failed init hash balancer: %v", err)
+	}
+
+	return hb
+}
+
+// assign returns target of every key sent in header
+func assign(t *testing.T, hb *HashBalancer, keys int) map[string]string {
+	t.Helper()
+
+	targets := make(map[string]string, keys)
+
+	for i := 0; i < keys; i++ {
+		key := "user-" + strconv.Itoa(i)
+
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("X-User", key)
+
+		target, _, err := hb.SelectTarget(&Selection{Request: r})
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		targets[key] = target
+	}
+
+	return targets
+}
+
+func TestHashKeySources(t *testing.T) {
+	tests := []struct {
+		name string
+		hash config.HashConfig
+		// set puts the same key into two requests from different clients
+		set func(r *http.Request, i int)
+	}{
+		{
+			name: "header",
+			hash: config.HashConfig{Key: "header", Name: "X-User"},
+			set: func(r *http.Request, i int) {
+				r.Header.Set("X-User", "alice")
+				r.RemoteAddr = "10.0.0." + strconv.Itoa(i) + ":1234"
+			},
+		},
+		{
+			name: "cookie",
+			hash: config.HashConfig{Key: "cookie", Name: "session"},
+			set: func(r *http.Request, i int) {
+				r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+				r.RemoteAddr = "10.0.0." + strconv.Itoa(i) + ":1234"
+			},
+		},
+		{
+			name: "ip",
+			hash: config.HashConfig{Key: "ip"},
+			set: func(r *http.Request, i int) {
+				r.RemoteAddr = "10.0.0.1:" + strconv.Itoa(1000+i)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb := newTestHash(t, tt.hash, "a", "b", "c", "d", "e")
+
+			var first string
+
+			for i := 0; i < 20; i++ {
+				r := httptest.NewRequest("GET", "/test", nil)
+				tt.set(r, i)
+
+				target, _, err := hb.SelectTarget(&Selection{Request: r})
+				if err != nil {
+					t.Fatalf("failed select target: %v", err)
+				}
+
+				if i == 0 {
+					first = target
+				}
+
+				if target != first {
+					t.Fatalf("request %d got target %s, want %s", i, target, first)
+				}
+			}
+		})
+	}
+}
+
+func TestHashRingRemapping(t *testing.T) {
+	const keys = 2000
+
+	hash := config.HashConfig{Key: "header", Name: "X-User"}
+
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+		// moved is target which gets or loses keys, others keep theirs
+		moved string
+	}{
+		{
+			name:   "target removed",
+			before: []string{"a", "b", "c", "d"},
+			after:  []string{"a", "b", "c"},
+			moved:  "d",
+		},
+		{
+			name:   "target added",
+			before: []string{"a", "b", "c"},
+			after:  []string{"a", "b", "c", "d"},
+			moved:  "d",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := assign(t, newTestHash(t, hash, tt.before...), keys)
+			after := assign(t, newTestHash(t, hash, tt.after...), keys)
+
+			changed := 0
+
+			for key, target := range before {
+				if after[key] == target {
+					continue
+				}
+
+				changed++
+
+				if target != tt.moved && after[key] != tt.moved {
+					t.Errorf("key %s moved from %s to %s", key, target, after[key])
+				}
+			}
+
+			// about a quarter of keys belongs to moved target
+			if changed == 0 || changed > keys/2 {
+				t.Errorf("%d of %d keys remapped", changed, keys)
+			}
+		})
+	}
+}
+
+func TestHashUnhealthyTarget(t *testing.T) {
+	const keys = 1000
+
+	hb := newTestHash(t, config.HashConfig{Key: "header", Name: "X-User"}, "a", "b", "c")
+
+	before := assign(t, hb, keys)
+
+	hb.SetHealthInfo(map[string]bool{"b": false})
+	during := assign(t, hb, keys)
+
+	for key, target := range during {
+		if target == "b" {
+			t.Fatalf("key %s sent to unhealthy target", key)
+		}
+
+		if before[key] != "b" && before[key] != target {
+			t.Errorf("key %s moved from healthy %s to %s", key, before[key], target)
+		}
+	}
+
+	hb.SetHealthInfo(map[string]bool{"b": true})
+	after := assign(t, hb, keys)
+
+	for key, target := range before {
+		if after[key] != target {
+			t.Errorf("key %s got %s after recovery, want %s", key, after[key], target)
+		}
+	}
+}
+
+func TestHashNoHealthyTargets(t *testing.T) {
+	hb := newTestHash(t, config.HashConfig{Key: "ip"}, "a", "b")
+	hb.SetHealthInfo(map[string]bool{"a": false, "b": false})
+
+	_, _, err := hb.SelectTarget(&Selection{Request: httptest.NewRequest("GET", "/test", nil)})
+	if err != errors.ErrNoHealthyTargets {
+		t.Errorf("got error %v, want %v", err, errors.ErrNoHealthyTargets)
+	}
+}
@@ -0,0 +1,134 @@
+package loadbalancer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/errors"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+type (
+	lcexpTarget struct {
+		rampTarget
+		health   bool
+		inflight int
+	}
+
+	// LeastConnBalancer sends every request to the healthy target
+	// with the smallest number of in-flight requests per unit of weight
+	LeastConnBalancer struct {
+		logger    *logger.Logger
+		prefix    string
+		targets   []lcexpTarget
+		slowStart *slowStart
+		// next is used to break ties between targets with equal load
+		next int
+		mu   sync.Mutex
+	}
+)
+
+func initLeastConn(gateway *config.Gateway, logger *logger.Logger) *LeastConnBalancer {
+	targets := make([]lcexpTarget, len(gateway.Targets))
+
+	for i, target := range gateway.Targets {
+		targets[i] = lcexpTarget{
+			rampTarget: rampTarget{
+				url:    target.Url,
+				weight: max(target.Weight, 1),
+			},
+			health: true,
+		}
+	}
+
+	// targets seen for the first time ramp up like recovered ones
+	ss := newSlowStart(gateway)
+	now := time.Now()
+	for i := range targets {
+		ss.start(&targets[i].rampTarget, now)
+	}
+
+	return &LeastConnBalancer{
+		logger:    logger,
+		prefix:    gateway.Name,
+		targets:   targets,
+		slowStart: ss,
+	}
+}
+
+func (lcb *LeastConnBalancer) SetHealthInfo(healthy map[string]bool) {
+	lcb.mu.Lock()
+	defer lcb.mu.Unlock()
+
+	changed := false
+	now := time.Now()
+
+	for i := range lcb.targets {
+		isHealth, ok := healthy[lcb.targets[i].url]
+		if !ok {
+			isHealth = true
+		}
+
+		if lcb.targets[i].health != isHealth {
+			changed = true
+		}
+
+		if !lcb.targets[i].health && isHealth {
+			lcb.slowStart.start(&lcb.targets[i].rampTarget, now)
+		}
+
+		lcb.targets[i].health = isHealth
+		lcb.slowStart.refresh(&lcb.targets[i].rampTarget, now)
+	}
+
+	if changed {
+		lcb.logger.Info("health status updated",
+			zap.String("prefix", lcb.prefix))
+	}
+}
+
+// SelectTarget picks the least loaded healthy target and counts
+// the request as in-flight until returned DoneFunc is called
+func (lcb *LeastConnBalancer) SelectTarget(_ *Selection) (string, DoneFunc, error) {
+	lcb.mu.Lock()
+	defer lcb.mu.Unlock()
+
+	now := time.Now()
+
+	best := -1
+	bestLoad := 0.0
+	for i := range lcb.targets {
+		idx := (lcb.next + i) % len(lcb.targets)
+		target := &lcb.targets[idx]
+
+		if !target.health {
+			continue
+		}
+
+		load := float64(target.inflight+1) / lcb.slowStart.effective(&target.rampTarget, now)
+		if best == -1 || load < bestLoad {
+			best = idx
+			bestLoad = load
+		}
+	}
+
+	if best == -1 {
+		return "", nil, errors.ErrNoHealthyTargets
+	}
+
+	lcb.targets[best].inflight++
+	lcb.next = (best + 1) % len(lcb.targets)
+
+	done := func(DoneInfo) {
+		lcb.mu.Lock()
+		defer lcb.mu.Unlock()
+
+		if lcb.targets[best].inflight > 0 {
+			lcb.targets[best].inflight--
+		}
+	}
+
+	return lcb.targets[best].url, done, nil
+}
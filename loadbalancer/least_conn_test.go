@@ -0,0 +1,162 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/osamikoyo/orion/config"
+)
+
+// inflight returns in-flight requests of targets by url
+func (lcb *LeastConnBalancer) inflight() map[string]int {
+	lcb.mu.Lock()
+	defer lcb.mu.Unlock()
+
+	counts := make(map[string]int, len(lcb.targets))
+	for _, target := range lcb.targets {
+		counts[target.url] = target.inflight
+	}
+
+	return counts
+}
+
+func TestLeastConnInflight(t *testing.T) {
+	lcb, sel := newTestBalancer(initLeastConn,
+		config.Target{Url: "a", Weight: 1},
+		config.Target{Url: "b", Weight: 1},
+	)
+
+	var dones []DoneFunc
+
+	for i := 0; i < 4; i++ {
+		_, done, err := lcb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		dones = append(dones, done)
+	}
+
+	if got := lcb.inflight(); got["a"] != 2 || got["b"] != 2 {
+		t.Fatalf("in-flight %v after select, want 2 on every target", got)
+	}
+
+	for _, done := range dones {
+		done(DoneInfo{Status: http.StatusOK})
+	}
+
+	// extra call of done must not make count negative
+	dones[0](DoneInfo{Status: http.StatusOK})
+
+	if got := lcb.inflight(); got["a"] != 0 || got["b"] != 0 {
+		t.Errorf("in-flight %v after done, want 0 on every target", got)
+	}
+}
+
+func TestLeastConnSelect(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []config.Target
+		// busy stores in-flight requests of targets before selection
+		busy map[string]int
+		want string
+	}{
+		{
+			name:    "fewer requests",
+			targets: []config.Target{{Url: "a", Weight: 1}, {Url: "b", Weight: 1}, {Url: "c", Weight: 1}},
+			busy:    map[string]int{"a": 2, "b": 1, "c": 3},
+			want:    "b",
+		},
+		{
+			// load of a is 3/3 against 2/1 of b
+			name:    "heavier target",
+			targets: []config.Target{{Url: "a", Weight: 3}, {Url: "b", Weight: 1}},
+			busy:    map[string]int{"a": 2, "b": 1},
+			want:    "a",
+		},
+		{
+			// load of a is 7/3 against 2/1 of b
+			name:    "heavier target is full",
+			targets: []config.Target{{Url: "a", Weight: 3}, {Url: "b", Weight: 1}},
+			busy:    map[string]int{"a": 6, "b": 1},
+			want:    "b",
+		},
+		{
+			name:    "zero weight counts as one",
+			targets: []config.Target{{Url: "a", Weight: 0}, {Url: "b", Weight: 2}},
+			busy:    map[string]int{"a": 0, "b": 2},
+			want:    "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lcb, sel := newTestBalancer(initLeastConn, tt.targets...)
+
+			for i := range lcb.targets {
+				lcb.targets[i].inflight = tt.busy[lcb.targets[i].url]
+			}
+
+			target, _, err := lcb.SelectTarget(sel)
+			if err != nil {
+				t.Fatalf("failed select target: %v", err)
+			}
+
+			if target != tt.want {
+				t.Errorf("selected %s, want %s", target, tt.want)
+			}
+
+			if got := lcb.inflight()[target]; got != tt.busy[target]+1 {
+				t.Errorf("in-flight of %s is %d, want %d", target, got, tt.busy[target]+1)
+			}
+		})
+	}
+}
+
+func TestLeastConnDistribution(t *testing.T) {
+	lcb, sel := newTestBalancer(initLeastConn,
+		config.Target{Url: "a", Weight: 3},
+		config.Target{Url: "b", Weight: 1},
+	)
+
+	// requests are not finished, so load is spread by weight
+	counts := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		target, _, err := lcb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		counts[target]++
+	}
+
+	if counts["a"] != 300 || counts["b"] != 100 {
+		t.Errorf("got %v, want 300 requests on a and 100 on b", counts)
+	}
+}
+
+func TestLeastConnUnhealthy(t *testing.T) {
+	lcb, sel := newTestBalancer(initLeastConn,
+		config.Target{Url: "a", Weight: 1},
+		config.Target{Url: "b", Weight: 1},
+	)
+
+	lcb.SetHealthInfo(map[string]bool{"a": false})
+
+	for i := 0; i < 4; i++ {
+		target, _, err := lcb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		if target != "b" {
+			t.Fatalf("unhealthy target %s is selected", target)
+		}
+	}
+
+	lcb.SetHealthInfo(map[string]bool{"a": false, "b": false})
+
+	if _, _, err := lcb.SelectTarget(sel); err == nil {
+		t.Errorf("target is selected when all targets are unhealthy")
+	}
+}
@@ -3,63 +3,136 @@ package loadbalancer
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/osamikoyo/orion/config"
 	"github.com/osamikoyo/orion/errors"
 	"github.com/osamikoyo/orion/healthchecker"
 	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/metrics"
 	"go.uber.org/zap"
 )
 
 const MaxLoad = math.MaxInt32
 
 type (
+	// Selection stores everything balancer may use to select target
+	Selection struct {
+		Request *http.Request
+		Gateway *config.Gateway
+	}
+
+	// DoneInfo describes finished request to selected target
+	DoneInfo struct {
+		Latency time.Duration
+		// Status is status code of upstream response
+		Status int
+		// Err is set when upstream could not be reached
+		Err error
+	}
+
+	// DoneFunc must be called once when request to selected target is finished
+	DoneFunc func(info DoneInfo)
+
 	Balancer interface {
-		SelectTarget(prefix string) (string, error)
+		SelectTarget(sel *Selection) (string, DoneFunc, error)
 		SetHealthInfo(health map[string]bool)
 	}
 
 	LoadBalancer struct {
-		balancer      Balancer
+		// balancers stores balancer of every gateway by name,
+		// gateway with pools has balancer for every pool instead
+		balancers     map[string]Balancer
 		logger        *logger.Logger
 		healthchecker *healthchecker.HealthChecker
+		// gateways stores gateway config by name of balancer
+		gateways map[string]*config.Gateway
+		// outliers stores passive health checking by name of balancer
+		outliers map[string]*outlierDetector
+		// splits stores splitter of every gateway with pools
+		splits map[string]*splitter
+		// parents stores name of configured gateway by name of balancer,
+		// it differs from name of balancer for pools
+		parents map[string]string
+
+		// activeHealth stores last results of health checker by gateway name
+		activeHealth map[string]map[string]bool
+		healthMu     sync.Mutex
 	}
 )
 
 func NewLoadBalancer(cfg *config.Config, logger *logger.Logger) (*LoadBalancer, context.CancelFunc, error) {
+	hc, err := healthchecker.NewHealthChecker(cfg, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	loadbalancer := &LoadBalancer{
 		logger:        logger,
-		healthchecker: healthchecker.NewHealthChecker(cfg, logger),
+		healthchecker: hc,
+		gateways:      make(map[string]*config.Gateway, len(cfg.Gateways)),
+		balancers:     make(map[string]Balancer, len(cfg.Gateways)),
+		outliers:      make(map[string]*outlierDetector, len(cfg.Gateways)),
+		splits:        make(map[string]*splitter),
+		parents:       make(map[string]string, len(cfg.Gateways)),
 	}
 
-	switch cfg.LoadBalancerAlg {
-	case "wrr":
-		loadbalancer.balancer = initWeightRoundRobin(cfg, logger)
-	case "rr":
-		loadbalancer.balancer = initRoundRobin(cfg, logger)
-	default:
-		logger.Error("unknown load balancer algorithm",
-			zap.String("alg", cfg.LoadBalancerAlg))
+	for i := range cfg.Gateways {
+		gateway := &cfg.Gateways[i]
+
+		// aggregation gateway has no targets to balance
+		if gateway.IsAggregate() {
+			continue
+		}
+
+		if len(gateway.Pools) == 0 {
+			if err := loadbalancer.addBalancer(gateway, gateway.Name); err != nil {
+				return nil, nil, err
+			}
 
-		return nil, nil, errors.ErrNoHealthyTargets
+			continue
+		}
+
+		split, err := newSplitter(gateway)
+		if err != nil {
+			logger.Error("failed create traffic split",
+				zap.String("gateway", gateway.Name),
+				zap.Error(err))
+
+			return nil, nil, err
+		}
+
+		loadbalancer.splits[gateway.Name] = split
+
+		for j := range gateway.Pools {
+			if err := loadbalancer.addBalancer(poolGateway(gateway, &gateway.Pools[j]), gateway.Name); err != nil {
+				return nil, nil, err
+			}
+		}
 	}
 
-	health := make(chan map[string]bool, 1)
+	health := make(chan map[string]map[string]bool, 1)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	go loadbalancer.runHealthCheck(ctx, health, cfg.HealthCheckTimeout)
+	go loadbalancer.healthchecker.Run(ctx, health)
 	go func() {
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case healthinfo := <-health:
-				loadbalancer.balancer.SetHealthInfo(healthinfo)
+				loadbalancer.healthMu.Lock()
+				loadbalancer.activeHealth = healthinfo
+				loadbalancer.healthMu.Unlock()
+
+				for name := range loadbalancer.balancers {
+					loadbalancer.applyHealth(name)
+				}
 			}
 		}
 	}()
@@ -67,25 +140,137 @@ func NewLoadBalancer(cfg *config.Config, logger *logger.Logger) (*LoadBalancer,
 	return loadbalancer, cancel, nil
 }
 
-func (lb *LoadBalancer) runHealthCheck(ctx context.Context, output chan map[string]bool, dur time.Duration) {
-	ticker := time.NewTicker(dur)
+// addBalancer creates balancer and outlier detection of gateway,
+// parent is name of configured gateway whose health results are applied
+func (lb *LoadBalancer) addBalancer(gateway *config.Gateway, parent string) error {
+	balancer, err := newBalancer(gateway, lb.logger)
+	if err != nil {
+		return err
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
+	name := gateway.Name
 
-			return
+	lb.gateways[name] = gateway
+	lb.balancers[name] = balancer
+	lb.parents[name] = parent
+	lb.outliers[name] = newOutlierDetector(gateway, lb.logger, func() {
+		lb.applyHealth(name)
+	})
 
-		case <-ticker.C:
-			output <- lb.healthchecker.Check(ctx)
-		}
+	return nil
+}
+
+// newBalancer creates balancer with algorithm configured for gateway
+func newBalancer(gateway *config.Gateway, logger *logger.Logger) (Balancer, error) {
+	switch gateway.Balancer.Alg {
+	case "wrr":
+		return initWeightRoundRobin(gateway, logger), nil
+	case "rr", "roundrobin":
+		return initRoundRobin(gateway, logger), nil
+	case "leastconn":
+		return initLeastConn(gateway, logger), nil
+	case "iphash":
+		return initHash(gateway, logger)
+	case "p2c":
+		return initP2C(gateway, logger, false), nil
+	case "ewma":
+		return initP2C(gateway, logger, true), nil
+	default:
+		logger.Error("unknown load balancer algorithm",
+			zap.String("prefix", gateway.Name),
+			zap.String("alg", gateway.Balancer.Alg))
+
+		return nil, errors.ErrUnknownAlg
+	}
+}
+
+// SelectPool splits request between pools of named gateway and returns
+// name of balancer of chosen pool, it is gateway name for gateway
+// without pools. Pool should be selected once for every request,
+// so all attempts of request go to the same pool
+func (lb *LoadBalancer) SelectPool(r *http.Request, name string) string {
+	split, ok := lb.splits[name]
+	if !ok {
+		return name
+	}
+
+	pool := split.choose(r)
+
+	metrics.SplitRequests.WithLabelValues(name, pool).Inc()
+
+	return poolKey(name, pool)
+}
+
+// SetPoolWeights changes split of named gateway between its pools at runtime
+func (lb *LoadBalancer) SetPoolWeights(name string, weights map[string]int) error {
+	split, ok := lb.splits[name]
+	if !ok {
+		return fmt.Errorf("gateway %s has no pools", name)
+	}
+
+	if err := config.ValidatePoolWeights(split.gateway.Pools, weights); err != nil {
+		return fmt.Errorf("invalid weights of gateway %s: %v", name, err)
+	}
+
+	split.setWeights(weights)
+
+	lb.logger.Info("weights of pools were changed",
+		zap.String("gateway", name),
+		zap.Any("weights", weights))
+
+	return nil
+}
+
+// Balance selects target for request by balancer of gateway or pool
+// returned by SelectPool, returned DoneFunc must be called when the
+// upstream response is finished
+func (lb *LoadBalancer) Balance(r *http.Request, name string) (string, DoneFunc, error) {
+	gateway, ok := lb.gateways[name]
+	if !ok {
+		lb.logger.Error("could not found gateway",
+			zap.String("gateway", name),
+			zap.String("path", r.URL.Path))
+
+		return "", nil, errors.ErrPrefixNotFound
+	}
+
+	target, done, err := lb.balancers[name].SelectTarget(&Selection{
+		Request: r,
+		Gateway: gateway,
+	})
+	if err != nil {
+		return "", nil, err
 	}
+
+	outliers := lb.outliers[name]
+	if outliers == nil {
+		return target, done, nil
+	}
+
+	return target, func(info DoneInfo) {
+		done(info)
+		outliers.observe(target, info)
+	}, nil
 }
 
-func (lb *LoadBalancer) Balance(r *http.Request) (string, error) {
-	parts := strings.Split(r.URL.Path, "/")
+// applyHealth merges results of health checker with
+// ejected targets and passes them to balancer of named gateway
+func (lb *LoadBalancer) applyHealth(name string) {
+	lb.healthMu.Lock()
+	defer lb.healthMu.Unlock()
 
-	prefix := "/" + parts[1]
+	active := lb.activeHealth[lb.parents[name]]
+
+	health := make(map[string]bool, len(active))
+	for url, healthy := range active {
+		health[url] = healthy
+	}
 
-	return lb.balancer.SelectTarget(prefix)
+	for _, url := range lb.outliers[name].ejected() {
+		health[url] = false
+	}
+
+	lb.balancers[name].SetHealthInfo(health)
 }
+
+func noopDone(DoneInfo) {}
@@ -0,0 +1,167 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/errors"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+// newTestBalancer creates balancer of gateway with targets by init
+// and selection of request to this gateway
+func newTestBalancer[B Balancer](init func(*config.Gateway, *logger.Logger) B, targets ...config.Target) (B, *Selection) {
+	gateway := &config.Gateway{
+		Prefix:  "/test",
+		Targets: targets,
+	}
+
+	sel := &Selection{
+		Request: httptest.NewRequest("GET", "/test", nil),
+		Gateway: gateway,
+	}
+
+	return init(gateway, &logger.Logger{Logger: zap.NewNop()}), sel
+}
+
+// newTestLoadBalancer creates load balancer without health checker
+func newTestLoadBalancer(t *testing.T, gateways ...*config.Gateway) *LoadBalancer {
+	t.Helper()
+
+	lb := &LoadBalancer{
+		logger:    &logger.Logger{Logger: zap.NewNop()},
+		balancers: make(map[string]Balancer),
+		gateways:  make(map[string]*config.Gateway),
+		outliers:  make(map[string]*outlierDetector),
+		splits:    make(map[string]*splitter),
+		parents:   make(map[string]string),
+	}
+
+	for _, gateway := range gateways {
+		if len(gateway.Pools) == 0 {
+			if err := lb.addBalancer(gateway, gateway.Name); err != nil {
+				t.Fatalf("failed add balancer: %v", err)
+			}
+
+			continue
+		}
+
+		split, err := newSplitter(gateway)
+		if err != nil {
+			t.Fatalf("failed create split: %v", err)
+		}
+
+		lb.splits[gateway.Name] = split
+
+		for i := range gateway.Pools {
+			if err := lb.addBalancer(poolGateway(gateway, &gateway.Pools[i]), gateway.Name); err != nil {
+				t.Fatalf("failed add balancer: %v", err)
+			}
+		}
+	}
+
+	return lb
+}
+
+func TestNewBalancer(t *testing.T) {
+	tests := []struct {
+		alg  string
+		want any
+	}{
+		{alg: "wrr", want: &WeightRoundRobinBalancer{}},
+		{alg: "rr", want: &RoundRobinBalancer{}},
+		{alg: "roundrobin", want: &RoundRobinBalancer{}},
+		{alg: "leastconn", want: &LeastConnBalancer{}},
+		{alg: "iphash", want: &HashBalancer{}},
+		{alg: "p2c", want: &P2CBalancer{}},
+		{alg: "ewma", want: &P2CBalancer{useLatency: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			gateway := &config.Gateway{
+				Name:     "test",
+				Targets:  []config.Target{{Url: "a", Weight: 1}},
+				Balancer: config.BalancerConfig{Alg: tt.alg, Hash: config.HashConfig{Key: "ip"}},
+			}
+
+			balancer, err := newBalancer(gateway, &logger.Logger{Logger: zap.NewNop()})
+			if err != nil {
+				t.Fatalf("failed create balancer: %v", err)
+			}
+
+			if got, want := fmt.Sprintf("%T", balancer), fmt.Sprintf("%T", tt.want); got != want {
+				t.Fatalf("got balancer %s, want %s", got, want)
+			}
+
+			if want, ok := tt.want.(*P2CBalancer); ok && balancer.(*P2CBalancer).useLatency != want.useLatency {
+				t.Errorf("balancer uses latency %v, want %v", !want.useLatency, want.useLatency)
+			}
+		})
+	}
+}
+
+func TestNewBalancerUnknownAlg(t *testing.T) {
+	gateway := &config.Gateway{
+		Name:     "test",
+		Targets:  []config.Target{{Url: "a", Weight: 1}},
+		Balancer: config.BalancerConfig{Alg: "random"},
+	}
+
+	if _, err := newBalancer(gateway, &logger.Logger{Logger: zap.NewNop()}); err != errors.ErrUnknownAlg {
+		t.Errorf("got error %v, want %v", err, errors.ErrUnknownAlg)
+	}
+}
+
+func TestBalancePerGateway(t *testing.T) {
+	// the same targets are balanced differently by gateways
+	targets := []config.Target{{Url: "a", Weight: 3}, {Url: "b", Weight: 1}}
+
+	rr := &config.Gateway{Name: "rr", Targets: targets, Balancer: config.BalancerConfig{Alg: "rr"}}
+	hash := &config.Gateway{Name: "hash", Targets: targets, Balancer: config.BalancerConfig{Alg: "iphash"}}
+
+	lb := newTestLoadBalancer(t, rr, hash)
+
+	tests := []struct {
+		gateway string
+		want    map[string]int
+		// sticky is true when all requests of client go to one target
+		sticky bool
+	}{
+		{gateway: "rr", want: map[string]int{"a": 4, "b": 4}},
+		{gateway: "hash", sticky: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.gateway, func(t *testing.T) {
+			counts := make(map[string]int)
+
+			for i := 0; i < 8; i++ {
+				target, done, err := lb.Balance(httptest.NewRequest("GET", "/test", nil), tt.gateway)
+				if err != nil {
+					t.Fatalf("failed select target: %v", err)
+				}
+
+				done(DoneInfo{Status: 200})
+				counts[target]++
+			}
+
+			if tt.sticky && len(counts) != 1 {
+				t.Errorf("requests of client are spread over targets: %v", counts)
+			}
+
+			for target, want := range tt.want {
+				if counts[target] != want {
+					t.Errorf("target %s got %d requests, want %d", target, counts[target], want)
+				}
+			}
+		})
+	}
+
+	if _, _, err := lb.Balance(httptest.NewRequest("GET", "/test", nil), "missing"); err != errors.ErrPrefixNotFound {
+		t.Errorf("got error %v for unknown gateway, want %v", err, errors.ErrPrefixNotFound)
+	}
+}
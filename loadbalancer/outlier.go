@@ -0,0 +1,259 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+type (
+	outlierTarget struct {
+		fails5xx int
+		errors   int
+		slow     int
+		// latency is ewma of response latency in nanoseconds, zero when target
+		// has no observations, it is compared with latency of other targets
+		latency    float64
+		lastUpdate time.Time
+		// ejections is count of ejections in a row, it makes next ejection longer
+		ejections    int
+		ejectedUntil time.Time
+		returnedAt   time.Time
+	}
+
+	// outlierDetector ejects targets of gateway which fail live requests
+	// or answer much slower than other targets of gateway
+	outlierDetector struct {
+		cfg     config.OutlierConfig
+		prefix  string
+		logger  *logger.Logger
+		targets map[string]*outlierTarget
+		// decay is time after which old latency observations lose most of their weight
+		decay time.Duration
+		// onChange is called when set of ejected targets is changed
+		onChange func()
+		mu       sync.Mutex
+	}
+)
+
+// newOutlierDetector returns nil when passive health checking is disabled for gateway
+func newOutlierDetector(gateway *config.Gateway, logger *logger.Logger, onChange func()) *outlierDetector {
+	cfg := gateway.Outlier
+	if cfg.Consecutive5xx == 0 && cfg.ConsecutiveErrors == 0 && cfg.ConsecutiveSlow == 0 {
+		return nil
+	}
+
+	if cfg.BaseEjection <= 0 {
+		cfg.BaseEjection = config.DefaultBaseEjection
+	}
+	if cfg.MaxEjection < cfg.BaseEjection {
+		cfg.MaxEjection = max(config.DefaultMaxEjection, cfg.BaseEjection)
+	}
+	if cfg.LatencyFactor <= 1 {
+		cfg.LatencyFactor = config.DefaultLatencyFactor
+	}
+
+	decay := gateway.Balancer.EWMADecay
+	if decay <= 0 {
+		decay = config.DefaultEWMADecay
+	}
+
+	targets := make(map[string]*outlierTarget, len(gateway.Targets))
+	for _, target := range gateway.Targets {
+		targets[target.Url] = &outlierTarget{}
+	}
+
+	return &outlierDetector{
+		cfg:      cfg,
+		prefix:   gateway.Name,
+		logger:   logger,
+		targets:  targets,
+		decay:    decay,
+		onChange: onChange,
+	}
+}
+
+// observe counts result of request to target and ejects target
+// when one of consecutive thresholds is reached
+func (od *outlierDetector) observe(url string, info DoneInfo) {
+	// status is empty when upstream was not called, canceled
+	// request was abandoned by client and says nothing about target
+	if od == nil || (info.Status == 0 && info.Err == nil) || errors.Is(info.Err, context.Canceled) {
+		return
+	}
+
+	od.mu.Lock()
+
+	target, ok := od.targets[url]
+	if !ok {
+		od.mu.Unlock()
+
+		return
+	}
+
+	switch {
+	case info.Err != nil:
+		target.errors++
+	case info.Status >= 500:
+		target.fails5xx++
+		target.errors = 0
+	default:
+		target.fails5xx = 0
+		target.errors = 0
+	}
+
+	// latency of failed request says nothing about speed of target
+	if od.cfg.ConsecutiveSlow > 0 && info.Err == nil {
+		if od.isSlow(url, info.Latency) {
+			target.slow++
+		} else {
+			target.slow = 0
+		}
+
+		od.observeLatency(target, info.Latency, time.Now())
+	}
+
+	reason := ""
+	switch {
+	case od.cfg.ConsecutiveErrors > 0 && target.errors >= od.cfg.ConsecutiveErrors:
+		reason = "connection errors"
+	case od.cfg.Consecutive5xx > 0 && target.fails5xx >= od.cfg.Consecutive5xx:
+		reason = "5xx responses"
+	case od.cfg.ConsecutiveSlow > 0 && target.slow >= od.cfg.ConsecutiveSlow:
+		reason = "slow responses"
+	}
+
+	if reason == "" || !od.eject(url, target, reason) {
+		od.mu.Unlock()
+
+		return
+	}
+
+	od.mu.Unlock()
+
+	od.onChange()
+}
+
+// isSlow must be called with locked mutex, it returns true when latency is
+// bigger than latency factor times median latency of other targets
+func (od *outlierDetector) isSlow(url string, latency time.Duration) bool {
+	if latency <= od.cfg.MinLatency {
+		return false
+	}
+
+	peers := make([]float64, 0, len(od.targets))
+	for peer, t := range od.targets {
+		if peer != url && t.latency != 0 {
+			peers = append(peers, t.latency)
+		}
+	}
+
+	// target without observed peers has nothing to be compared with
+	if len(peers) == 0 {
+		return false
+	}
+
+	slices.Sort(peers)
+
+	median := peers[len(peers)/2]
+	if len(peers)%2 == 0 {
+		median = (peers[len(peers)/2-1] + median) / 2
+	}
+
+	return float64(latency) > od.cfg.LatencyFactor*median
+}
+
+// observeLatency must be called with locked mutex, it blends latency into ewma of target
+func (od *outlierDetector) observeLatency(target *outlierTarget, latency time.Duration, now time.Time) {
+	if target.latency == 0 {
+		target.latency = float64(latency)
+	} else {
+		w := math.Exp(-float64(now.Sub(target.lastUpdate)) / float64(od.decay))
+		target.latency = target.latency*w + float64(latency)*(1-w)
+	}
+
+	target.lastUpdate = now
+}
+
+// eject must be called with locked mutex, it returns false when target
+// is already ejected or too many targets of gateway are ejected
+func (od *outlierDetector) eject(url string, target *outlierTarget, reason string) bool {
+	now := time.Now()
+
+	if now.Before(target.ejectedUntil) {
+		return false
+	}
+
+	ejected := 0
+	for _, t := range od.targets {
+		if now.Before(t.ejectedUntil) {
+			ejected++
+		}
+	}
+
+	allowed := max(1, len(od.targets)*od.cfg.MaxEjectedPercent/100)
+	if ejected >= allowed {
+		od.logger.Warn("outlier was not ejected, max ejected percent is reached",
+			zap.String("prefix", od.prefix),
+			zap.String("target", url))
+
+		return false
+	}
+
+	// target which stayed in pool long enough starts back-off from scratch
+	if !target.returnedAt.IsZero() && now.Sub(target.returnedAt) > od.cfg.MaxEjection {
+		target.ejections = 0
+	}
+
+	duration := od.cfg.BaseEjection << target.ejections
+	if duration <= 0 || duration > od.cfg.MaxEjection {
+		duration = od.cfg.MaxEjection
+	} else {
+		target.ejections++
+	}
+
+	target.ejectedUntil = now.Add(duration)
+	target.returnedAt = target.ejectedUntil
+	target.fails5xx, target.errors, target.slow = 0, 0, 0
+	// latency of ejected target must not raise median of its peers
+	target.latency = 0
+
+	od.logger.Warn("target was ejected",
+		zap.String("prefix", od.prefix),
+		zap.String("target", url),
+		zap.String("reason", reason),
+		zap.Duration("duration", duration))
+
+	// return target to pool when ejection is over
+	time.AfterFunc(duration, od.onChange)
+
+	return true
+}
+
+// ejected returns targets which must not receive requests now
+func (od *outlierDetector) ejected() []string {
+	if od == nil {
+		return nil
+	}
+
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	now := time.Now()
+
+	var urls []string
+	for url, target := range od.targets {
+		if now.Before(target.ejectedUntil) {
+			urls = append(urls, url)
+		}
+	}
+
+	return urls
+}
@@ -0,0 +1,289 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+func newTestOutlier(cfg config.OutlierConfig, urls ...string) (*outlierDetector, *int) {
+	gateway := &config.Gateway{Name: "test", Outlier: cfg}
+	for _, url := range urls {
+		gateway.Targets = append(gateway.Targets, config.Target{Url: url, Weight: 1})
+	}
+
+	changes := 0
+
+	return newOutlierDetector(gateway, &logger.Logger{Logger: zap.NewNop()}, func() { changes++ }), &changes
+}
+
+func TestOutlierDisabled(t *testing.T) {
+	od, _ := newTestOutlier(config.OutlierConfig{}, "a")
+	if od != nil {
+		t.Fatalf("detector created without thresholds")
+	}
+
+	// nil detector ignores results
+	od.observe("a", DoneInfo{Status: 500})
+
+	if ejected := od.ejected(); len(ejected) != 0 {
+		t.Errorf("ejected %v by disabled detector", ejected)
+	}
+}
+
+func TestOutlierEjection(t *testing.T) {
+	connErr := fmt.Errorf("connection refused")
+
+	tests := []struct {
+		name  string
+		cfg   config.OutlierConfig
+		infos []DoneInfo
+		want  bool
+	}{
+		{
+			name:  "consecutive 5xx",
+			cfg:   config.OutlierConfig{Consecutive5xx: 3},
+			infos: []DoneInfo{{Status: 500}, {Status: 502}, {Status: 503}},
+			want:  true,
+		},
+		{
+			name:  "success resets 5xx",
+			cfg:   config.OutlierConfig{Consecutive5xx: 3},
+			infos: []DoneInfo{{Status: 500}, {Status: 500}, {Status: 200}, {Status: 500}},
+			want:  false,
+		},
+		{
+			name:  "4xx is not failure",
+			cfg:   config.OutlierConfig{Consecutive5xx: 2},
+			infos: []DoneInfo{{Status: 500}, {Status: 404}, {Status: 500}},
+			want:  false,
+		},
+		{
+			name:  "consecutive connection errors",
+			cfg:   config.OutlierConfig{ConsecutiveErrors: 2},
+			infos: []DoneInfo{{Err: connErr}, {Err: connErr}},
+			want:  true,
+		},
+		{
+			name:  "canceled requests are ignored",
+			cfg:   config.OutlierConfig{ConsecutiveErrors: 2},
+			infos: []DoneInfo{{Err: context.Canceled}, {Err: fmt.Errorf("read body: %w", context.Canceled)}, {Err: connErr}},
+			want:  false,
+		},
+		{
+			name:  "skipped requests are ignored",
+			cfg:   config.OutlierConfig{Consecutive5xx: 2},
+			infos: []DoneInfo{{Status: 500}, {}, {}, {Status: 500}},
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.MaxEjectedPercent = 100
+
+			od, changes := newTestOutlier(tt.cfg, "a", "b")
+
+			for _, info := range tt.infos {
+				od.observe("a", info)
+			}
+
+			ejected := slices.Contains(od.ejected(), "a")
+			if ejected != tt.want {
+				t.Fatalf("target ejected %v, want %v", ejected, tt.want)
+			}
+
+			if tt.want && *changes != 1 {
+				t.Errorf("got %d changes, want 1", *changes)
+			}
+		})
+	}
+}
+
+func TestOutlierLatency(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.OutlierConfig
+		// peer is latency of the other targets, zero when they are not observed
+		peer      time.Duration
+		latencies []time.Duration
+		err       error
+		want      bool
+	}{
+		{
+			name:      "slower than peers",
+			peer:      100 * time.Millisecond,
+			latencies: []time.Duration{400 * time.Millisecond, 500 * time.Millisecond},
+			want:      true,
+		},
+		{
+			name:      "fast response resets slow",
+			peer:      100 * time.Millisecond,
+			latencies: []time.Duration{400 * time.Millisecond, 100 * time.Millisecond, 400 * time.Millisecond},
+			want:      false,
+		},
+		{
+			name:      "peers are slow too",
+			peer:      time.Second,
+			latencies: []time.Duration{2 * time.Second, 2 * time.Second},
+			want:      false,
+		},
+		{
+			name:      "custom latency factor",
+			cfg:       config.OutlierConfig{LatencyFactor: 1.5},
+			peer:      time.Second,
+			latencies: []time.Duration{2 * time.Second, 2 * time.Second},
+			want:      true,
+		},
+		{
+			name:      "faster than min latency",
+			cfg:       config.OutlierConfig{MinLatency: 10 * time.Millisecond},
+			peer:      time.Millisecond,
+			latencies: []time.Duration{5 * time.Millisecond, 5 * time.Millisecond},
+			want:      false,
+		},
+		{
+			name:      "no observed peers",
+			latencies: []time.Duration{time.Second, time.Second, time.Second},
+			want:      false,
+		},
+		{
+			name:      "failed requests are not slow",
+			peer:      100 * time.Millisecond,
+			latencies: []time.Duration{time.Second, time.Second},
+			err:       fmt.Errorf("connection reset"),
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.ConsecutiveSlow = 2
+			tt.cfg.MaxEjectedPercent = 100
+
+			od, _ := newTestOutlier(tt.cfg, "a", "b", "c")
+
+			if tt.peer != 0 {
+				od.observe("b", DoneInfo{Status: 200, Latency: tt.peer})
+				od.observe("c", DoneInfo{Status: 200, Latency: tt.peer})
+			}
+
+			for _, latency := range tt.latencies {
+				info := DoneInfo{Status: 200, Latency: latency}
+				if tt.err != nil {
+					info = DoneInfo{Err: tt.err, Latency: latency}
+				}
+
+				od.observe("a", info)
+			}
+
+			if ejected := slices.Contains(od.ejected(), "a"); ejected != tt.want {
+				t.Errorf("target ejected %v, want %v", ejected, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutlierMaxEjectedPercent(t *testing.T) {
+	tests := []struct {
+		percent int
+		targets int
+		want    int
+	}{
+		{percent: 10, targets: 4, want: 1},
+		{percent: 50, targets: 4, want: 2},
+		{percent: 100, targets: 4, want: 4},
+		{percent: 30, targets: 10, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d of %d", tt.percent, tt.targets), func(t *testing.T) {
+			urls := make([]string, tt.targets)
+			for i := range urls {
+				urls[i] = fmt.Sprintf("t%d", i)
+			}
+
+			od, _ := newTestOutlier(config.OutlierConfig{
+				Consecutive5xx:    1,
+				MaxEjectedPercent: tt.percent,
+			}, urls...)
+
+			for _, url := range urls {
+				od.observe(url, DoneInfo{Status: 500})
+			}
+
+			if got := len(od.ejected()); got != tt.want {
+				t.Errorf("ejected %d targets, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutlierEjectionBackoff(t *testing.T) {
+	od, _ := newTestOutlier(config.OutlierConfig{
+		Consecutive5xx:    1,
+		BaseEjection:      time.Minute,
+		MaxEjection:       3 * time.Minute,
+		MaxEjectedPercent: 100,
+	}, "a")
+
+	target := od.targets["a"]
+
+	// ejection is doubled every time until max ejection
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		target.ejectedUntil = time.Time{}
+		target.returnedAt = time.Now()
+
+		od.observe("a", DoneInfo{Status: 500})
+
+		got := time.Until(target.ejectedUntil)
+		if got <= want-time.Second || got > want {
+			t.Errorf("ejected for %s, want %s", got.Round(time.Second), want)
+		}
+	}
+}
+
+func TestBalanceSkipsEjected(t *testing.T) {
+	gateway := &config.Gateway{
+		Name:     "test",
+		Targets:  []config.Target{{Url: "a", Weight: 1}, {Url: "b", Weight: 1}},
+		Balancer: config.BalancerConfig{Alg: "rr"},
+		Outlier:  config.OutlierConfig{Consecutive5xx: 1, MaxEjectedPercent: 50},
+	}
+
+	lb := newTestLoadBalancer(t, gateway)
+
+	// result of request is passed to outlier detection by done
+	for i := 0; i < 2; i++ {
+		target, done, err := lb.Balance(httptest.NewRequest("GET", "/test", nil), "test")
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		if target == "a" {
+			done(DoneInfo{Status: 500})
+		} else {
+			done(DoneInfo{Status: 200})
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		target, done, err := lb.Balance(httptest.NewRequest("GET", "/test", nil), "test")
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		done(DoneInfo{Status: 200})
+
+		if target != "b" {
+			t.Errorf("request %d sent to ejected target %s", i, target)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package loadbalancer
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/errors"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+// failurePenalty is latency observed for failed request, so target
+// which fails fast is not preferred over targets which answer
+const failurePenalty = 10 * time.Second
+
+type (
+	p2cexpTarget struct {
+		rampTarget
+		health   bool
+		inflight int
+		// ewma stores peak ewma of upstream latency in nanoseconds
+		ewma       float64
+		lastUpdate time.Time
+	}
+
+	// P2CBalancer picks two random healthy targets and sends request to
+	// less loaded one, with latency enabled load is peak ewma of latency
+	// multiplied by in-flight requests, otherwise only in-flight requests,
+	// load is divided by target weight
+	P2CBalancer struct {
+		logger     *logger.Logger
+		prefix     string
+		targets    []p2cexpTarget
+		useLatency bool
+		slowStart  *slowStart
+		// decay is time after which old latency observations lose most of their weight
+		decay time.Duration
+		mu    sync.Mutex
+	}
+)
+
+func initP2C(gateway *config.Gateway, logger *logger.Logger, useLatency bool) *P2CBalancer {
+	targets := make([]p2cexpTarget, len(gateway.Targets))
+
+	for i, target := range gateway.Targets {
+		targets[i] = p2cexpTarget{
+			rampTarget: rampTarget{
+				url:    target.Url,
+				weight: max(target.Weight, 1),
+			},
+			health: true,
+		}
+	}
+
+	decay := gateway.Balancer.EWMADecay
+	if decay <= 0 {
+		decay = config.DefaultEWMADecay
+	}
+
+	// targets seen for the first time ramp up like recovered ones
+	ss := newSlowStart(gateway)
+	now := time.Now()
+	for i := range targets {
+		ss.start(&targets[i].rampTarget, now)
+	}
+
+	return &P2CBalancer{
+		logger:     logger,
+		prefix:     gateway.Name,
+		targets:    targets,
+		useLatency: useLatency,
+		slowStart:  ss,
+		decay:      decay,
+	}
+}
+
+func (pb *P2CBalancer) SetHealthInfo(healthy map[string]bool) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	changed := false
+	now := time.Now()
+
+	for i := range pb.targets {
+		isHealth, ok := healthy[pb.targets[i].url]
+		if !ok {
+			isHealth = true
+		}
+
+		if pb.targets[i].health != isHealth {
+			changed = true
+		}
+
+		if !pb.targets[i].health && isHealth {
+			pb.slowStart.start(&pb.targets[i].rampTarget, now)
+		}
+
+		pb.targets[i].health = isHealth
+		pb.slowStart.refresh(&pb.targets[i].rampTarget, now)
+	}
+
+	if changed {
+		pb.logger.Info("health status updated",
+			zap.String("prefix", pb.prefix))
+	}
+}
+
+func (pb *P2CBalancer) SelectTarget(_ *Selection) (string, DoneFunc, error) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	healthy := make([]int, 0, len(pb.targets))
+	for i := range pb.targets {
+		if pb.targets[i].health {
+			healthy = append(healthy, i)
+		}
+	}
+
+	var chosen int
+
+	switch len(healthy) {
+	case 0:
+		return "", nil, errors.ErrNoHealthyTargets
+	case 1:
+		chosen = healthy[0]
+	default:
+		first := rand.IntN(len(healthy))
+		second := rand.IntN(len(healthy) - 1)
+		if second >= first {
+			second++
+		}
+
+		now := time.Now()
+		neutral := pb.neutralEWMA(now)
+
+		chosen = healthy[first]
+		if pb.cost(&pb.targets[healthy[second]], now, neutral) < pb.cost(&pb.targets[chosen], now, neutral) {
+			chosen = healthy[second]
+		}
+	}
+
+	target := &pb.targets[chosen]
+	target.inflight++
+
+	done := func(info DoneInfo) {
+		pb.mu.Lock()
+		defer pb.mu.Unlock()
+
+		if target.inflight > 0 {
+			target.inflight--
+		}
+
+		// status is empty when upstream was not called
+		switch {
+		case info.Err != nil:
+			pb.observe(target, max(info.Latency, failurePenalty), time.Now())
+		case info.Status != 0:
+			pb.observe(target, info.Latency, time.Now())
+		}
+	}
+
+	return target.url, done, nil
+}
+
+// cost returns load of target per unit of its weight, target
+// without observations is assumed to have neutral latency
+func (pb *P2CBalancer) cost(target *p2cexpTarget, now time.Time, neutral float64) float64 {
+	load := float64(target.inflight + 1)
+	if latency := cmp.Or(pb.decayed(target, now), neutral); pb.useLatency && latency != 0 {
+		load *= latency
+	}
+
+	return load / pb.slowStart.effective(&target.rampTarget, now)
+}
+
+// decayed returns ewma of target faded by time since the last observation,
+// so target penalized by failure gets requests again when it is not chosen
+func (pb *P2CBalancer) decayed(target *p2cexpTarget, now time.Time) float64 {
+	if target.ewma == 0 {
+		return 0
+	}
+
+	elapsed := max(now.Sub(target.lastUpdate), 0)
+
+	return target.ewma * math.Exp(-float64(elapsed)/float64(pb.decay))
+}
+
+// neutralEWMA returns mean decayed latency of observed targets
+func (pb *P2CBalancer) neutralEWMA(now time.Time) float64 {
+	if !pb.useLatency {
+		return 0
+	}
+
+	sum, count := 0.0, 0
+
+	for i := range pb.targets {
+		if pb.targets[i].ewma != 0 {
+			sum += pb.decayed(&pb.targets[i], now)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	return sum / float64(count)
+}
+
+// observe updates peak ewma: spikes are taken immediately
+// and good latency is blended in slowly
+func (pb *P2CBalancer) observe(target *p2cexpTarget, latency time.Duration, now time.Time) {
+	rtt := float64(latency)
+
+	if rtt > target.ewma {
+		target.ewma = rtt
+	} else {
+		elapsed := now.Sub(target.lastUpdate)
+		w := math.Exp(-float64(elapsed) / float64(pb.decay))
+		target.ewma = target.ewma*w + rtt*(1-w)
+	}
+
+	target.lastUpdate = now
+}
@@ -0,0 +1,238 @@
+package loadbalancer
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+)
+
+// initTestP2C returns constructor of p2c balancer with or without latency
+func initTestP2C(useLatency bool) func(*config.Gateway, *logger.Logger) *P2CBalancer {
+	return func(gateway *config.Gateway, logger *logger.Logger) *P2CBalancer {
+		return initP2C(gateway, logger, useLatency)
+	}
+}
+
+func TestP2CLessLoaded(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		// want stores in-flight requests of targets after selections
+		want map[string]int
+	}{
+		{name: "equal weights", weights: map[string]int{"a": 1, "b": 1}, want: map[string]int{"a": 4, "b": 4}},
+		{name: "weighted", weights: map[string]int{"a": 3, "b": 1}, want: map[string]int{"a": 6, "b": 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pb, sel := newTestBalancer(initTestP2C(false),
+				config.Target{Url: "a", Weight: tt.weights["a"]},
+				config.Target{Url: "b", Weight: tt.weights["b"]},
+			)
+
+			// requests are never finished, so every one adds load to its target
+			counts := make(map[string]int)
+			for i := 0; i < 8; i++ {
+				target, _, err := pb.SelectTarget(sel)
+				if err != nil {
+					t.Fatalf("failed select target: %v", err)
+				}
+
+				counts[target]++
+			}
+
+			for target, want := range tt.want {
+				if counts[target] != want {
+					t.Errorf("target %s got %d requests, want %d", target, counts[target], want)
+				}
+			}
+		})
+	}
+}
+
+func TestP2CInflight(t *testing.T) {
+	pb, sel := newTestBalancer(initTestP2C(false),
+		config.Target{Url: "a", Weight: 1},
+		config.Target{Url: "b", Weight: 1},
+	)
+
+	first, done, err := pb.SelectTarget(sel)
+	if err != nil {
+		t.Fatalf("failed select target: %v", err)
+	}
+
+	// busy target is not chosen until its request is finished
+	for i := 0; i < 10; i++ {
+		target, next, err := pb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		if target == first {
+			t.Fatalf("busy target %s is chosen", first)
+		}
+
+		next(DoneInfo{Status: http.StatusOK})
+	}
+
+	done(DoneInfo{Status: http.StatusOK})
+
+	for i := range pb.targets {
+		if pb.targets[i].inflight != 0 {
+			t.Errorf("target %s has %d in-flight requests after done", pb.targets[i].url, pb.targets[i].inflight)
+		}
+	}
+}
+
+func TestP2CLatency(t *testing.T) {
+	tests := []struct {
+		name string
+		// results are reported by targets
+		results map[string]DoneInfo
+		want    string
+	}{
+		{
+			name: "faster target",
+			results: map[string]DoneInfo{
+				"a": {Status: http.StatusOK, Latency: 100 * time.Millisecond},
+				"b": {Status: http.StatusOK, Latency: 10 * time.Millisecond},
+			},
+			want: "b",
+		},
+		{
+			// target which fails fast looks slower than one which answers
+			name: "failure penalty",
+			results: map[string]DoneInfo{
+				"a": {Status: http.StatusBadGateway, Latency: time.Millisecond, Err: errors.New("connection refused")},
+				"b": {Status: http.StatusOK, Latency: 500 * time.Millisecond},
+			},
+			want: "b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pb, sel := newTestBalancer(initTestP2C(true),
+				config.Target{Url: "a", Weight: 1},
+				config.Target{Url: "b", Weight: 1},
+			)
+
+			// both targets are observed while their latency is unknown
+			for i := 0; i < 64; i++ {
+				target, done, err := pb.SelectTarget(sel)
+				if err != nil {
+					t.Fatalf("failed select target: %v", err)
+				}
+
+				done(tt.results[target])
+			}
+
+			for i := 0; i < 100; i++ {
+				target, done, err := pb.SelectTarget(sel)
+				if err != nil {
+					t.Fatalf("failed select target: %v", err)
+				}
+
+				if target != tt.want {
+					t.Fatalf("request %d is sent to %s, want %s", i, target, tt.want)
+				}
+
+				done(tt.results[target])
+			}
+		})
+	}
+}
+
+func TestP2CEWMADecay(t *testing.T) {
+	decay := 10 * time.Second
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		latency time.Duration
+		want    time.Duration
+	}{
+		{name: "spike is taken at once", elapsed: time.Millisecond, latency: 200 * time.Millisecond, want: 200 * time.Millisecond},
+		{name: "recent observation", elapsed: 0, latency: 10 * time.Millisecond, want: 100 * time.Millisecond},
+		{
+			name:    "one decay period",
+			elapsed: decay,
+			latency: 10 * time.Millisecond,
+			want:    time.Duration(100e6*math.Exp(-1) + 10e6*(1-math.Exp(-1))),
+		},
+		{name: "old observation", elapsed: 100 * decay, latency: 10 * time.Millisecond, want: 10 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pb, _ := newTestBalancer(initTestP2C(true), config.Target{Url: "a", Weight: 1})
+			pb.decay = decay
+
+			now := time.Now()
+			target := &pb.targets[0]
+			target.ewma = float64(100 * time.Millisecond)
+			target.lastUpdate = now
+
+			pb.observe(target, tt.latency, now.Add(tt.elapsed))
+
+			if got := time.Duration(target.ewma); math.Abs(float64(got-tt.want)) > float64(time.Microsecond) {
+				t.Errorf("ewma %s, want %s", got, tt.want)
+			}
+
+			if !target.lastUpdate.Equal(now.Add(tt.elapsed)) {
+				t.Errorf("last update %s, want time of observation", target.lastUpdate)
+			}
+		})
+	}
+}
+
+func TestP2CPenaltyRecovery(t *testing.T) {
+	pb, sel := newTestBalancer(initTestP2C(true),
+		config.Target{Url: "a", Weight: 1},
+		config.Target{Url: "b", Weight: 1},
+	)
+	pb.decay = 100 * time.Millisecond
+
+	now := time.Now()
+	pb.observe(&pb.targets[0], failurePenalty, now)
+	pb.observe(&pb.targets[1], 10*time.Millisecond, now)
+
+	// penalty is fresh, so only b is chosen
+	for i := 0; i < 20; i++ {
+		target, done, err := pb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		if target != "b" {
+			t.Fatalf("penalized target is chosen right after failure")
+		}
+
+		done(DoneInfo{Status: http.StatusOK, Latency: 10 * time.Millisecond})
+	}
+
+	// a was not chosen for ten decay windows while b kept answering
+	pb.targets[0].lastUpdate = now.Add(-10 * pb.decay)
+
+	counts := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		target, done, err := pb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		counts[target]++
+
+		done(DoneInfo{Status: http.StatusOK, Latency: 10 * time.Millisecond})
+	}
+
+	if counts["a"] == 0 {
+		t.Errorf("penalized target got no requests after decay, counts %v", counts)
+	}
+}
@@ -1,58 +1,43 @@
 package loadbalancer
 
 import (
-	"errors"
 	"sync"
 
 	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/errors"
 	"github.com/osamikoyo/orion/logger"
 	"go.uber.org/zap"
 )
 
-var (
-	ErrPrefixNotFound = errors.New("not found prefix for url")
-)
-
 type (
 	rrexpTarget struct {
 		url    string
 		health bool
 	}
 
-	rrexpandedTargets struct {
+	RoundRobinBalancer struct {
+		logger  *logger.Logger
+		prefix  string
 		targets []rrexpTarget
 		index   int
-	}
-
-	RoundRobinBalancer struct {
-		logger      *logger.Logger
-		targetsInfo map[string]rrexpandedTargets
-		mu          sync.RWMutex
+		mu      sync.Mutex
 	}
 )
 
-func initRoundRobin(cfg *config.Config, logger *logger.Logger) *RoundRobinBalancer {
-	targetsInfo := make(map[string]rrexpandedTargets)
+func initRoundRobin(gateway *config.Gateway, logger *logger.Logger) *RoundRobinBalancer {
+	targets := make([]rrexpTarget, len(gateway.Targets))
 
-	for _, gateway := range cfg.Gateways {
-		targets := make([]rrexpTarget, len(gateway.Targets))
-
-		for i, target := range gateway.Targets {
-			targets[i] = rrexpTarget{
-				url:    target.Url,
-				health: true,
-			}
-		}
-
-		targetsInfo[gateway.Prefix] = rrexpandedTargets{
-			targets: targets,
-			index:   0,
+	for i, target := range gateway.Targets {
+		targets[i] = rrexpTarget{
+			url:    target.Url,
+			health: true,
 		}
 	}
 
 	return &RoundRobinBalancer{
-		logger:      logger,
-		targetsInfo: targetsInfo,
+		logger:  logger,
+		prefix:  gateway.Name,
+		targets: targets,
 	}
 }
 
@@ -60,56 +45,41 @@ func (rrb *RoundRobinBalancer) SetHealthInfo(healthy map[string]bool) {
 	rrb.mu.Lock()
 	defer rrb.mu.Unlock()
 
-	for prefix, exptarget := range rrb.targetsInfo {
-		changed := false
-		for i := range exptarget.targets {
-			oldHealth := exptarget.targets[i].health
-			url := exptarget.targets[i].url
-
-			isHealth, ok := healthy[url]
-			if !ok {
-				isHealth = true
-			}
-
-			if oldHealth != isHealth {
-				changed = true
-			}
+	changed := false
+	for i := range rrb.targets {
+		oldHealth := rrb.targets[i].health
+		url := rrb.targets[i].url
 
-			exptarget.targets[i].health = isHealth
+		isHealth, ok := healthy[url]
+		if !ok {
+			isHealth = true
 		}
 
-		if changed {
-			rrb.logger.Info("health status updated",
-				zap.String("prefix", prefix))
+		if oldHealth != isHealth {
+			changed = true
 		}
-	}
-}
-
-func (rrb *RoundRobinBalancer) SelectTarget(prefix string) (string, error) {
-	rrb.mu.RLock()
-
-	expTargets, ok := rrb.targetsInfo[prefix]
-
-	rrb.mu.RUnlock()
-	if !ok {
 
-		rrb.logger.Error("could not found targets",
-			zap.String("prefix", prefix))
+		rrb.targets[i].health = isHealth
+	}
 
-		return "", ErrPrefixNotFound
+	if changed {
+		rrb.logger.Info("health status updated",
+			zap.String("prefix", rrb.prefix))
 	}
+}
 
+func (rrb *RoundRobinBalancer) SelectTarget(_ *Selection) (string, DoneFunc, error) {
 	rrb.mu.Lock()
+	defer rrb.mu.Unlock()
 
-	target := expTargets.targets[expTargets.index]
+	for attempts := 0; attempts < len(rrb.targets); attempts++ {
+		target := rrb.targets[rrb.index]
+		rrb.index = (rrb.index + 1) % len(rrb.targets)
 
-	if expTargets.index == len(expTargets.targets)-1 {
-		expTargets.index = 0
-	} else {
-		expTargets.index++
+		if target.health {
+			return target.url, noopDone, nil
+		}
 	}
 
-	rrb.mu.Unlock()
-
-	return target.url, nil
+	return "", nil, errors.ErrNoHealthyTargets
 }
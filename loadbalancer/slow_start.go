@@ -0,0 +1,95 @@
+package loadbalancer
+
+import (
+	"math"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/metrics"
+)
+
+// slowStart ramps weight of target up after it becomes healthy or is added
+type slowStart struct {
+	prefix     string
+	window     time.Duration
+	minFactor  float64
+	aggression float64
+}
+
+// newSlowStart returns nil when slow start is disabled for gateway
+func newSlowStart(gateway *config.Gateway) *slowStart {
+	cfg := gateway.Balancer.SlowStart
+	if cfg.Window <= 0 {
+		return nil
+	}
+
+	aggression := cfg.Aggression
+	if aggression <= 0 {
+		aggression = config.DefaultSlowStartAggr
+	}
+
+	minPercent := config.DefaultSlowStartMinWeight
+	if cfg.MinWeightPercent != nil {
+		minPercent = *cfg.MinWeightPercent
+	}
+
+	return &slowStart{
+		prefix:     gateway.Name,
+		window:     cfg.Window,
+		minFactor:  float64(minPercent) / 100,
+		aggression: aggression,
+	}
+}
+
+// rampTarget stores slow start state of one target
+type rampTarget struct {
+	url    string
+	weight int
+	// since is time when target became healthy, zero when target is not ramping
+	since time.Time
+}
+
+// start begins ramp up of target which became healthy or was seen for the first time
+func (ss *slowStart) start(target *rampTarget, now time.Time) {
+	if ss == nil {
+		return
+	}
+
+	target.since = now
+
+	metrics.TargetEffectiveWeight.WithLabelValues(ss.prefix, target.url).Set(float64(target.weight) * ss.minFactor)
+}
+
+// refresh updates weight gauge of target, so the end of ramp
+// is reported even when balancer does not weigh target
+func (ss *slowStart) refresh(target *rampTarget, now time.Time) {
+	if ss == nil {
+		return
+	}
+
+	ss.effective(target, now)
+}
+
+// effective returns weight of target at the moment
+func (ss *slowStart) effective(target *rampTarget, now time.Time) float64 {
+	weight := float64(target.weight)
+
+	if ss == nil || target.since.IsZero() {
+		return weight
+	}
+
+	progress := float64(now.Sub(target.since)) / float64(ss.window)
+	if progress >= 1 {
+		target.since = time.Time{}
+		metrics.TargetEffectiveWeight.WithLabelValues(ss.prefix, target.url).Set(weight)
+
+		return weight
+	}
+
+	factor := math.Max(ss.minFactor, math.Pow(progress, 1/ss.aggression))
+	weight *= factor
+
+	metrics.TargetEffectiveWeight.WithLabelValues(ss.prefix, target.url).Set(weight)
+
+	return weight
+}
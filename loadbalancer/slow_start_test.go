@@ -0,0 +1,204 @@
+package loadbalancer
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/metrics"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// effectiveWeightMetric returns exported weight of target
+func effectiveWeightMetric(t *testing.T, prefix, url string) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := metrics.TargetEffectiveWeight.WithLabelValues(prefix, url).Write(&m); err != nil {
+		t.Fatalf("failed read metric: %v", err)
+	}
+
+	return m.GetGauge().GetValue()
+}
+
+func TestSlowStartRamp(t *testing.T) {
+	const window = 100 * time.Second
+
+	type point struct {
+		// elapsed is time since target became healthy
+		elapsed time.Duration
+		want    float64
+	}
+
+	tests := []struct {
+		name       string
+		aggression float64
+		minPercent int
+		points     []point
+	}{
+		{
+			name:       "linear",
+			aggression: 1,
+			minPercent: 10,
+			points: []point{
+				{elapsed: 0, want: 0.4},
+				{elapsed: 5 * time.Second, want: 0.4},
+				{elapsed: 25 * time.Second, want: 1},
+				{elapsed: 50 * time.Second, want: 2},
+				{elapsed: 90 * time.Second, want: 3.6},
+				{elapsed: window, want: 4},
+			},
+		},
+		{
+			// weight grows fast at the start of window
+			name:       "aggressive",
+			aggression: 2,
+			minPercent: 10,
+			points: []point{
+				{elapsed: 0, want: 0.4},
+				{elapsed: 4 * time.Second, want: 0.8},
+				{elapsed: 25 * time.Second, want: 2},
+				{elapsed: 64 * time.Second, want: 3.2},
+				{elapsed: window, want: 4},
+			},
+		},
+		{
+			// weight grows fast at the end of window
+			name:       "cautious",
+			aggression: 0.5,
+			minPercent: 10,
+			points: []point{
+				{elapsed: 20 * time.Second, want: 0.4},
+				{elapsed: 50 * time.Second, want: 1},
+				{elapsed: 90 * time.Second, want: 3.24},
+				{elapsed: 2 * window, want: 4},
+			},
+		},
+		{
+			// target gets no traffic at the start of window
+			name:       "zero minimum weight",
+			aggression: 1,
+			minPercent: 0,
+			points: []point{
+				{elapsed: 0, want: 0},
+				{elapsed: 25 * time.Second, want: 1},
+				{elapsed: 50 * time.Second, want: 2},
+				{elapsed: window, want: 4},
+			},
+		},
+		{
+			name:       "minimum weight",
+			aggression: 1,
+			minPercent: 50,
+			points: []point{
+				{elapsed: 0, want: 2},
+				{elapsed: 30 * time.Second, want: 2},
+				{elapsed: 75 * time.Second, want: 3},
+				{elapsed: window, want: 4},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := &config.Gateway{Name: "slow-start-" + tt.name}
+			gateway.Balancer.SlowStart = config.SlowStartConfig{
+				Window:           window,
+				MinWeightPercent: &tt.minPercent,
+				Aggression:       tt.aggression,
+			}
+
+			ss := newSlowStart(gateway)
+			target := &rampTarget{url: "a", weight: 4}
+
+			// clock of test is moved by points of window
+			started := time.Now()
+			ss.start(target, started)
+
+			if got := effectiveWeightMetric(t, gateway.Name, target.url); got != 4*float64(tt.minPercent)/100 {
+				t.Errorf("metric %v after start, want minimum weight", got)
+			}
+
+			for _, p := range tt.points {
+				got := ss.effective(target, started.Add(p.elapsed))
+				if math.Abs(got-p.want) > 1e-9 {
+					t.Errorf("weight %v after %s, want %v", got, p.elapsed, p.want)
+				}
+
+				if metric := effectiveWeightMetric(t, gateway.Name, target.url); math.Abs(metric-p.want) > 1e-9 {
+					t.Errorf("metric %v after %s, want %v", metric, p.elapsed, p.want)
+				}
+			}
+
+			// ramp is finished at the end of window
+			if !target.since.IsZero() {
+				t.Errorf("target is still ramping after window")
+			}
+
+			if got := ss.effective(target, started); got != 4 {
+				t.Errorf("weight %v after ramp, want full weight", got)
+			}
+		})
+	}
+}
+
+func TestSlowStartDisabled(t *testing.T) {
+	ss := newSlowStart(&config.Gateway{Name: "slow-start-disabled"})
+	if ss != nil {
+		t.Fatalf("slow start is created without window")
+	}
+
+	target := &rampTarget{url: "a", weight: 3}
+
+	// nil slow start keeps configured weight
+	ss.start(target, time.Now())
+	ss.refresh(target, time.Now())
+
+	if got := ss.effective(target, time.Now()); got != 3 {
+		t.Errorf("weight %v, want 3", got)
+	}
+}
+
+func TestSlowStartRecovered(t *testing.T) {
+	gateway := &config.Gateway{
+		Name: "slow-start-recovered",
+		Targets: []config.Target{
+			{Url: "a", Weight: 1},
+			{Url: "b", Weight: 1},
+		},
+	}
+	minPercent := 10
+	gateway.Balancer.SlowStart = config.SlowStartConfig{Window: time.Hour, MinWeightPercent: &minPercent, Aggression: 1}
+
+	lcb := initLeastConn(gateway, &logger.Logger{Logger: zap.NewNop()})
+	sel := &Selection{Request: httptest.NewRequest(http.MethodGet, "/test", nil), Gateway: gateway}
+
+	// b has finished its ramp, a has just recovered
+	lcb.targets[1].since = time.Time{}
+	lcb.SetHealthInfo(map[string]bool{"a": false})
+	lcb.SetHealthInfo(map[string]bool{"a": true})
+
+	counts := make(map[string]int)
+	for i := 0; i < 110; i++ {
+		target, _, err := lcb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		counts[target]++
+	}
+
+	// a has tenth of weight of b at the start of ramp
+	if counts["a"] > 11 {
+		t.Errorf("recovered target got %d of 110 requests, want at most 11", counts["a"])
+	}
+
+	if got := effectiveWeightMetric(t, gateway.Name, "a"); got >= 0.2 {
+		t.Errorf("metric of recovered target %v, want about minimum weight", got)
+	}
+}
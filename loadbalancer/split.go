@@ -0,0 +1,126 @@
+package loadbalancer
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"github.com/osamikoyo/orion/auth"
+	"github.com/osamikoyo/orion/config"
+)
+
+// splitter chooses pool of gateway for request by split rules and weights of pools
+type splitter struct {
+	gateway *config.Gateway
+	rules   []config.SplitRule
+	// sticky builds key of client when split is sticky
+	sticky *keyExtractor
+	// weights stores weights in order of pools, they are replaced at runtime
+	weights atomic.Pointer[[]int]
+}
+
+func newSplitter(gateway *config.Gateway) (*splitter, error) {
+	s := &splitter{
+		gateway: gateway,
+		rules:   gateway.Split.Rules,
+	}
+
+	if gateway.Split.Sticky {
+		sticky, err := newKeyExtractor(config.HashConfig{
+			Key:            gateway.Split.Key,
+			Name:           gateway.Split.Name,
+			TrustedProxies: gateway.Headers.Forwarded.TrustedProxies,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		s.sticky = sticky
+	}
+
+	s.setWeights(gateway.PoolWeights())
+
+	return s, nil
+}
+
+// choose returns name of pool for request, rules are checked first
+func (s *splitter) choose(r *http.Request) string {
+	for _, rule := range s.rules {
+		if matchRule(r, rule) {
+			return rule.Pool
+		}
+	}
+
+	var point int
+	if s.sticky != nil {
+		// client keeps its point, so it stays in pool while weight of pool only grows
+		h := fnv.New32a()
+		h.Write([]byte(s.sticky.Extract(r)))
+		point = int(h.Sum32() % 100)
+	} else {
+		point = rand.IntN(100)
+	}
+
+	weights := *s.weights.Load()
+	for i, weight := range weights {
+		if point < weight {
+			return s.gateway.Pools[i].Name
+		}
+
+		point -= weight
+	}
+
+	return s.gateway.Pools[len(s.gateway.Pools)-1].Name
+}
+
+// setWeights replaces weights of pools, they must be validated before
+func (s *splitter) setWeights(weights map[string]int) {
+	ordered := make([]int, len(s.gateway.Pools))
+	for i, pool := range s.gateway.Pools {
+		ordered[i] = weights[pool.Name]
+	}
+
+	s.weights.Store(&ordered)
+}
+
+// matchRule returns true when request has accepted value of rule
+func matchRule(r *http.Request, rule config.SplitRule) bool {
+	var values []string
+
+	switch rule.Source {
+	case "header":
+		values = r.Header.Values(rule.Name)
+	case "cookie":
+		if cookie, err := r.Cookie(rule.Name); err == nil {
+			values = []string{cookie.Value}
+		}
+	case "claim":
+		values = auth.ClaimValues(r, rule.Name)
+	}
+
+	for _, value := range values {
+		if value != "" && (len(rule.Values) == 0 || slices.Contains(rule.Values, value)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// poolGateway returns gateway with targets and balancer of pool,
+// balancers and outlier detection of pool are created from it
+func poolGateway(gateway *config.Gateway, pool *config.PoolConfig) *config.Gateway {
+	pg := *gateway
+	pg.Name = poolKey(gateway.Name, pool.Name)
+	pg.Targets = pool.Targets
+	pg.Balancer = pool.Balancer
+	pg.Pools = nil
+
+	return &pg
+}
+
+func poolKey(gateway, pool string) string {
+	return gateway + "/" + pool
+}
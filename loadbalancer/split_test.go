@@ -0,0 +1,216 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/osamikoyo/orion/config"
+)
+
+func newTestSplitGateway(stable, canary int, split config.SplitConfig) *config.Gateway {
+	return &config.Gateway{
+		Name:     "test",
+		Prefix:   "/test",
+		Balancer: config.BalancerConfig{Alg: "rr"},
+		Split:    split,
+		Pools: []config.PoolConfig{
+			{
+				Name:     "stable",
+				Targets:  []config.Target{{Url: "stable-1", Weight: 1}, {Url: "stable-2", Weight: 1}},
+				Weight:   stable,
+				Balancer: config.BalancerConfig{Alg: "rr"},
+			},
+			{
+				Name:     "canary",
+				Targets:  []config.Target{{Url: "canary-1", Weight: 1}},
+				Weight:   canary,
+				Balancer: config.BalancerConfig{Alg: "rr"},
+			},
+		},
+	}
+}
+
+func TestSplitWeights(t *testing.T) {
+	const requests = 10000
+
+	tests := []struct {
+		name   string
+		stable int
+		canary int
+	}{
+		{name: "all stable", stable: 100, canary: 0},
+		{name: "canary", stable: 90, canary: 10},
+		{name: "half", stable: 50, canary: 50},
+		{name: "all canary", stable: 0, canary: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newSplitter(newTestSplitGateway(tt.stable, tt.canary, config.SplitConfig{}))
+			if err != nil {
+				t.Fatalf("failed create split: %v", err)
+			}
+
+			counts := make(map[string]int)
+			for i := 0; i < requests; i++ {
+				counts[s.choose(httptest.NewRequest("GET", "/test", nil))]++
+			}
+
+			// random split is checked with tolerance of 2 percent
+			want := requests * tt.canary / 100
+			if got := counts["canary"]; got < want-requests/50 || got > want+requests/50 {
+				t.Errorf("canary got %d requests, want about %d", got, want)
+			}
+
+			if counts["stable"]+counts["canary"] != requests {
+				t.Errorf("requests sent to unknown pools: %v", counts)
+			}
+		})
+	}
+}
+
+func TestSplitRules(t *testing.T) {
+	rules := []config.SplitRule{
+		{Pool: "canary", Source: "header", Name: "X-Canary", Values: []string{"1", "true"}},
+		{Pool: "canary", Source: "cookie", Name: "beta"},
+	}
+
+	tests := []struct {
+		name string
+		set  func(r *http.Request)
+		want string
+	}{
+		{name: "no values", set: func(r *http.Request) {}, want: "stable"},
+		{name: "header", set: func(r *http.Request) { r.Header.Set("X-Canary", "true") }, want: "canary"},
+		{name: "header with other value", set: func(r *http.Request) { r.Header.Set("X-Canary", "0") }, want: "stable"},
+		{name: "any cookie value", set: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "beta", Value: "x"}) }, want: "canary"},
+		{name: "empty cookie", set: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "beta", Value: ""}) }, want: "stable"},
+	}
+
+	s, err := newSplitter(newTestSplitGateway(100, 0, config.SplitConfig{Rules: rules}))
+	if err != nil {
+		t.Fatalf("failed create split: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/test", nil)
+			tt.set(r)
+
+			if got := s.choose(r); got != tt.want {
+				t.Errorf("pool %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitSticky(t *testing.T) {
+	const clients = 1000
+
+	gateway := newTestSplitGateway(90, 10, config.SplitConfig{Sticky: true, Key: "header", Name: "X-User"})
+
+	s, err := newSplitter(gateway)
+	if err != nil {
+		t.Fatalf("failed create split: %v", err)
+	}
+
+	choose := func() map[string]string {
+		pools := make(map[string]string, clients)
+
+		for i := 0; i < clients; i++ {
+			r := httptest.NewRequest("GET", "/test", nil)
+			r.Header.Set("X-User", "user-"+strconv.Itoa(i))
+
+			pools[r.Header.Get("X-User")] = s.choose(r)
+		}
+
+		return pools
+	}
+
+	before := choose()
+
+	// the same client always gets the same pool
+	for user, pool := range choose() {
+		if before[user] != pool {
+			t.Fatalf("client %s moved from %s to %s", user, before[user], pool)
+		}
+	}
+
+	// clients of canary stay there while its weight grows
+	s.setWeights(map[string]int{"stable": 50, "canary": 50})
+
+	moved := 0
+	for user, pool := range choose() {
+		if before[user] == "canary" && pool != "canary" {
+			t.Errorf("client %s left canary when its weight grew", user)
+		}
+
+		if before[user] != pool {
+			moved++
+		}
+	}
+
+	if moved == 0 {
+		t.Errorf("no clients moved to canary")
+	}
+}
+
+func TestSelectPool(t *testing.T) {
+	gateway := newTestSplitGateway(100, 0, config.SplitConfig{})
+	lb := newTestLoadBalancer(t, gateway)
+
+	balance := func() string {
+		r := httptest.NewRequest("GET", "/test", nil)
+
+		target, done, err := lb.Balance(r, lb.SelectPool(r, "test"))
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		done(DoneInfo{Status: 200})
+
+		return target
+	}
+
+	for i := 0; i < 4; i++ {
+		if target := balance(); target != "stable-1" && target != "stable-2" {
+			t.Fatalf("target %s is not in stable pool", target)
+		}
+	}
+
+	invalid := []map[string]int{
+		{"stable": 50, "canary": 40},
+		{"stable": 100},
+		{"stable": 110, "canary": -10},
+		{"stable": 50, "other": 50},
+	}
+	for _, weights := range invalid {
+		if err := lb.SetPoolWeights("test", weights); err == nil {
+			t.Errorf("invalid weights %v are accepted", weights)
+		}
+	}
+
+	if err := lb.SetPoolWeights("test", map[string]int{"stable": 0, "canary": 100}); err != nil {
+		t.Fatalf("failed set weights: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if target := balance(); target != "canary-1" {
+			t.Fatalf("target %s is not in canary pool", target)
+		}
+	}
+
+	// gateway without pools is its own pool
+	plain := &config.Gateway{Name: "plain", Targets: []config.Target{{Url: "a", Weight: 1}}, Balancer: config.BalancerConfig{Alg: "rr"}}
+	lb = newTestLoadBalancer(t, plain)
+
+	if pool := lb.SelectPool(httptest.NewRequest("GET", "/test", nil), "plain"); pool != "plain" {
+		t.Errorf("pool %s, want plain", pool)
+	}
+
+	if err := lb.SetPoolWeights("plain", map[string]int{"plain": 100}); err == nil {
+		t.Errorf("weights are set for gateway without pools")
+	}
+}
@@ -2,6 +2,7 @@ package loadbalancer
 
 import (
 	"sync"
+	"time"
 
 	"github.com/osamikoyo/orion/config"
 	"github.com/osamikoyo/orion/errors"
@@ -11,54 +12,57 @@ import (
 
 type (
 	wrrexpTarget struct {
-		url    string
+		rampTarget
 		health bool
-		weight int
-	}
-
-	wrrexpandedTargets struct {
-		targets     []wrrexpTarget
-		current     int
-		totalWeight int
+		// current is the running weight of smooth weighted round robin
+		current float64
 	}
 
+	// WeightRoundRobinBalancer is nginx-like smooth weighted round robin,
+	// every target gets share of requests proportional to its weight and
+	// requests to heavy targets are interleaved with others
 	WeightRoundRobinBalancer struct {
 		logger      *logger.Logger
-		targetsInfo map[string]*wrrexpandedTargets
-		mu          sync.RWMutex
+		prefix      string
+		targets     []wrrexpTarget
+		totalWeight int
+		slowStart   *slowStart
+		mu          sync.Mutex
 	}
 )
 
-func initWeightRoundRobin(cfg *config.Config, logger *logger.Logger) *WeightRoundRobinBalancer {
-	targetsInfo := make(map[string]*wrrexpandedTargets)
+func initWeightRoundRobin(gateway *config.Gateway, logger *logger.Logger) *WeightRoundRobinBalancer {
+	targets := make([]wrrexpTarget, 0, len(gateway.Targets))
+	totalWeight := 0
 
-	for _, gateway := range cfg.Gateways {
-		targets := make([]wrrexpTarget, 0, len(gateway.Targets))
-		totalWeight := 0
-
-		for _, target := range gateway.Targets {
-			if target.Weight <= 0 {
-				logger.Warn("target weight is zero or negative, setting to 1", zap.String("url", target.Url))
-				target.Weight = 1
-			}
-			targets = append(targets, wrrexpTarget{
+	for _, target := range gateway.Targets {
+		if target.Weight <= 0 {
+			logger.Warn("target weight is zero or negative, setting to 1", zap.String("url", target.Url))
+			target.Weight = 1
+		}
+		targets = append(targets, wrrexpTarget{
+			rampTarget: rampTarget{
 				url:    target.Url,
-				health: true,
 				weight: target.Weight,
-			})
-			totalWeight += target.Weight
-		}
+			},
+			health: true,
+		})
+		totalWeight += target.Weight
+	}
 
-		targetsInfo[gateway.Prefix] = &wrrexpandedTargets{
-			targets:     targets,
-			current:     0,
-			totalWeight: totalWeight,
-		}
+	// targets seen for the first time ramp up like recovered ones
+	ss := newSlowStart(gateway)
+	now := time.Now()
+	for i := range targets {
+		ss.start(&targets[i].rampTarget, now)
 	}
 
 	return &WeightRoundRobinBalancer{
 		logger:      logger,
-		targetsInfo: targetsInfo,
+		prefix:      gateway.Name,
+		targets:     targets,
+		totalWeight: totalWeight,
+		slowStart:   ss,
 	}
 }
 
@@ -66,74 +70,84 @@ func (wrrb *WeightRoundRobinBalancer) SetHealthInfo(healthy map[string]bool) {
 	wrrb.mu.Lock()
 	defer wrrb.mu.Unlock()
 
-	for prefix, exp := range wrrb.targetsInfo {
-		newTotalWeight := 0
-		changed := false
+	newTotalWeight := 0
+	changed := false
+	now := time.Now()
 
-		for i := range exp.targets {
-			oldHealth := exp.targets[i].health
-			url := exp.targets[i].url
+	for i := range wrrb.targets {
+		oldHealth := wrrb.targets[i].health
+		url := wrrb.targets[i].url
 
-			isHealth, ok := healthy[url]
-			if !ok {
-				isHealth = true
-			}
+		isHealth, ok := healthy[url]
+		if !ok {
+			isHealth = true
+		}
 
-			if oldHealth != isHealth {
-				changed = true
-			}
+		if oldHealth != isHealth {
+			changed = true
+		}
 
-			exp.targets[i].health = isHealth
-			if isHealth {
-				newTotalWeight += exp.targets[i].weight
-			}
+		if !oldHealth && isHealth {
+			wrrb.slowStart.start(&wrrb.targets[i].rampTarget, now)
 		}
 
-		if changed {
-			exp.totalWeight = newTotalWeight
-			exp.current = 0
-			wrrb.logger.Info("health status updated, new total weight",
-				zap.String("prefix", prefix),
-				zap.Int("total_weight", newTotalWeight))
+		wrrb.targets[i].health = isHealth
+		wrrb.slowStart.refresh(&wrrb.targets[i].rampTarget, now)
+		if isHealth {
+			newTotalWeight += wrrb.targets[i].weight
 		}
 	}
-}
 
-func (wrrb *WeightRoundRobinBalancer) SelectTarget(prefix string) (string, error) {
-	wrrb.mu.RLock()
-	exp, ok := wrrb.targetsInfo[prefix]
-	wrrb.mu.RUnlock()
-	if !ok {
-		wrrb.logger.Error("could not found targets for prefix",
-			zap.String("prefix", prefix))
-		return "", errors.ErrPrefixNotFound
-	}
+	if changed {
+		// start new round, so set of healthy targets is balanced from scratch
+		for i := range wrrb.targets {
+			wrrb.targets[i].current = 0
+		}
 
-	if exp.totalWeight == 0 {
-		return "", errors.ErrNoHealthyTargets
+		wrrb.totalWeight = newTotalWeight
+		wrrb.logger.Info("health status updated, new total weight",
+			zap.String("prefix", wrrb.prefix),
+			zap.Int("total_weight", newTotalWeight))
 	}
+}
 
+// SelectTarget adds effective weight to current weight of every healthy
+// target, picks target with the biggest current weight and lowers it
+// by total weight
+func (wrrb *WeightRoundRobinBalancer) SelectTarget(_ *Selection) (string, DoneFunc, error) {
 	wrrb.mu.Lock()
 	defer wrrb.mu.Unlock()
 
-	for attempts := 0; attempts < len(exp.targets); attempts++ {
-		currentTarget := &exp.targets[exp.current]
-		exp.current = (exp.current + 1) % len(exp.targets)
+	if wrrb.totalWeight == 0 {
+		return "", nil, errors.ErrNoHealthyTargets
+	}
+
+	now := time.Now()
+	total := 0.0
+
+	var best *wrrexpTarget
+	for i := range wrrb.targets {
+		target := &wrrb.targets[i]
 
-		if !currentTarget.health {
+		if !target.health {
 			continue
 		}
 
-		if currentTarget.weight > 0 {
-			return currentTarget.url, nil
+		effective := wrrb.slowStart.effective(&target.rampTarget, now)
+
+		target.current += effective
+		total += effective
+
+		if best == nil || target.current > best.current {
+			best = target
 		}
 	}
 
-	for _, t := range exp.targets {
-		if t.health {
-			return t.url, nil
-		}
+	if best == nil {
+		return "", nil, errors.ErrNoHealthyTargets
 	}
 
-	return "", errors.ErrNoHealthyTargets
+	best.current -= total
+
+	return best.url, noopDone, nil
 }
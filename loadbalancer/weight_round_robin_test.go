@@ -0,0 +1,92 @@
+package loadbalancer
+
+import (
+	"testing"
+
+	"github.com/osamikoyo/orion/config"
+)
+
+func TestWeightRoundRobinDistribution(t *testing.T) {
+	wrrb, sel := newTestBalancer(initWeightRoundRobin,
+		config.Target{Url: "a", Weight: 5},
+		config.Target{Url: "b", Weight: 3},
+		config.Target{Url: "c", Weight: 1},
+	)
+
+	const rounds = 1000
+
+	counts := make(map[string]int)
+	for i := 0; i < rounds*9; i++ {
+		target, _, err := wrrb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		counts[target]++
+	}
+
+	expected := map[string]int{"a": 5 * rounds, "b": 3 * rounds, "c": rounds}
+	for target, want := range expected {
+		if counts[target] != want {
+			t.Errorf("target %s got %d requests, want %d", target, counts[target], want)
+		}
+	}
+}
+
+func TestWeightRoundRobinInterleaving(t *testing.T) {
+	wrrb, sel := newTestBalancer(initWeightRoundRobin,
+		config.Target{Url: "a", Weight: 5},
+		config.Target{Url: "b", Weight: 1},
+		config.Target{Url: "c", Weight: 1},
+	)
+
+	// heavy target must not get its whole share in a row
+	streak, longest := 0, 0
+	for i := 0; i < 70; i++ {
+		target, _, err := wrrb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		if target == "a" {
+			streak++
+			longest = max(longest, streak)
+		} else {
+			streak = 0
+		}
+	}
+
+	if longest >= 5 {
+		t.Errorf("heavy target was selected %d times in a row", longest)
+	}
+}
+
+func TestWeightRoundRobinHealthChange(t *testing.T) {
+	wrrb, sel := newTestBalancer(initWeightRoundRobin,
+		config.Target{Url: "a", Weight: 2},
+		config.Target{Url: "b", Weight: 1},
+		config.Target{Url: "c", Weight: 1},
+	)
+
+	wrrb.SetHealthInfo(map[string]bool{"a": false})
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		target, _, err := wrrb.SelectTarget(sel)
+		if err != nil {
+			t.Fatalf("failed select target: %v", err)
+		}
+
+		counts[target]++
+	}
+
+	if counts["a"] != 0 || counts["b"] != 50 || counts["c"] != 50 {
+		t.Errorf("unexpected distribution with unhealthy target: %v", counts)
+	}
+
+	wrrb.SetHealthInfo(map[string]bool{"a": false, "b": false, "c": false})
+
+	if _, _, err := wrrb.SelectTarget(sel); err == nil {
+		t.Error("expected error without healthy targets")
+	}
+}
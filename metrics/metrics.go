@@ -26,11 +26,141 @@ var (
 		},
 		[]string{"path"},
 	)
+
+	// ErrorRequestTotal stores number of requests failed by gateway,
+	// reason tells rate limit, gateway timeout, upstream closed connection and so on
+	ErrorRequestTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "error_request_total",
+			Help: "Total number of failed requests",
+		},
+		[]string{"path", "reason"},
+	)
+
+	// TargetEffectiveWeight stores weight of target used by balancer, it is
+	// lower than configured weight while target is in slow start
+	TargetEffectiveWeight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "target_effective_weight",
+			Help: "Effective weight of upstream target",
+		},
+		[]string{"prefix", "target"},
+	)
+
+	// CircuitBreakerState stores state of circuit breaker:
+	// 0 is closed, 1 is half-open, 2 is open
+	CircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "State of circuit breaker",
+		},
+		[]string{"prefix", "target"},
+	)
+
+	// CircuitBreakerTransitions stores number of circuit breaker state changes
+	CircuitBreakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_transitions_total",
+			Help: "Total number of circuit breaker state changes",
+		},
+		[]string{"prefix", "target", "state"},
+	)
+
+	// HedgeRequests stores number of hedged requests by result:
+	// won and lost are sent copies, skipped ones were not sent because of budget
+	HedgeRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hedge_requests_total",
+			Help: "Total number of hedged requests",
+		},
+		[]string{"prefix", "result"},
+	)
+
+	// SplitRequests stores number of requests sent to every pool of gateway
+	SplitRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "split_requests_total",
+			Help: "Total number of requests by pool of gateway",
+		},
+		[]string{"prefix", "pool"},
+	)
+
+	// MirrorRequests stores number of mirrored requests by result: match and mismatch
+	// compare status of shadow response with primary one, error means shadow request
+	// failed, skipped and dropped requests were not sent because of body size or limit
+	MirrorRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mirror_requests_total",
+			Help: "Total number of mirrored requests",
+		},
+		[]string{"prefix", "result"},
+	)
+
+	// MirrorStatus stores pairs of status classes of primary and shadow responses
+	MirrorStatus = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mirror_status_total",
+			Help: "Total number of mirrored requests by primary and shadow status class",
+		},
+		[]string{"prefix", "primary", "shadow"},
+	)
+
+	// MirrorLatencyDiff stores latency of shadow response minus latency of primary one
+	MirrorLatencyDiff = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mirror_latency_diff_seconds",
+			Help:    "Difference between shadow and primary response latency",
+			Buckets: []float64{-1, -0.5, -0.25, -0.1, -0.05, -0.01, 0, 0.01, 0.05, 0.1, 0.25, 0.5, 1},
+		},
+		[]string{"prefix"},
+	)
+
+	// OpenStreams stores number of open websocket and event stream connections
+	OpenStreams = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "open_streams",
+			Help: "Number of open streaming connections",
+		},
+		[]string{"prefix"},
+	)
+
+	// GRPCRequests stores number of grpc calls by status code
+	GRPCRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grpc_requests_total",
+			Help: "Number of grpc calls by status code",
+		},
+		[]string{"prefix", "code"},
+	)
+
+	// AggregateCalls stores number of calls of aggregation gateways by result
+	AggregateCalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aggregate_calls_total",
+			Help: "Number of aggregation calls by result",
+		},
+		[]string{"prefix", "call", "result"},
+	)
 )
 
 // InitMetrics() initialize metrics
 func InitMetrics() {
 	sync.OnceFunc(func() {
-		prometheus.MustRegister(RequestDuration, RequestTotal)
+		prometheus.MustRegister(
+			RequestDuration,
+			RequestTotal,
+			ErrorRequestTotal,
+			TargetEffectiveWeight,
+			CircuitBreakerState,
+			CircuitBreakerTransitions,
+			HedgeRequests,
+			SplitRequests,
+			MirrorRequests,
+			MirrorStatus,
+			MirrorLatencyDiff,
+			OpenStreams,
+			GRPCRequests,
+			AggregateCalls,
+		)
 	})()
 }
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+)
+
+// budgetBuckets is count of one second buckets in retry budget window
+const budgetBuckets = 10
+
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+// retryBudget limits retries to percent of requests in the last
+// seconds, so retries can not multiply load of failing upstream
+type retryBudget struct {
+	percent      int
+	minPerSecond int
+	buckets      [budgetBuckets]budgetBucket
+	mu           sync.Mutex
+}
+
+func newRetryBudget(cfg config.RetryBudgetConfig) *retryBudget {
+	return &retryBudget{
+		percent:      cfg.Percent,
+		minPerSecond: cfg.MinPerSecond,
+	}
+}
+
+// request counts new incoming request
+func (rb *retryBudget) request() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.bucket(time.Now()).requests++
+}
+
+// allowRetry returns true and counts retry when budget is not exhausted
+func (rb *retryBudget) allowRetry() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	now := time.Now()
+	current := rb.bucket(now)
+
+	requests, retries := 0, 0
+	for _, b := range rb.buckets {
+		if now.Unix()-b.second < budgetBuckets {
+			requests += b.requests
+			retries += b.retries
+		}
+	}
+
+	if retries >= rb.minPerSecond*budgetBuckets && retries*100 >= requests*rb.percent {
+		return false
+	}
+
+	current.retries++
+
+	return true
+}
+
+// bucket must be called with locked mutex
+func (rb *retryBudget) bucket(now time.Time) *budgetBucket {
+	second := now.Unix()
+
+	b := &rb.buckets[second%budgetBuckets]
+	if b.second != second {
+		*b = budgetBucket{second: second}
+	}
+
+	return b
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// streamDeadline is deadline of request which may become stream, it limits request
+	// until response headers and is lifted when response turns out to be stream,
+	// so idle timeout and lifetime of stream limit it instead
+	streamDeadline struct {
+		parent   context.Context
+		deadline time.Time
+		// outer is deadline of parent context, it is lifted together with this one
+		outer  *streamDeadline
+		done   chan struct{}
+		timer  *time.Timer
+		stop   func() bool
+		lifted atomic.Bool
+		err    error
+		mu     sync.Mutex
+	}
+
+	streamDeadlineKey struct{}
+)
+
+// WithStreamDeadline returns context with deadline which is lifted
+// when response of websocket or event stream request is stream
+func WithStreamDeadline(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	sd := &streamDeadline{
+		parent:   parent,
+		deadline: time.Now().Add(timeout),
+		done:     make(chan struct{}),
+	}
+	sd.outer, _ = parent.Value(streamDeadlineKey{}).(*streamDeadline)
+
+	// callbacks wait until both of them are set
+	sd.mu.Lock()
+	sd.timer = time.AfterFunc(timeout, func() { sd.cancel(context.DeadlineExceeded) })
+	sd.stop = context.AfterFunc(parent, func() { sd.cancel(parent.Err()) })
+	sd.mu.Unlock()
+
+	return sd, func() { sd.cancel(context.Canceled) }
+}
+
+// liftDeadline stops deadlines of ctx set by WithStreamDeadline, deadlines
+// which already expired are kept, so expired request is not revived
+func liftDeadline(ctx context.Context) {
+	sd, _ := ctx.Value(streamDeadlineKey{}).(*streamDeadline)
+
+	for ; sd != nil; sd = sd.outer {
+		sd.mu.Lock()
+		if sd.err == nil && sd.timer.Stop() {
+			sd.lifted.Store(true)
+		}
+		sd.mu.Unlock()
+	}
+}
+
+func (sd *streamDeadline) cancel(err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if sd.err != nil {
+		return
+	}
+
+	sd.err = err
+	close(sd.done)
+	sd.timer.Stop()
+	sd.stop()
+}
+
+func (sd *streamDeadline) Deadline() (time.Time, bool) {
+	deadline, ok := sd.parent.Deadline()
+	if sd.lifted.Load() || ok && deadline.Before(sd.deadline) {
+		return deadline, ok
+	}
+
+	return sd.deadline, true
+}
+
+func (sd *streamDeadline) Done() <-chan struct{} {
+	return sd.done
+}
+
+func (sd *streamDeadline) Err() error {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	return sd.err
+}
+
+func (sd *streamDeadline) Value(key any) any {
+	if key == (streamDeadlineKey{}) {
+		return sd
+	}
+
+	return sd.parent.Value(key)
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+)
+
+func TestStreamDeadline(t *testing.T) {
+	tests := []struct {
+		name string
+		// lift is time after which deadline is lifted, zero keeps it
+		lift    time.Duration
+		wantErr error
+	}{
+		{name: "expires", wantErr: context.DeadlineExceeded},
+		{name: "lifted before expiry", lift: time.Millisecond, wantErr: nil},
+		{name: "lifted after expiry", lift: 50 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := WithStreamDeadline(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			if tt.lift > 0 {
+				time.Sleep(tt.lift)
+				liftDeadline(ctx)
+			}
+
+			time.Sleep(60 * time.Millisecond)
+
+			if err := ctx.Err(); err != tt.wantErr {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+
+			_, ok := ctx.Deadline()
+			if lifted := tt.wantErr == nil; ok == lifted {
+				t.Errorf("deadline is set %v after lift %v", ok, lifted)
+			}
+
+			cancel()
+
+			if err := ctx.Err(); tt.wantErr == nil && err != context.Canceled {
+				t.Errorf("error %v after cancel, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestStreamDeadlineParent(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.WithValue(context.Background(), requestStateKey{}, "state"))
+
+	ctx, cancel := WithStreamDeadline(parent, time.Hour)
+	defer cancel()
+
+	if ctx.Value(requestStateKey{}) != "state" {
+		t.Errorf("values of parent are not inherited")
+	}
+
+	liftDeadline(ctx)
+	cancelParent()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("context is not done after parent was canceled")
+	}
+
+	if err := ctx.Err(); err != context.Canceled {
+		t.Errorf("error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestStreamDeadlineNested(t *testing.T) {
+	// request deadline of gateway and per try deadline are lifted together
+	outer, cancelOuter := WithStreamDeadline(context.Background(), 20*time.Millisecond)
+	defer cancelOuter()
+
+	inner, cancelInner := WithStreamDeadline(outer, 10*time.Millisecond)
+	defer cancelInner()
+
+	liftDeadline(inner)
+	time.Sleep(40 * time.Millisecond)
+
+	if err := inner.Err(); err != nil {
+		t.Errorf("inner context failed after lift: %v", err)
+	}
+
+	if err := outer.Err(); err != nil {
+		t.Errorf("outer context failed after lift: %v", err)
+	}
+
+	// shorter deadline of parent is reported until lift
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Second)
+	defer cancelParent()
+
+	ctx, cancel := WithStreamDeadline(parent, time.Hour)
+	defer cancel()
+
+	want, _ := parent.Deadline()
+	if got, ok := ctx.Deadline(); !ok || !got.Equal(want) {
+		t.Errorf("deadline %s, want deadline of parent %s", got, want)
+	}
+}
+
+func TestStreamPerTryTimeout(t *testing.T) {
+	target := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte("late"))
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+
+		// stream lives longer than per try timeout
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		accept     string
+		wantStatus int
+		wantEvents int
+	}{
+		{name: "stream outlives timeout", path: "/events", accept: "text/event-stream", wantStatus: http.StatusOK, wantEvents: 5},
+		{name: "slow response of stream request", path: "/slow", accept: "text/event-stream", wantStatus: http.StatusGatewayTimeout},
+		{name: "slow plain response", path: "/slow", wantStatus: http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(target)
+			gateway.Retry = config.RetryConfig{PerTryTimeout: 50 * time.Millisecond}
+
+			handler, _ := newTestProxy(t, gateway, config.RetryBudgetConfig{})
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if events := strings.Count(rec.Body.String(), "data: "); events != tt.wantEvents {
+				t.Errorf("got %d events, want %d", events, tt.wantEvents)
+			}
+		})
+	}
+}
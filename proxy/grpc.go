@@ -0,0 +1,476 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osamikoyo/orion/metrics"
+	"github.com/osamikoyo/orion/transcoder"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	grpcContentType        = "application/grpc"
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+	// grpcTrailerFlag marks frame of grpc-web response which carries trailers
+	grpcTrailerFlag = 0x80
+	// grpcMaxTimeout is the biggest value of grpc-timeout header
+	grpcMaxTimeout = 99999999
+)
+
+type (
+	// grpcBody reports status of grpc call when response is finished,
+	// for grpc-web calls it also translates response and puts trailers into its body
+	grpcBody struct {
+		io.ReadCloser
+		resp *http.Response
+		// web is set for grpc-web calls, text encodes response in base64
+		web    bool
+		text   bool
+		buf    []byte
+		out    bytes.Buffer
+		eof    bool
+		report func(code codes.Code, message string)
+		once   sync.Once
+	}
+
+	// base64Reader decodes body of grpc-web-text request,
+	// client may pad every chunk of it
+	base64Reader struct {
+		io.ReadCloser
+		// in stores characters of incomplete quantum
+		in  []byte
+		out []byte
+		err error
+	}
+)
+
+// grpcTimeoutUnits stores units of grpc-timeout header
+var grpcTimeoutUnits = []struct {
+	unit   time.Duration
+	suffix byte
+}{
+	{time.Nanosecond, 'n'},
+	{time.Microsecond, 'u'},
+	{time.Millisecond, 'm'},
+	{time.Second, 'S'},
+	{time.Minute, 'M'},
+	{time.Hour, 'H'},
+}
+
+// IsGRPC returns true for grpc and grpc-web requests
+func IsGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType)
+}
+
+// GRPCTimeout returns deadline of call sent by grpc client in grpc-timeout header
+func GRPCTimeout(r *http.Request) (time.Duration, bool) {
+	value := r.Header.Get("Grpc-Timeout")
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	for _, u := range grpcTimeoutUnits {
+		if u.suffix != value[len(value)-1] {
+			continue
+		}
+
+		if n > math.MaxInt64/int64(u.unit) {
+			return math.MaxInt64, true
+		}
+
+		return time.Duration(n) * u.unit, true
+	}
+
+	return 0, false
+}
+
+// encodeGRPCTimeout formats timeout with the smallest unit which fits into header
+func encodeGRPCTimeout(d time.Duration) string {
+	// target must not wait when deadline is already exceeded
+	d = max(d, time.Nanosecond)
+
+	for _, u := range grpcTimeoutUnits {
+		// value is rounded up without overflow of the longest deadlines
+		v := d / u.unit
+		if d%u.unit != 0 {
+			v++
+		}
+
+		if v <= grpcMaxTimeout {
+			return strconv.FormatInt(int64(v), 10) + string(u.suffix)
+		}
+	}
+
+	return strconv.Itoa(grpcMaxTimeout) + "H"
+}
+
+// grpcWebMode returns true when content type is grpc-web,
+// text is true for base64 encoded grpc-web-text
+func grpcWebMode(contentType string) (web bool, text bool) {
+	switch {
+	case strings.HasPrefix(contentType, grpcWebTextContentType):
+		return true, true
+	case strings.HasPrefix(contentType, grpcWebContentType):
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// rewriteGRPCWeb turns grpc-web request into grpc one, subtype like +proto is kept
+func rewriteGRPCWeb(pr *httputil.ProxyRequest) {
+	contentType := pr.In.Header.Get("Content-Type")
+
+	web, text := grpcWebMode(contentType)
+	if !web {
+		return
+	}
+
+	prefix := grpcWebContentType
+	if text {
+		prefix = grpcWebTextContentType
+	}
+
+	pr.Out.Header.Set("Content-Type", grpcContentType+strings.TrimPrefix(contentType, prefix))
+	pr.Out.Header.Set("Te", "trailers")
+
+	if text && pr.Out.Body != nil && pr.Out.Body != http.NoBody {
+		// size of decoded body is unknown
+		pr.Out.Body = &base64Reader{ReadCloser: pr.Out.Body}
+		pr.Out.ContentLength = -1
+		pr.Out.Header.Del("Content-Length")
+	}
+}
+
+// grpcResponse wraps body of grpc response, so status of call is
+// reported when it is finished, response of grpc-web call is translated
+func (mw *ProxyMW) grpcResponse(resp *http.Response, gateway string, in *http.Request) {
+	body := &grpcBody{
+		ReadCloser: resp.Body,
+		resp:       resp,
+		report: func(code codes.Code, message string) {
+			mw.reportGRPC(gateway, in, code, message)
+		},
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+
+	// errors of targets which are not grpc responses are passed as is
+	if web, text := grpcWebMode(in.Header.Get("Content-Type")); web && strings.HasPrefix(contentType, grpcContentType) {
+		prefix := grpcWebContentType
+		if text {
+			prefix = grpcWebTextContentType
+		}
+
+		resp.Header.Set("Content-Type", prefix+strings.TrimPrefix(contentType, grpcContentType))
+		resp.Header.Del("Trailer")
+		// browsers can't read trailers, so they are sent in body
+		resp.Trailer = nil
+
+		body.web = true
+		body.text = text
+		body.buf = make([]byte, 32<<10)
+	}
+
+	resp.Body = body
+}
+
+// reportGRPC records status of grpc call
+func (mw *ProxyMW) reportGRPC(gateway string, in *http.Request, code codes.Code, message string) {
+	metrics.GRPCRequests.WithLabelValues(gateway, code.String()).Inc()
+
+	if code != codes.OK {
+		mw.logger.Warn("grpc call failed",
+			zap.String("prefix", gateway),
+			zap.String("method", in.URL.Path),
+			zap.String("code", code.String()),
+			zap.String("message", message))
+	}
+}
+
+// writeGRPCError writes trailers-only response with status of failed call
+func (mw *ProxyMW) writeGRPCError(w http.ResponseWriter, in *http.Request, gateway string, code codes.Code, message string) {
+	contentType := grpcContentType
+	if web, text := grpcWebMode(in.Header.Get("Content-Type")); web {
+		contentType = grpcWebContentType
+		if text {
+			contentType = grpcWebTextContentType
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(code)))
+	w.Header().Set("Grpc-Message", url.PathEscape(message))
+	w.WriteHeader(http.StatusOK)
+
+	metrics.GRPCRequests.WithLabelValues(gateway, code.String()).Inc()
+}
+
+// transcodeResponse turns response of transcoded grpc call into JSON,
+// responses bigger than maxBodySize are replaced with bad gateway error
+func transcodeResponse(resp *http.Response, call *transcoder.Call, maxBodySize int64) error {
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	resp.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	if int64(len(payload)) > maxBodySize {
+		_, body := call.Error(codes.ResourceExhausted, "response body is too large", "")
+		setTranscodedBody(resp, http.StatusBadGateway, body)
+
+		return nil
+	}
+
+	code, message := grpcStatus(resp, resp.Trailer)
+
+	status, body := http.StatusOK, []byte(nil)
+	if code == codes.OK {
+		body, err = call.Response(payload)
+		if err != nil {
+			code, message = codes.Internal, err.Error()
+		}
+	}
+
+	if code != codes.OK {
+		details := cmp.Or(resp.Trailer.Get("Grpc-Status-Details-Bin"), resp.Header.Get("Grpc-Status-Details-Bin"))
+		status, body = call.Error(code, message, details)
+	}
+
+	setTranscodedBody(resp, status, body)
+
+	return nil
+}
+
+// setTranscodedBody replaces grpc response with JSON body of REST call
+func setTranscodedBody(resp *http.Response, status int, body []byte) {
+	for _, name := range []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin", "Trailer"} {
+		resp.Header.Del(name)
+	}
+
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.StatusCode = status
+	resp.Status = strconv.Itoa(status) + " " + http.StatusText(status)
+	resp.Trailer = nil
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+}
+
+// grpcStatus returns status of finished grpc response, it is sent in trailers
+// or in headers of trailers-only response
+func grpcStatus(resp *http.Response, trailer http.Header) (codes.Code, string) {
+	if resp.StatusCode != http.StatusOK {
+		return httpToGRPC(resp.StatusCode), resp.Status
+	}
+
+	status, message := trailer.Get("Grpc-Status"), trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+
+	if status == "" {
+		return codes.Unknown, "grpc-status is missing"
+	}
+
+	code, err := strconv.ParseUint(status, 10, 32)
+	if err != nil {
+		return codes.Unknown, "invalid grpc-status " + status
+	}
+
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+
+	return codes.Code(code), message
+}
+
+// httpToGRPC maps http status of response without grpc status
+// like grpc clients do it
+func httpToGRPC(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
+
+// grpcErrorCode returns status of call failed by proxy
+func grpcErrorCode(status int, reason string) codes.Code {
+	switch reason {
+	case "gateway_timeout":
+		return codes.DeadlineExceeded
+	case "client_closed":
+		return codes.Canceled
+	default:
+		return httpToGRPC(status)
+	}
+}
+
+func (b *grpcBody) Read(p []byte) (int, error) {
+	if !b.web {
+		n, err := b.ReadCloser.Read(p)
+		if err == io.EOF {
+			b.finish()
+		}
+
+		return n, err
+	}
+
+	for b.out.Len() == 0 {
+		if b.eof {
+			return 0, io.EOF
+		}
+
+		n, err := b.ReadCloser.Read(b.buf)
+		b.write(b.buf[:n])
+
+		switch {
+		case err == io.EOF:
+			b.eof = true
+			b.finish()
+		case err != nil:
+			return 0, err
+		}
+	}
+
+	return b.out.Read(p)
+}
+
+func (b *grpcBody) Close() error {
+	err := b.ReadCloser.Close()
+
+	// body closed before its end means that client has gone
+	b.once.Do(func() {
+		b.report(codes.Canceled, "response is not finished")
+	})
+
+	if b.web {
+		b.resp.Trailer = nil
+	}
+
+	return err
+}
+
+// finish reports status of call, trailers of grpc-web response are written to body
+func (b *grpcBody) finish() {
+	b.once.Do(func() {
+		// trailers are filled by transport when body is read to the end
+		trailer := b.resp.Trailer
+
+		if b.web {
+			b.resp.Trailer = nil
+
+			if len(trailer) > 0 {
+				b.write(trailerFrame(trailer))
+			}
+		}
+
+		b.report(grpcStatus(b.resp, trailer))
+	})
+}
+
+// write adds data to translated response
+func (b *grpcBody) write(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	if b.text {
+		// every chunk is padded, so client decodes it without waiting for the next one
+		b.out.WriteString(base64.StdEncoding.EncodeToString(data))
+
+		return
+	}
+
+	b.out.Write(data)
+}
+
+// trailerFrame encodes trailers like http/1 headers in grpc-web frame
+func trailerFrame(trailer http.Header) []byte {
+	var buf bytes.Buffer
+
+	for name, values := range trailer {
+		for _, value := range values {
+			buf.WriteString(strings.ToLower(name) + ": " + value + "\r\n")
+		}
+	}
+
+	frame := make([]byte, 5, 5+buf.Len())
+	frame[0] = grpcTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(buf.Len()))
+
+	return append(frame, buf.Bytes()...)
+}
+
+func (br *base64Reader) Read(p []byte) (int, error) {
+	for len(br.out) == 0 {
+		if br.err != nil {
+			return 0, br.err
+		}
+
+		var buf [4 << 10]byte
+
+		n, err := br.ReadCloser.Read(buf[:])
+		br.err = err
+		br.in = append(br.in, buf[:n]...)
+
+		// quantum of 4 characters is decoded with its own padding
+		full := len(br.in) / 4 * 4
+		decoded := make([]byte, full/4*3)
+
+		size := 0
+		for i := 0; i < full; i += 4 {
+			m, err := base64.StdEncoding.Decode(decoded[size:], br.in[i:i+4])
+			if err != nil {
+				br.err = err
+
+				break
+			}
+
+			size += m
+		}
+
+		br.out = decoded[:size]
+		br.in = append(br.in[:0], br.in[full:]...)
+
+		if br.err == io.EOF && len(br.in) > 0 {
+			br.err = io.ErrUnexpectedEOF
+		}
+	}
+
+	n := copy(p, br.out)
+	br.out = br.out[n:]
+
+	return n, nil
+}
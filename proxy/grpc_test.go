@@ -0,0 +1,707 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// grpcFrame returns length prefixed message with flag
+func grpcFrame(flag byte, payload []byte) []byte {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+
+	return append(frame, payload...)
+}
+
+// readGRPCFrames splits body into frames, flags are returned by index of frame
+func readGRPCFrames(t *testing.T, body []byte) ([]byte, [][]byte) {
+	t.Helper()
+
+	var (
+		flags  []byte
+		frames [][]byte
+	)
+
+	for len(body) > 0 {
+		if len(body) < 5 {
+			t.Fatalf("frame header is truncated: %q", body)
+		}
+
+		size := int(binary.BigEndian.Uint32(body[1:5]))
+		if len(body) < 5+size {
+			t.Fatalf("frame of %d bytes is truncated: %q", size, body)
+		}
+
+		flags = append(flags, body[0])
+		frames = append(frames, body[5:5+size])
+		body = body[5+size:]
+	}
+
+	return flags, frames
+}
+
+func TestGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{name: "nanoseconds", header: "100n", want: 100 * time.Nanosecond, ok: true},
+		{name: "microseconds", header: "100u", want: 100 * time.Microsecond, ok: true},
+		{name: "milliseconds", header: "100m", want: 100 * time.Millisecond, ok: true},
+		{name: "seconds", header: "10S", want: 10 * time.Second, ok: true},
+		{name: "minutes", header: "2M", want: 2 * time.Minute, ok: true},
+		{name: "hours", header: "1H", want: time.Hour, ok: true},
+		{name: "max digits", header: "99999999n", want: 99999999 * time.Nanosecond, ok: true},
+		{name: "overflow is clamped", header: "99999999H", want: math.MaxInt64, ok: true},
+		{name: "missing", header: "", ok: false},
+		{name: "no value", header: "S", ok: false},
+		{name: "too many digits", header: "123456789S", ok: false},
+		{name: "unknown unit", header: "10s", ok: false},
+		{name: "negative", header: "-1S", ok: false},
+		{name: "not a number", header: "1.5S", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/test", nil)
+			if tt.header != "" {
+				r.Header.Set("Grpc-Timeout", tt.header)
+			}
+
+			got, ok := GRPCTimeout(r)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("got %s, %v, want %s, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestEncodeGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    string
+	}{
+		{name: "exceeded", timeout: -time.Second, want: "1n"},
+		{name: "zero", timeout: 0, want: "1n"},
+		{name: "nanoseconds", timeout: 99999999 * time.Nanosecond, want: "99999999n"},
+		// the smallest unit which fits is rounded up, so target never gets longer deadline
+		{name: "rounded to microseconds", timeout: 100*time.Millisecond + time.Nanosecond, want: "100001u"},
+		{name: "seconds", timeout: 30 * time.Hour, want: "108000S"},
+		{name: "hours", timeout: math.MaxInt64, want: "2562048H"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodeGRPCTimeout(tt.timeout)
+			if got != tt.want {
+				t.Fatalf("encoded %q, want %q", got, tt.want)
+			}
+
+			// encoded value is parsed back to timeout which is not shorter
+			r := httptest.NewRequest(http.MethodPost, "/test", nil)
+			r.Header.Set("Grpc-Timeout", got)
+
+			decoded, ok := GRPCTimeout(r)
+			if !ok || decoded < tt.timeout {
+				t.Errorf("decoded %s, %v, want at least %s", decoded, ok, tt.timeout)
+			}
+		})
+	}
+}
+
+func TestHTTPToGRPC(t *testing.T) {
+	tests := []struct {
+		status int
+		want   codes.Code
+	}{
+		{status: http.StatusBadRequest, want: codes.Internal},
+		{status: http.StatusUnauthorized, want: codes.Unauthenticated},
+		{status: http.StatusForbidden, want: codes.PermissionDenied},
+		{status: http.StatusNotFound, want: codes.Unimplemented},
+		{status: http.StatusTooManyRequests, want: codes.Unavailable},
+		{status: http.StatusBadGateway, want: codes.Unavailable},
+		{status: http.StatusServiceUnavailable, want: codes.Unavailable},
+		{status: http.StatusGatewayTimeout, want: codes.Unavailable},
+		{status: http.StatusInternalServerError, want: codes.Unknown},
+		{status: http.StatusConflict, want: codes.Unknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			if got := httpToGRPC(tt.status); got != tt.want {
+				t.Errorf("code %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCErrorCode(t *testing.T) {
+	tests := []struct {
+		status int
+		reason string
+		want   codes.Code
+	}{
+		{status: http.StatusGatewayTimeout, reason: "gateway_timeout", want: codes.DeadlineExceeded},
+		{status: http.StatusBadGateway, reason: "client_closed", want: codes.Canceled},
+		{status: http.StatusServiceUnavailable, reason: "circuit_open", want: codes.Unavailable},
+		{status: http.StatusBadGateway, reason: "upstream_error", want: codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			if got := grpcErrorCode(tt.status, tt.reason); got != tt.want {
+				t.Errorf("code %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		header      http.Header
+		trailer     http.Header
+		wantCode    codes.Code
+		wantMessage string
+	}{
+		{
+			name:        "trailers",
+			status:      http.StatusOK,
+			trailer:     http.Header{"Grpc-Status": {"5"}, "Grpc-Message": {"user%20not%20found"}},
+			wantCode:    codes.NotFound,
+			wantMessage: "user not found",
+		},
+		{
+			name:        "trailers only",
+			status:      http.StatusOK,
+			header:      http.Header{"Grpc-Status": {"0"}},
+			wantCode:    codes.OK,
+			wantMessage: "",
+		},
+		{
+			name:        "trailers over headers",
+			status:      http.StatusOK,
+			header:      http.Header{"Grpc-Status": {"0"}},
+			trailer:     http.Header{"Grpc-Status": {"13"}, "Grpc-Message": {"failed"}},
+			wantCode:    codes.Internal,
+			wantMessage: "failed",
+		},
+		{
+			name:        "missing",
+			status:      http.StatusOK,
+			wantCode:    codes.Unknown,
+			wantMessage: "grpc-status is missing",
+		},
+		{
+			name:        "invalid",
+			status:      http.StatusOK,
+			trailer:     http.Header{"Grpc-Status": {"ok"}},
+			wantCode:    codes.Unknown,
+			wantMessage: "invalid grpc-status ok",
+		},
+		{
+			name:        "message is not escaped",
+			status:      http.StatusOK,
+			trailer:     http.Header{"Grpc-Status": {"2"}, "Grpc-Message": {"100%"}},
+			wantCode:    codes.Unknown,
+			wantMessage: "100%",
+		},
+		{
+			name:        "http error",
+			status:      http.StatusServiceUnavailable,
+			trailer:     http.Header{"Grpc-Status": {"0"}},
+			wantCode:    codes.Unavailable,
+			wantMessage: "503 Service Unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Status:     strconv.Itoa(tt.status) + " " + http.StatusText(tt.status),
+				Header:     http.Header{},
+			}
+			for name, values := range tt.header {
+				resp.Header[name] = values
+			}
+
+			code, message := grpcStatus(resp, tt.trailer)
+			if code != tt.wantCode || message != tt.wantMessage {
+				t.Errorf("got %s %q, want %s %q", code, message, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestBase64Reader(t *testing.T) {
+	first := grpcFrame(0, []byte("hello"))
+	second := grpcFrame(0, []byte("grpc web"))
+
+	tests := []struct {
+		name    string
+		body    string
+		want    []byte
+		wantErr error
+	}{
+		{
+			name: "single chunk",
+			body: base64.StdEncoding.EncodeToString(append(append([]byte{}, first...), second...)),
+			want: append(append([]byte{}, first...), second...),
+		},
+		{
+			// every chunk of client is padded on its own
+			name: "padded chunks",
+			body: base64.StdEncoding.EncodeToString(first) + base64.StdEncoding.EncodeToString(second),
+			want: append(append([]byte{}, first...), second...),
+		},
+		{
+			name:    "incomplete quantum",
+			body:    base64.StdEncoding.EncodeToString(first) + "QQ",
+			want:    first,
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "invalid",
+			body:    "!!!!",
+			wantErr: base64.CorruptInputError(0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// quantums are split between reads
+			br := &base64Reader{ReadCloser: io.NopCloser(iotest.OneByteReader(strings.NewReader(tt.body)))}
+
+			got, err := io.ReadAll(br)
+			if err != tt.wantErr {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("decoded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCBodyText(t *testing.T) {
+	// translated response is decoded chunk by chunk like browsers do it
+	data := grpcFrame(0, []byte("reply"))
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Trailer:    http.Header{"Grpc-Status": {"0"}},
+	}
+
+	var reported []codes.Code
+
+	body := &grpcBody{
+		ReadCloser: io.NopCloser(iotest.OneByteReader(bytes.NewReader(data))),
+		resp:       resp,
+		web:        true,
+		text:       true,
+		buf:        make([]byte, 2),
+		report: func(code codes.Code, message string) {
+			reported = append(reported, code)
+		},
+	}
+
+	encoded, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("failed read body: %v", err)
+	}
+	body.Close()
+
+	decoded, err := io.ReadAll(&base64Reader{ReadCloser: io.NopCloser(bytes.NewReader(encoded))})
+	if err != nil {
+		t.Fatalf("failed decode body %q: %v", encoded, err)
+	}
+
+	flags, frames := readGRPCFrames(t, decoded)
+	if len(frames) != 2 || flags[0] != 0 || string(frames[0]) != "reply" || flags[1] != grpcTrailerFlag {
+		t.Fatalf("got frames %q with flags %v, want reply and trailers", frames, flags)
+	}
+
+	if string(frames[1]) != "grpc-status: 0\r\n" {
+		t.Errorf("trailers %q, want grpc-status", frames[1])
+	}
+
+	if resp.Trailer != nil {
+		t.Errorf("trailers %v are left in response", resp.Trailer)
+	}
+
+	if len(reported) != 1 || reported[0] != codes.OK {
+		t.Errorf("reported %v, want single OK", reported)
+	}
+}
+
+func TestTrailerFrame(t *testing.T) {
+	frame := trailerFrame(http.Header{
+		"Grpc-Status":  {"3"},
+		"Grpc-Message": {"bad%20id"},
+		"X-Values":     {"a", "b"},
+	})
+
+	flags, frames := readGRPCFrames(t, frame)
+	if len(frames) != 1 || flags[0] != grpcTrailerFlag {
+		t.Fatalf("got %d frames with flags %v, want single trailer frame", len(frames), flags)
+	}
+
+	// order of headers in map is random
+	lines := strings.SplitAfter(string(frames[0]), "\r\n")
+	if lines[len(lines)-1] != "" {
+		t.Fatalf("trailers %q are not terminated by crlf", frames[0])
+	}
+
+	got := make(map[string]bool)
+	for _, line := range lines[:len(lines)-1] {
+		got[line] = true
+	}
+
+	for _, want := range []string{"grpc-status: 3\r\n", "grpc-message: bad%20id\r\n", "x-values: a\r\n", "x-values: b\r\n"} {
+		if !got[want] {
+			t.Errorf("trailers %q have no line %q", frames[0], want)
+		}
+	}
+
+	if len(got) != 4 {
+		t.Errorf("got %d trailer lines, want 4", len(got))
+	}
+}
+
+func TestGRPCWebRoundTrip(t *testing.T) {
+	// target speaks grpc over h2c and echoes message back
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.ProtoMajor != 2:
+			t.Errorf("request is sent over %s, want HTTP/2", r.Proto)
+		case r.Header.Get("Content-Type") != "application/grpc+proto":
+			t.Errorf("content type %q, want application/grpc+proto", r.Header.Get("Content-Type"))
+		case r.Header.Get("Te") != "trailers":
+			t.Errorf("te %q, want trailers", r.Header.Get("Te"))
+		}
+
+		// rest of deadline of call is sent to target
+		if timeout, ok := GRPCTimeout(r); !ok || timeout <= 0 || timeout > time.Second {
+			t.Errorf("grpc-timeout %q, want at most 1s", r.Header.Get("Grpc-Timeout"))
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed read request: %v", err)
+		}
+
+		_, frames := readGRPCFrames(t, body)
+		if len(frames) != 1 {
+			t.Errorf("got %d frames, want 1", len(frames))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Write(grpcFrame(0, append([]byte("echo "), frames[0]...)))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "done")
+	}))
+
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	gateway := newTestGateway("h2c://" + strings.TrimPrefix(server.URL, "http://"))
+	gateway.GRPC = config.GRPCConfig{Use: true, Web: true}
+
+	handler, _ := newTestProxy(t, gateway, config.RetryBudgetConfig{})
+
+	tests := []struct {
+		name        string
+		contentType string
+		text        bool
+	}{
+		{name: "grpc-web", contentType: "application/grpc-web+proto"},
+		{name: "grpc-web-text", contentType: "application/grpc-web-text+proto", text: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := grpcFrame(0, []byte("ping"))
+			if tt.text {
+				body = []byte(base64.StdEncoding.EncodeToString(body))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			r := httptest.NewRequest(http.MethodPost, "/test.Echo/Echo", bytes.NewReader(body)).WithContext(ctx)
+			r.Header.Set("Content-Type", tt.contentType)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("content type %q, want %q", got, tt.contentType)
+			}
+
+			payload := rec.Body.Bytes()
+			if tt.text {
+				decoded, err := io.ReadAll(&base64Reader{ReadCloser: io.NopCloser(bytes.NewReader(payload))})
+				if err != nil {
+					t.Fatalf("failed decode response %q: %v", payload, err)
+				}
+
+				payload = decoded
+			}
+
+			flags, frames := readGRPCFrames(t, payload)
+			if len(frames) != 2 {
+				t.Fatalf("got %d frames, want message and trailers", len(frames))
+			}
+
+			if flags[0] != 0 || string(frames[0]) != "echo ping" {
+				t.Errorf("message %q with flag %d, want %q", frames[0], flags[0], "echo ping")
+			}
+
+			if flags[1] != grpcTrailerFlag {
+				t.Errorf("flag of trailer frame %d, want %d", flags[1], grpcTrailerFlag)
+			}
+
+			trailers := string(frames[1])
+			if !strings.Contains(trailers, "grpc-status: 0\r\n") || !strings.Contains(trailers, "grpc-message: done\r\n") {
+				t.Errorf("trailers %q, want status and message", trailers)
+			}
+
+			// browsers can't read http trailers, so none are sent
+			if trailer := rec.Result().Trailer; len(trailer) > 0 {
+				t.Errorf("http trailers %v are sent", trailer)
+			}
+		})
+	}
+}
+
+// newTestDescriptor writes descriptor set of books service with
+// GET, PUT and POST rules and returns its path
+func newTestDescriptor(t *testing.T) string {
+	t.Helper()
+
+	// kind and body are numbers of fields of google.api.HttpRule
+	method := func(name string, kind protowire.Number, path string) *descriptorpb.MethodDescriptorProto {
+		rule := protowire.AppendString(protowire.AppendTag(nil, kind, protowire.BytesType), path)
+		if kind != 2 {
+			rule = protowire.AppendString(protowire.AppendTag(rule, 7, protowire.BytesType), "*")
+		}
+
+		opts := &descriptorpb.MethodOptions{}
+		opts.ProtoReflect().SetUnknown(protowire.AppendBytes(protowire.AppendTag(nil, 72295728, protowire.BytesType), rule))
+
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".books.Book"),
+			OutputType: proto.String(".books.Book"),
+			Options:    opts,
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("books.proto"),
+		Package: proto.String("books"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Book"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("name"),
+				JsonName: proto.String("name"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Books"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("GetBook", 2, "/test/books/{name}"),
+				method("UpdateBook", 3, "/test/books/{name}"),
+				method("CreateBook", 4, "/test/books"),
+			},
+		}},
+	}
+
+	raw, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatalf("failed encode descriptor set: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "books.pb")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatalf("failed write descriptor set: %v", err)
+	}
+
+	return path
+}
+
+// newTestGRPCTarget starts h2c target which answers grpc calls with book
+// after handler, handler returns false when it has answered by itself
+func newTestGRPCTarget(t *testing.T, handler func(w http.ResponseWriter, r *http.Request) bool) string {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+
+		if !handler(w, r) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Write(grpcFrame(0, protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "dune")))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return "h2c://" + strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestTranscodedIdempotency(t *testing.T) {
+	descriptor := newTestDescriptor(t)
+
+	tests := []struct {
+		name   string
+		hedge  bool
+		method string
+		path   string
+		body   string
+		// repeated is true when call must be sent to the second target
+		repeated bool
+	}{
+		{name: "get is retried", method: http.MethodGet, path: "/test/books/dune", repeated: true},
+		{name: "put is retried", method: http.MethodPut, path: "/test/books/dune", body: `{"name":"dune"}`, repeated: true},
+		{name: "post is not retried", method: http.MethodPost, path: "/test/books", body: `{"name":"dune"}`, repeated: false},
+		{name: "get is hedged", hedge: true, method: http.MethodGet, path: "/test/books/dune", repeated: true},
+		{name: "put is not hedged", hedge: true, method: http.MethodPut, path: "/test/books/dune", body: `{"name":"dune"}`, repeated: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the first target fails or is slow, so call is repeated when it is idempotent
+			first := newTestGRPCTarget(t, func(w http.ResponseWriter, r *http.Request) bool {
+				if !tt.hedge {
+					w.WriteHeader(http.StatusServiceUnavailable)
+
+					return false
+				}
+
+				select {
+				case <-r.Context().Done():
+					return false
+				case <-time.After(200 * time.Millisecond):
+					return true
+				}
+			})
+
+			var called atomic.Int64
+			second := newTestGRPCTarget(t, func(w http.ResponseWriter, r *http.Request) bool {
+				// the whole request message is sent again
+				if got := r.ContentLength; got != 11 {
+					t.Errorf("repeated call has body of %d bytes, want 11", got)
+				}
+
+				called.Add(1)
+
+				return true
+			})
+
+			gateway := newTestGateway(first, second)
+			gateway.GRPC = config.GRPCConfig{Use: true, Descriptor: descriptor, MaxBodySize: 1 << 10}
+
+			if tt.hedge {
+				gateway.Hedge = config.HedgeConfig{Use: true, Delay: 20 * time.Millisecond, Budget: 100}
+			} else {
+				gateway.Retry = config.RetryConfig{Attempts: 2, On: []string{"503"}}
+			}
+
+			handler, _ := newTestProxy(t, gateway, config.RetryBudgetConfig{Percent: 100})
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, body))
+
+			if repeated := called.Load() > 0; repeated != tt.repeated {
+				t.Fatalf("second target called %v, want %v", repeated, tt.repeated)
+			}
+
+			if tt.repeated && (rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "dune")) {
+				t.Errorf("got %d %q, want book from second target", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestTranscodedResponseLimit(t *testing.T) {
+	descriptor := newTestDescriptor(t)
+
+	// framed book of target is 11 bytes long
+	tests := []struct {
+		name        string
+		maxBodySize int64
+		wantStatus  int
+		wantBody    string
+	}{
+		{name: "under limit", maxBodySize: 11, wantStatus: http.StatusOK, wantBody: "dune"},
+		{name: "over limit", maxBodySize: 10, wantStatus: http.StatusBadGateway, wantBody: "response body is too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestGRPCTarget(t, func(w http.ResponseWriter, r *http.Request) bool { return true })
+
+			gateway := newTestGateway(target)
+			gateway.GRPC = config.GRPCConfig{Use: true, Descriptor: descriptor, MaxBodySize: tt.maxBodySize}
+
+			handler, _ := newTestProxy(t, gateway, config.RetryBudgetConfig{})
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/books/dune", nil))
+
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %q, want %d with %q", rec.Code, rec.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
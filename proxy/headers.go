@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/osamikoyo/orion/auth"
+	"github.com/osamikoyo/orion/clientip"
+	"github.com/osamikoyo/orion/config"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// headerVar matches placeholder of header value template
+var headerVar = regexp.MustCompile(`\{([a-z_]+(?:\.[^{}]+)?)\}`)
+
+type (
+	// headerRules stores rules with environment variables already filled in
+	headerRules struct {
+		set    map[string]string
+		add    map[string]string
+		remove []string
+	}
+
+	// headerPolicy applies global and gateway header rules to requests and responses
+	headerPolicy struct {
+		route     string
+		request   []headerRules
+		response  []headerRules
+		hopByHop  []string
+		forwarded config.ForwardedConfig
+		resolver  *clientip.Resolver
+		// requestID is true when templates use request id,
+		// then it is generated for requests which have no one
+		requestID bool
+	}
+)
+
+func newHeaderPolicy(global config.HeadersConfig, gateway *config.Gateway) (*headerPolicy, error) {
+	forwarded := gateway.Headers.Forwarded
+	if forwarded.Mode == "" {
+		forwarded.Mode = global.Forwarded.Mode
+	}
+	if len(forwarded.TrustedProxies) == 0 {
+		forwarded.TrustedProxies = global.Forwarded.TrustedProxies
+	}
+
+	resolver, err := clientip.NewResolver(forwarded.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	hp := &headerPolicy{
+		route:     gateway.Name,
+		hopByHop:  slices.Concat(global.HopByHop, gateway.Headers.HopByHop),
+		forwarded: forwarded,
+		resolver:  resolver,
+	}
+
+	for _, cfg := range []config.HeadersConfig{global, gateway.Headers} {
+		hp.request = append(hp.request, hp.compile(cfg.Request))
+		hp.response = append(hp.response, hp.compile(cfg.Response))
+	}
+
+	return hp, nil
+}
+
+// compile fills environment variables, they do not change while gateway works
+func (hp *headerPolicy) compile(rules config.HeaderRules) headerRules {
+	env := func(values map[string]string) map[string]string {
+		compiled := make(map[string]string, len(values))
+
+		for name, value := range values {
+			value = headerVar.ReplaceAllStringFunc(value, func(placeholder string) string {
+				if name, ok := strings.CutPrefix(placeholder[1:len(placeholder)-1], "env."); ok {
+					return os.Getenv(name)
+				}
+
+				return placeholder
+			})
+
+			if strings.Contains(value, "{request_id}") {
+				hp.requestID = true
+			}
+
+			compiled[name] = value
+		}
+
+		return compiled
+	}
+
+	return headerRules{
+		set:    env(rules.Set),
+		add:    env(rules.Add),
+		remove: rules.Remove,
+	}
+}
+
+// rewriteRequest sets forwarding headers and applies request rules to outgoing request
+func (hp *headerPolicy) rewriteRequest(pr *httputil.ProxyRequest) {
+	hp.forward(pr)
+
+	if hp.requestID && pr.In.Header.Get(requestIDHeader) == "" {
+		// response rules read request id from incoming request
+		id := rand.Text()
+		pr.In.Header.Set(requestIDHeader, id)
+		pr.Out.Header.Set(requestIDHeader, id)
+	}
+
+	for _, name := range hp.hopByHop {
+		pr.Out.Header.Del(name)
+	}
+
+	for _, rules := range hp.request {
+		hp.apply(pr.Out.Header, rules, pr.In)
+	}
+}
+
+// modifyResponse applies response rules, in is request which came to gateway
+func (hp *headerPolicy) modifyResponse(resp *http.Response, in *http.Request) {
+	for _, name := range hp.hopByHop {
+		resp.Header.Del(name)
+	}
+
+	for _, rules := range hp.response {
+		hp.apply(resp.Header, rules, in)
+	}
+}
+
+// forward sets X-Forwarded-* headers by forwarded mode of gateway,
+// reverse proxy has already removed ones sent by client
+func (hp *headerPolicy) forward(pr *httputil.ProxyRequest) {
+	switch hp.forwarded.Mode {
+	case "off":
+		return
+	case "overwrite":
+		pr.SetXForwarded()
+
+		return
+	}
+
+	if !hp.resolver.IsTrusted(clientip.RemoteIP(pr.In)) {
+		pr.SetXForwarded()
+
+		return
+	}
+
+	// trusted proxy has already set original host and proto
+	if prior, ok := pr.In.Header["X-Forwarded-For"]; ok {
+		pr.Out.Header["X-Forwarded-For"] = prior
+	}
+
+	pr.SetXForwarded()
+
+	for _, name := range []string{"X-Forwarded-Host", "X-Forwarded-Proto"} {
+		if value := pr.In.Header.Get(name); value != "" {
+			pr.Out.Header.Set(name, value)
+		}
+	}
+}
+
+func (hp *headerPolicy) apply(header http.Header, rules headerRules, in *http.Request) {
+	for _, name := range rules.remove {
+		header.Del(name)
+	}
+
+	for name, value := range rules.set {
+		header.Set(name, hp.render(value, in))
+	}
+
+	for name, value := range rules.add {
+		header.Add(name, hp.render(value, in))
+	}
+}
+
+// render fills placeholders of value from request, unknown placeholders are kept
+func (hp *headerPolicy) render(value string, in *http.Request) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+
+	return headerVar.ReplaceAllStringFunc(value, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+
+		switch name {
+		case "client_ip":
+			return hp.resolver.ClientIP(in)
+		case "route":
+			return hp.route
+		case "request_id":
+			return in.Header.Get(requestIDHeader)
+		}
+
+		if claim, ok := strings.CutPrefix(name, "claim."); ok {
+			return auth.Claim(in, claim)
+		}
+
+		return placeholder
+	})
+}
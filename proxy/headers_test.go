@@ -0,0 +1,316 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/osamikoyo/orion/auth"
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+// newTestHeaderProxy proxies requests to target which answers with
+// response headers and returns headers of request it got
+func newTestHeaderProxy(t *testing.T, global config.HeadersConfig, gateway config.HeadersConfig, response http.Header) (http.Handler, func() http.Header) {
+	t.Helper()
+
+	received := make(chan http.Header, 1)
+
+	target := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+
+		for name, values := range response {
+			w.Header()[name] = values
+		}
+	})
+
+	gw := newTestGateway(target)
+	gw.Name = "users"
+	gw.Headers = gateway
+
+	cfg := &config.Config{Headers: global, Gateways: []config.Gateway{*gw}}
+
+	mw, err := NewProxyMW(cfg, &logger.Logger{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("failed create proxy: %v", err)
+	}
+
+	tu := newTestUpstream(gw)
+
+	return mw.Middleware(Upstream{Gateway: gw, Select: tu.selectTarget}), func() http.Header {
+		select {
+		case header := <-received:
+			return header
+		default:
+			t.Fatalf("target got no request")
+			return nil
+		}
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   config.HeadersConfig
+		gateway  config.HeadersConfig
+		request  http.Header
+		response http.Header
+		// wantRequest and wantResponse store expected values, nil value requires absence
+		wantRequest  map[string][]string
+		wantResponse map[string][]string
+	}{
+		{
+			name:        "set overwrites",
+			gateway:     config.HeadersConfig{Request: config.HeaderRules{Set: map[string]string{"X-Tenant": "orion"}}},
+			request:     http.Header{"X-Tenant": {"client"}},
+			wantRequest: map[string][]string{"X-Tenant": {"orion"}},
+		},
+		{
+			name:        "add appends",
+			gateway:     config.HeadersConfig{Request: config.HeaderRules{Add: map[string]string{"X-Tag": "gateway"}}},
+			request:     http.Header{"X-Tag": {"client"}},
+			wantRequest: map[string][]string{"X-Tag": {"client", "gateway"}},
+		},
+		{
+			name:        "remove",
+			gateway:     config.HeadersConfig{Request: config.HeaderRules{Remove: []string{"X-Debug"}}},
+			request:     http.Header{"X-Debug": {"1"}, "X-Keep": {"1"}},
+			wantRequest: map[string][]string{"X-Debug": nil, "X-Keep": {"1"}},
+		},
+		{
+			name:        "gateway rules after global",
+			global:      config.HeadersConfig{Request: config.HeaderRules{Set: map[string]string{"X-Env": "global", "X-Global": "1"}}},
+			gateway:     config.HeadersConfig{Request: config.HeaderRules{Set: map[string]string{"X-Env": "gateway"}}},
+			wantRequest: map[string][]string{"X-Env": {"gateway"}, "X-Global": {"1"}},
+		},
+		{
+			name:        "gateway removes header of global rule",
+			global:      config.HeadersConfig{Request: config.HeaderRules{Set: map[string]string{"X-Env": "global"}}},
+			gateway:     config.HeadersConfig{Request: config.HeaderRules{Remove: []string{"X-Env"}}},
+			wantRequest: map[string][]string{"X-Env": nil},
+		},
+		{
+			name: "response rules",
+			gateway: config.HeadersConfig{Response: config.HeaderRules{
+				Set:    map[string]string{"Cache-Control": "no-store"},
+				Add:    map[string]string{"Vary": "Authorization"},
+				Remove: []string{"X-Powered-By"},
+			}},
+			response: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept"}, "X-Powered-By": {"php"}},
+			wantResponse: map[string][]string{
+				"Cache-Control": {"no-store"},
+				"Vary":          {"Accept", "Authorization"},
+				"X-Powered-By":  nil,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, received := newTestHeaderProxy(t, tt.global, tt.gateway, tt.response)
+
+			r := httptest.NewRequest(http.MethodGet, "/test", nil)
+			for name, values := range tt.request {
+				r.Header[name] = values
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			header := received()
+
+			for name, want := range tt.wantRequest {
+				if got := header.Values(name); !slices.Equal(got, want) {
+					t.Errorf("request header %s is %q, want %q", name, got, want)
+				}
+			}
+
+			for name, want := range tt.wantResponse {
+				if got := rec.Header().Values(name); !slices.Equal(got, want) {
+					t.Errorf("response header %s is %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderTemplates(t *testing.T) {
+	t.Setenv("ORION_REGION", "eu")
+
+	const key = "secret"
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "alice"}).SignedString([]byte(key))
+	if err != nil {
+		t.Fatalf("failed sign token: %v", err)
+	}
+
+	gateway := config.HeadersConfig{
+		Request: config.HeaderRules{Set: map[string]string{
+			"X-Client":  "{client_ip}",
+			"X-Route":   "route={route}",
+			"X-Region":  "{env.ORION_REGION}",
+			"X-User":    "{claim.sub}",
+			"X-Unknown": "{unknown}",
+		}},
+		Response: config.HeaderRules{Set: map[string]string{"X-Trace": "{request_id}"}},
+	}
+
+	handler, received := newTestHeaderProxy(t, config.HeadersConfig{}, gateway, nil)
+
+	// claims are put into request by auth middleware
+	handler = auth.NewAuthMW(&config.Config{AuthConfig: config.AuthConfig{Key: key}}, &logger.Logger{Logger: zap.NewNop()}).Middleware(handler)
+
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+	r.RemoteAddr = "192.0.2.10:4000"
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	header := received()
+
+	want := map[string]string{
+		"X-Client":  "192.0.2.10",
+		"X-Route":   "route=users",
+		"X-Region":  "eu",
+		"X-User":    "alice",
+		"X-Unknown": "{unknown}",
+	}
+
+	for name, value := range want {
+		if got := header.Get(name); got != value {
+			t.Errorf("request header %s is %q, want %q", name, got, value)
+		}
+	}
+
+	// request id is generated when client sent none and is the same in response
+	id := header.Get("X-Request-Id")
+	if id == "" {
+		t.Fatalf("request id is not generated")
+	}
+
+	if got := rec.Header().Get("X-Trace"); got != id {
+		t.Errorf("response header X-Trace is %q, want request id %q", got, id)
+	}
+
+	// request id of client is kept
+	r = httptest.NewRequest(http.MethodGet, "/test", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("X-Request-Id", "client-id")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	if got := received().Get("X-Request-Id"); got != "client-id" {
+		t.Errorf("request id %q, want id of client", got)
+	}
+
+	if got := rec.Header().Get("X-Trace"); got != "client-id" {
+		t.Errorf("response header X-Trace is %q, want id of client", got)
+	}
+}
+
+func TestHeaderForwarded(t *testing.T) {
+	tests := []struct {
+		name       string
+		forwarded  config.ForwardedConfig
+		remoteAddr string
+		// want stores expected values, empty value requires absence
+		want map[string]string
+	}{
+		{
+			name:       "overwrite",
+			forwarded:  config.ForwardedConfig{Mode: "overwrite", TrustedProxies: []string{"192.0.2.0/24"}},
+			remoteAddr: "192.0.2.1:4000",
+			want:       map[string]string{"X-Forwarded-For": "192.0.2.1", "X-Forwarded-Host": "orion.test", "X-Forwarded-Proto": "http"},
+		},
+		{
+			name:       "append from trusted proxy",
+			forwarded:  config.ForwardedConfig{Mode: "append", TrustedProxies: []string{"192.0.2.0/24"}},
+			remoteAddr: "192.0.2.1:4000",
+			want:       map[string]string{"X-Forwarded-For": "203.0.113.7, 192.0.2.1", "X-Forwarded-Host": "public.test", "X-Forwarded-Proto": "https"},
+		},
+		{
+			name:       "append from untrusted client",
+			forwarded:  config.ForwardedConfig{Mode: "append", TrustedProxies: []string{"192.0.2.0/24"}},
+			remoteAddr: "198.51.100.1:4000",
+			want:       map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Host": "orion.test", "X-Forwarded-Proto": "http"},
+		},
+		{
+			name:       "off",
+			forwarded:  config.ForwardedConfig{Mode: "off"},
+			remoteAddr: "192.0.2.1:4000",
+			want:       map[string]string{"X-Forwarded-For": "", "X-Forwarded-Host": "", "X-Forwarded-Proto": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, received := newTestHeaderProxy(t, config.HeadersConfig{}, config.HeadersConfig{Forwarded: tt.forwarded}, nil)
+
+			r := httptest.NewRequest(http.MethodGet, "http://orion.test/test", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			r.Header.Set("X-Forwarded-Host", "public.test")
+			r.Header.Set("X-Forwarded-Proto", "https")
+
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			header := received()
+
+			for name, want := range tt.want {
+				if got := header.Get(name); got != want {
+					t.Errorf("header %s is %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderHopByHop(t *testing.T) {
+	global := config.HeadersConfig{HopByHop: []string{"X-Global-Hop"}}
+	gateway := config.HeadersConfig{HopByHop: []string{"X-Gateway-Hop"}}
+
+	response := http.Header{
+		"X-Global-Hop":  {"1"},
+		"X-Gateway-Hop": {"1"},
+		"Keep-Alive":    {"timeout=5"},
+		"X-Kept":        {"1"},
+	}
+
+	handler, received := newTestHeaderProxy(t, global, gateway, response)
+
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+	r.Header.Set("Connection", "X-Listed")
+	r.Header.Set("X-Listed", "1")
+	r.Header.Set("Proxy-Authorization", "Basic b3Jpb24=")
+	r.Header.Set("X-Global-Hop", "1")
+	r.Header.Set("X-Gateway-Hop", "1")
+	r.Header.Set("X-Kept", "1")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	header := received()
+
+	// standard ones, ones listed in Connection and configured ones are removed
+	for _, name := range []string{"X-Listed", "Proxy-Authorization", "X-Global-Hop", "X-Gateway-Hop"} {
+		if value := header.Get(name); value != "" {
+			t.Errorf("hop-by-hop header %s is sent to target", name)
+		}
+	}
+
+	for _, name := range []string{"X-Global-Hop", "X-Gateway-Hop", "Keep-Alive"} {
+		if value := rec.Header().Get(name); value != "" {
+			t.Errorf("hop-by-hop header %s is sent to client", name)
+		}
+	}
+
+	if header.Get("X-Kept") == "" || rec.Header().Get("X-Kept") == "" {
+		t.Errorf("end-to-end header is removed")
+	}
+}
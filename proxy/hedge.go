@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/loadbalancer"
+	"github.com/osamikoyo/orion/metrics"
+	"github.com/osamikoyo/orion/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	// hedgeSamples is count of last latencies used for percentile
+	hedgeSamples = 512
+	// hedgeMinSamples is count of latencies required before percentile is used
+	hedgeMinSamples = 20
+	// hedgeRecalc is count of new latencies after which percentile is recalculated
+	hedgeRecalc = 32
+)
+
+// hedger stores hedge settings and observed latencies of one gateway
+type hedger struct {
+	cfg    config.HedgeConfig
+	prefix string
+	budget *retryBudget
+
+	latencies [hedgeSamples]time.Duration
+	next      int
+	count     int
+	fresh     int
+	current   time.Duration
+	mu        sync.Mutex
+}
+
+// newHedger returns nil when hedging is disabled for gateway
+func newHedger(gateway *config.Gateway) *hedger {
+	if !gateway.Hedge.Use {
+		return nil
+	}
+
+	return &hedger{
+		cfg:    gateway.Hedge,
+		prefix: gateway.Name,
+		// hedges are limited by percent only, there is no minimal rate
+		budget: newRetryBudget(config.RetryBudgetConfig{Percent: gateway.Hedge.Budget}),
+	}
+}
+
+// hedgeable returns true when request may be sent twice,
+// body is not buffered for hedges so requests with body and streams are skipped
+func (h *hedger) hedgeable(r *http.Request) bool {
+	if h == nil || restMethod(r) != http.MethodGet || stream.IsRequest(r) {
+		return false
+	}
+
+	// body of transcoded call is encoded request message, every copy sends it again
+	if state := stateOf(r); state != nil && state.call != nil {
+		return true
+	}
+
+	return r.Body == nil || r.Body == http.NoBody
+}
+
+// delay returns time after which copy of request is sent
+func (h *hedger) delay() time.Duration {
+	if h.cfg.Delay > 0 {
+		return h.cfg.Delay
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count < hedgeMinSamples {
+		return config.DefaultHedgeDelay
+	}
+
+	if h.current == 0 || h.fresh >= hedgeRecalc {
+		sorted := slices.Clone(h.latencies[:h.count])
+		slices.Sort(sorted)
+
+		h.current = sorted[(len(sorted)-1)*h.cfg.Percentile/100]
+		h.fresh = 0
+	}
+
+	return h.current
+}
+
+// observe stores latency of successful response
+func (h *hedger) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % hedgeSamples
+	h.count = min(h.count+1, hedgeSamples)
+	h.fresh++
+}
+
+// good returns true when attempt got response which is worth to return
+func (a *attempt) good() bool {
+	return a.info.Err == nil && a.info.Status < http.StatusInternalServerError
+}
+
+// hedge sends request to target and when it does not answer within hedge delay
+// sends copy to another target, the first good response wins and the loser is cancelled,
+// body is buffered body of transcoded call or nil
+func (rt *retryTransport) hedge(up *Upstream, req *http.Request, target string, done loadbalancer.DoneFunc, tried *[]string, body []byte) *attempt {
+	h := rt.hedger
+
+	type result struct {
+		index int
+		*attempt
+	}
+
+	results := make(chan result, 2)
+	var cancels []context.CancelFunc
+
+	send := func(target string, done loadbalancer.DoneFunc) {
+		ctx, cancel := context.WithCancel(req.Context())
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+
+		go func() {
+			a := rt.try(req.WithContext(ctx), target, done, body)
+
+			tryCancel := a.cancel
+			a.cancel = func() {
+				tryCancel()
+				cancel()
+			}
+
+			results <- result{index: index, attempt: a}
+		}()
+	}
+
+	send(target, done)
+	pending := 1
+
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+
+	var winner *result
+	var failed []*attempt
+
+	for winner == nil {
+		select {
+		case <-timer.C:
+			if !h.budget.allowRetry() {
+				metrics.HedgeRequests.WithLabelValues(h.prefix, "skipped").Inc()
+
+				continue
+			}
+
+			target, done, err := up.selectTarget(req, *tried)
+			if err != nil {
+				continue
+			}
+
+			// there is no other target to hedge with
+			if slices.Contains(*tried, target) {
+				done(loadbalancer.DoneInfo{})
+
+				continue
+			}
+
+			*tried = append(*tried, target)
+
+			rt.mw.logger.Info("hedging upstream request",
+				zap.String("prefix", h.prefix),
+				zap.String("target", target))
+
+			send(target, done)
+			pending++
+
+		case res := <-results:
+			pending--
+
+			if res.good() || pending == 0 {
+				winner = &res
+
+				continue
+			}
+
+			failed = append(failed, res.attempt)
+		}
+	}
+
+	if winner.good() {
+		h.observe(winner.info.Latency)
+	}
+
+	if len(cancels) > 1 {
+		result := "lost"
+		if winner.index > 0 {
+			result = "won"
+		}
+
+		metrics.HedgeRequests.WithLabelValues(h.prefix, result).Inc()
+	}
+
+	// failed attempts are reported as is, cancelled ones are not counted against targets
+	for _, a := range failed {
+		a.discard(a.info)
+	}
+
+	for i, cancel := range cancels {
+		if i != winner.index {
+			cancel()
+		}
+	}
+
+	go func() {
+		for ; pending > 0; pending-- {
+			res := <-results
+			res.discard(loadbalancer.DoneInfo{})
+		}
+	}()
+
+	return winner.attempt
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/loadbalancer"
+)
+
+func TestHedge(t *testing.T) {
+	tests := []struct {
+		name string
+		// slowFirst makes the first target answer only when its request is canceled
+		slowFirst bool
+		budget    int
+		want      string
+		// hedged is true when the second target must be called
+		hedged bool
+	}{
+		{name: "slow target loses", slowFirst: true, budget: 100, want: "second", hedged: true},
+		{name: "fast target is not hedged", slowFirst: false, budget: 100, want: "first", hedged: false},
+		{name: "budget is exhausted", slowFirst: true, budget: 0, want: "first", hedged: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canceled := make(chan struct{})
+
+			first := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.slowFirst {
+					select {
+					case <-r.Context().Done():
+						close(canceled)
+
+						return
+					case <-time.After(200 * time.Millisecond):
+					}
+				}
+
+				w.Write([]byte("first"))
+			})
+
+			called := make(chan struct{}, 1)
+			second := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+				called <- struct{}{}
+				w.Write([]byte("second"))
+			})
+
+			gateway := newTestGateway(first, second)
+			gateway.Hedge = config.HedgeConfig{Use: true, Delay: 20 * time.Millisecond, Budget: tt.budget}
+
+			handler, tu := newTestProxy(t, gateway, config.RetryBudgetConfig{})
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+				t.Fatalf("got %d %q, want 200 %q", rec.Code, rec.Body.String(), tt.want)
+			}
+
+			if hedged := len(called) > 0; hedged != tt.hedged {
+				t.Fatalf("second target called %v, want %v", hedged, tt.hedged)
+			}
+
+			if !tt.hedged {
+				return
+			}
+
+			// loser is canceled and its result is not counted against target
+			select {
+			case <-canceled:
+			case <-time.After(time.Second):
+				t.Fatalf("request of losing target was not canceled")
+			}
+
+			waitReports(t, tu, first, 1)
+
+			tu.mu.Lock()
+			info := tu.reports[first][0]
+			tu.mu.Unlock()
+
+			if info != (loadbalancer.DoneInfo{}) {
+				t.Errorf("losing target got report %+v, want empty", info)
+			}
+
+			if got := tu.statuses(second); len(got) != 1 || got[0] != http.StatusOK {
+				t.Errorf("winning target got reports %v, want [200]", got)
+			}
+		})
+	}
+}
+
+func TestHedgeFailedAttempt(t *testing.T) {
+	// the first target fails after hedge is sent, so the second one wins
+	first := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(40 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	second := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		w.Write([]byte("second"))
+	})
+
+	gateway := newTestGateway(first, second)
+	gateway.Hedge = config.HedgeConfig{Use: true, Delay: 10 * time.Millisecond, Budget: 100}
+
+	handler, tu := newTestProxy(t, gateway, config.RetryBudgetConfig{})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	// failed attempt is reported as is
+	waitReports(t, tu, first, 1)
+
+	if got := tu.statuses(first); got[0] != http.StatusInternalServerError {
+		t.Errorf("failed target got reports %v, want [500]", got)
+	}
+}
+
+func TestHedgeDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.HedgeConfig
+		samples int
+		want    time.Duration
+	}{
+		{name: "configured", cfg: config.HedgeConfig{Delay: time.Second, Percentile: 90}, samples: 100, want: time.Second},
+		{name: "too few samples", cfg: config.HedgeConfig{Percentile: 90}, samples: hedgeMinSamples - 1, want: config.DefaultHedgeDelay},
+		{name: "percentile", cfg: config.HedgeConfig{Percentile: 90}, samples: 101, want: 90 * time.Millisecond},
+		{name: "median", cfg: config.HedgeConfig{Percentile: 50}, samples: 101, want: 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHedger(&config.Gateway{Prefix: "/test", Hedge: tt.cfg})
+			if h != nil {
+				t.Fatalf("hedger created for disabled hedging")
+			}
+
+			tt.cfg.Use = true
+			h = newHedger(&config.Gateway{Prefix: "/test", Hedge: tt.cfg})
+
+			// latencies are 0ms, 1ms ... in reverse order
+			for i := tt.samples - 1; i >= 0; i-- {
+				h.observe(time.Duration(i) * time.Millisecond)
+			}
+
+			if got := h.delay(); got != tt.want {
+				t.Errorf("delay %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// waitReports waits until target gets count of reports, losers report asynchronously
+func waitReports(t *testing.T, tu *testUpstream, target string, count int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		tu.mu.Lock()
+		got := len(tu.reports[target])
+		tu.mu.Unlock()
+
+		if got >= count {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("target %s got %d reports, want %d", target, got, count)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,281 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/metrics"
+	"github.com/osamikoyo/orion/stream"
+	"go.uber.org/zap"
+)
+
+// shadowDrainLimit is size of shadow response body read to reuse connection
+const shadowDrainLimit = 64 << 10
+
+type (
+	// mirror copies sampled requests of gateway to shadow targets and compares
+	// their responses with primary ones, primary request never waits for it
+	mirror struct {
+		logger  *logger.Logger
+		gateway string
+		cfg     config.MirrorConfig
+		pool    *transportPool
+		next    atomic.Uint64
+		// inflight limits count of shadow requests in progress
+		inflight chan struct{}
+	}
+
+	// shadow is mirrored copy of request waiting for result of primary one
+	shadow struct {
+		primary chan outcome
+	}
+
+	// outcome is status and latency of response, err is set when request failed
+	outcome struct {
+		status  int
+		latency time.Duration
+		err     error
+	}
+
+	// teeBody copies request body read by primary request up to limit,
+	// done is closed when primary request has finished with body
+	teeBody struct {
+		io.ReadCloser
+		limit    int64
+		buf      bytes.Buffer
+		eof      bool
+		overflow bool
+		done     chan struct{}
+		once     sync.Once
+		mu       sync.Mutex
+	}
+)
+
+// newMirror returns nil when mirroring is disabled for gateway
+func newMirror(gateway *config.Gateway, logger *logger.Logger) (*mirror, error) {
+	if !gateway.Mirror.Use {
+		return nil, nil
+	}
+
+	pool, err := newTransportPool(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mirror{
+		logger:   logger,
+		gateway:  gateway.Name,
+		cfg:      gateway.Mirror,
+		pool:     pool,
+		inflight: make(chan struct{}, gateway.Mirror.MaxInFlight),
+	}, nil
+}
+
+// start sends copy of request to shadow target when request is sampled,
+// body is buffered request body or nil when primary request streams it.
+// Returned shadow is nil when request is not mirrored
+func (m *mirror) start(req *http.Request, body []byte) *shadow {
+	if m == nil || stream.IsRequest(req) || rand.IntN(100) >= m.cfg.Percent {
+		return nil
+	}
+
+	if req.ContentLength > m.cfg.MaxBodySize || int64(len(body)) > m.cfg.MaxBodySize {
+		metrics.MirrorRequests.WithLabelValues(m.gateway, "skipped").Inc()
+
+		return nil
+	}
+
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		metrics.MirrorRequests.WithLabelValues(m.gateway, "dropped").Inc()
+
+		return nil
+	}
+
+	// copy of body is taken while primary request sends it
+	var tee *teeBody
+	if body == nil && req.Body != nil && req.Body != http.NoBody {
+		tee = &teeBody{
+			ReadCloser: req.Body,
+			limit:      m.cfg.MaxBodySize,
+			done:       make(chan struct{}),
+		}
+		req.Body = tee
+	}
+
+	// shadow request must not be canceled with primary one
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), m.cfg.Timeout)
+	out := req.Clone(ctx)
+
+	s := &shadow{
+		primary: make(chan outcome, 1),
+	}
+
+	go func() {
+		defer func() { <-m.inflight }()
+		defer cancel()
+
+		if tee != nil {
+			select {
+			case <-tee.done:
+			case <-ctx.Done():
+			}
+
+			buffered, ok := tee.bytes()
+			if !ok {
+				metrics.MirrorRequests.WithLabelValues(m.gateway, "skipped").Inc()
+
+				return
+			}
+
+			body = buffered
+		}
+
+		result := m.send(out, body)
+		m.record(req, <-s.primary, result)
+	}()
+
+	return s
+}
+
+// send sends shadow request and discards response
+func (m *mirror) send(out *http.Request, body []byte) outcome {
+	target := m.cfg.Targets[m.next.Add(1)%uint64(len(m.cfg.Targets))]
+
+	transport, err := m.pool.get(target)
+	if err != nil {
+		return outcome{err: err}
+	}
+
+	out.URL.Host = strings.ReplaceAll(transport.endpoint.Host, "{id}", routeParam(out, "id"))
+	out.URL.Scheme = transport.endpoint.URLScheme()
+	out.Host = ""
+	out.Body = http.NoBody
+	out.ContentLength = int64(len(body))
+	if len(body) > 0 {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	started := time.Now()
+
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		return outcome{latency: time.Since(started), err: err}
+	}
+
+	result := outcome{status: resp.StatusCode, latency: time.Since(started)}
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, shadowDrainLimit))
+	resp.Body.Close()
+
+	return result
+}
+
+// record compares shadow response with primary one
+func (m *mirror) record(req *http.Request, primary, shadow outcome) {
+	metrics.MirrorStatus.WithLabelValues(m.gateway, statusClass(primary), statusClass(shadow)).Inc()
+
+	result := "match"
+	switch {
+	case shadow.err != nil:
+		result = "error"
+	case primary.err != nil || primary.status != shadow.status:
+		result = "mismatch"
+	}
+
+	metrics.MirrorRequests.WithLabelValues(m.gateway, result).Inc()
+
+	if primary.err == nil && shadow.err == nil {
+		metrics.MirrorLatencyDiff.WithLabelValues(m.gateway).Observe((shadow.latency - primary.latency).Seconds())
+	}
+
+	if result != "match" && m.cfg.LogMismatch {
+		m.logger.Warn("shadow response differs from primary",
+			zap.String("prefix", m.gateway),
+			zap.String("method", req.Method),
+			zap.String("path", req.URL.Path),
+			zap.Int("primary_status", primary.status),
+			zap.Int("shadow_status", shadow.status),
+			zap.Duration("primary_latency", primary.latency),
+			zap.Duration("shadow_latency", shadow.latency),
+			zap.NamedError("primary_error", primary.err),
+			zap.NamedError("shadow_error", shadow.err))
+	}
+}
+
+// finish passes result of primary request to shadow, shadow may be nil
+func (s *shadow) finish(resp *http.Response, err error, latency time.Duration) {
+	if s == nil {
+		return
+	}
+
+	result := outcome{latency: latency, err: err}
+	if resp != nil {
+		result.status = resp.StatusCode
+	}
+
+	s.primary <- result
+}
+
+// statusClass returns class of status like 2xx or error
+func statusClass(o outcome) string {
+	if o.err != nil {
+		return "error"
+	}
+
+	return strconv.Itoa(o.status/100) + "xx"
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+
+	t.mu.Lock()
+	if n > 0 && !t.overflow {
+		if int64(t.buf.Len()+n) > t.limit {
+			t.overflow = true
+			t.buf = bytes.Buffer{}
+		} else {
+			t.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		t.eof = true
+	}
+	t.mu.Unlock()
+
+	if err == io.EOF {
+		t.once.Do(func() { close(t.done) })
+	}
+
+	return n, err
+}
+
+func (t *teeBody) Close() error {
+	err := t.ReadCloser.Close()
+	t.once.Do(func() { close(t.done) })
+
+	return err
+}
+
+// bytes returns copied body, false is returned when body was not read completely
+func (t *teeBody) bytes() ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.eof || t.overflow {
+		return nil, false
+	}
+
+	return t.buf.Bytes(), true
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+func newTestMirrorConfig(percent int, targets ...string) config.MirrorConfig {
+	return config.MirrorConfig{
+		Use:         true,
+		Targets:     targets,
+		Percent:     percent,
+		MaxBodySize: 1 << 10,
+		Timeout:     time.Second,
+		MaxInFlight: 1000,
+	}
+}
+
+func TestMirrorPrimaryIsolation(t *testing.T) {
+	primary := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("primary"))
+	})
+
+	// shadow bodies are sent here by every mirror target
+	bodies := make(chan string, 1)
+
+	slow := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+
+		time.Sleep(500 * time.Millisecond)
+	})
+	failing := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listen: %v", err)
+	}
+	refused := listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		name   string
+		shadow string
+		// received is true when shadow target gets copy of request
+		received bool
+	}{
+		{name: "slow shadow", shadow: slow, received: true},
+		{name: "failing shadow", shadow: failing, received: true},
+		{name: "unreachable shadow", shadow: refused, received: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(primary)
+			gateway.Mirror = newTestMirrorConfig(100, tt.shadow)
+
+			handler, _ := newTestProxy(t, gateway, config.RetryBudgetConfig{})
+
+			rec := httptest.NewRecorder()
+
+			started := time.Now()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("payload")))
+			latency := time.Since(started)
+
+			if rec.Code != http.StatusCreated || rec.Body.String() != "primary" {
+				t.Errorf("got %d %q, want response of primary", rec.Code, rec.Body.String())
+			}
+
+			// primary never waits for shadow
+			if latency > 200*time.Millisecond {
+				t.Errorf("primary took %s with %s", latency, tt.name)
+			}
+
+			if !tt.received {
+				return
+			}
+
+			select {
+			case body := <-bodies:
+				if body != "payload" {
+					t.Errorf("shadow got body %q, want %q", body, "payload")
+				}
+			case <-time.After(time.Second):
+				t.Errorf("shadow got no request")
+			}
+		})
+	}
+}
+
+func TestMirrorPercent(t *testing.T) {
+	const requests = 1000
+
+	tests := []struct {
+		name    string
+		percent int
+		low     int64
+		high    int64
+	}{
+		{name: "disabled", percent: 0, low: 0, high: 0},
+		{name: "quarter", percent: 25, low: 175, high: 325},
+		{name: "all", percent: 100, low: requests, high: requests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received atomic.Int64
+
+			shadow := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+				received.Add(1)
+			})
+
+			gateway := newTestGateway()
+			gateway.Mirror = newTestMirrorConfig(tt.percent, shadow)
+
+			m, err := newMirror(gateway, &logger.Logger{Logger: zap.NewNop()})
+			if err != nil {
+				t.Fatalf("failed create mirror: %v", err)
+			}
+
+			for i := 0; i < requests; i++ {
+				s := m.start(httptest.NewRequest(http.MethodGet, "/test", nil), nil)
+				s.finish(&http.Response{StatusCode: http.StatusOK}, nil, time.Millisecond)
+			}
+
+			// shadow requests are finished when their slots are released
+			deadline := time.Now().Add(5 * time.Second)
+
+			for len(m.inflight) > 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d shadow requests are not finished", len(m.inflight))
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if got := received.Load(); got < tt.low || got > tt.high {
+				t.Errorf("mirrored %d of %d requests, want from %d to %d", got, requests, tt.low, tt.high)
+			}
+		})
+	}
+}
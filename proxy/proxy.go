@@ -1,33 +1,390 @@
 package proxy
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
-	"strings"
+	"strconv"
+	"sync"
+	"syscall"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/osamikoyo/orion/breaker"
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/loadbalancer"
 	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/metrics"
+	"github.com/osamikoyo/orion/stream"
+	"github.com/osamikoyo/orion/transcoder"
 	"go.uber.org/zap"
 )
 
-type ProxyMW struct {
-	logger *logger.Logger
-}
+type (
+	// Selector picks target for every attempt of request
+	Selector func(r *http.Request) (string, loadbalancer.DoneFunc, error)
+
+	// Upstream describes where proxy sends requests of one gateway
+	Upstream struct {
+		Gateway *config.Gateway
+		Select  Selector
+		// Done is called once with result of the last attempt, may be nil
+		Done loadbalancer.DoneFunc
+	}
+
+	ProxyMW struct {
+		logger *logger.Logger
+		budget *retryBudget
+		// headers stores global headers policy
+		headers config.HeadersConfig
+		// proxies stores reverse proxy of every gateway
+		proxies map[string]*gatewayProxy
+		// streams stores open streams of all gateways
+		streams *streamTracker
+		mu      sync.RWMutex
+	}
+
+	gatewayProxy struct {
+		proxy *httputil.ReverseProxy
+		// transcoder is set when gateway has descriptors of grpc services
+		transcoder *transcoder.Transcoder
+		// streams limits open streams of gateway, it is nil without limit
+		streams chan struct{}
+	}
+
+	// requestState is passed by Middleware to shared reverse proxy in context
+	requestState struct {
+		up *Upstream
+		// in is request which came to proxy
+		in *http.Request
+		// call is set when REST call is transcoded to grpc one
+		call *transcoder.Call
+	}
+
+	requestStateKey struct{}
+)
+
+// errDraining is returned for streams opened while proxy is draining
+var errDraining = errors.New("proxy is draining")
+
+func NewProxyMW(cfg *config.Config, logger *logger.Logger) (*ProxyMW, error) {
+	mw := &ProxyMW{
+		logger:  logger,
+		budget:  newRetryBudget(cfg.RetryBudget),
+		headers: cfg.Headers,
+		proxies: make(map[string]*gatewayProxy, len(cfg.Gateways)),
+		streams: newStreamTracker(),
+	}
+
+	for i := range cfg.Gateways {
+		// aggregation gateway answers itself and never proxies requests
+		if cfg.Gateways[i].IsAggregate() {
+			continue
+		}
+
+		gp, err := mw.newReverseProxy(&cfg.Gateways[i])
+		if err != nil {
+			logger.Error("failed create reverse proxy",
+				zap.String("prefix", cfg.Gateways[i].Name),
+				zap.Error(err))
+
+			return nil, err
+		}
 
-func NewProxyMW(logger *logger.Logger) *ProxyMW {
-	return &ProxyMW{
-		logger: logger,
+		mw.proxies[cfg.Gateways[i].Name] = gp
 	}
+
+	return mw, nil
 }
 
-func (mw *ProxyMW) Middleware(target string) http.HandlerFunc {
+// Middleware proxies request to targets picked by upstream selector,
+// retries failed attempts by gateway retry policy and reports result
+// of every attempt to its DoneFunc when response is finished,
+// slow GET requests are hedged when it is enabled for gateway.
+// Websocket and event stream requests are limited by stream config of gateway
+func (mw *ProxyMW) Middleware(up Upstream) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		targetURL := strings.ReplaceAll(target, "{id}", chi.URLParam(r, "id"))
+		mw.budget.request()
+
+		gp, err := mw.reverseProxy(up.Gateway)
+		if err != nil {
+			mw.logger.Error("failed create reverse proxy",
+				zap.String("prefix", up.Gateway.Name),
+				zap.Error(err))
+
+			http.Error(w, "failed proxy request", http.StatusBadGateway)
+
+			return
+		}
+
+		if stream.IsRequest(r) {
+			release, ok := gp.acquireStream(mw.streams)
+			if !ok {
+				metrics.ErrorRequestTotal.WithLabelValues(r.URL.Path, "stream_limit").Inc()
+
+				http.Error(w, "too many streams", http.StatusServiceUnavailable)
+
+				return
+			}
+			defer release()
+		}
+
+		// reverse proxy is shared by requests of gateway,
+		// so selector of request is passed to transport in context
+		state := &requestState{up: &up}
+		r = r.WithContext(context.WithValue(r.Context(), requestStateKey{}, state))
+
+		// REST calls are sent to targets as grpc calls
+		if gp.transcoder != nil && !IsGRPC(r) {
+			out, call, err := gp.transcoder.Transcode(r)
+			if err != nil {
+				gp.transcoder.WriteError(w, err)
+
+				return
+			}
+
+			r, state.call = out, call
+		}
+
+		state.in = r
+
+		gp.proxy.ServeHTTP(w, r)
+	}
+}
+
+// Drain stops accepting streams and waits until open ones are closed,
+// streams which are still open when ctx is done are closed
+func (mw *ProxyMW) Drain(ctx context.Context) error {
+	return mw.streams.drain(ctx)
+}
+
+// acquireStream takes slot of stream, false is returned when limit
+// of gateway is reached or proxy is draining
+func (gp *gatewayProxy) acquireStream(tracker *streamTracker) (func(), bool) {
+	if tracker.isDraining() {
+		return nil, false
+	}
+
+	if gp.streams == nil {
+		return func() {}, true
+	}
+
+	select {
+	case gp.streams <- struct{}{}:
+		return func() { <-gp.streams }, true
+	default:
+		return nil, false
+	}
+}
+
+// reverseProxy returns reverse proxy of gateway, it is created
+// when gateway was added after proxy middleware
+func (mw *ProxyMW) reverseProxy(gateway *config.Gateway) (*gatewayProxy, error) {
+	mw.mu.RLock()
+	gp, ok := mw.proxies[gateway.Name]
+	mw.mu.RUnlock()
+
+	if ok {
+		return gp, nil
+	}
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	if gp, ok := mw.proxies[gateway.Name]; ok {
+		return gp, nil
+	}
+
+	gp, err := mw.newReverseProxy(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	mw.proxies[gateway.Name] = gp
+
+	return gp, nil
+}
 
-		mw.logger.Info("new api request", zap.String("target", target))
+// newReverseProxy creates reverse proxy which is reused by all requests of gateway,
+// connections to every target are pooled by its own transport
+func (mw *ProxyMW) newReverseProxy(gateway *config.Gateway) (*gatewayProxy, error) {
+	pool, err := newTransportPool(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	mirror, err := newMirror(gateway, mw.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &retryTransport{
+		mw:      mw,
+		gateway: gateway,
+		policy:  newRetryPolicy(gateway.Retry),
+		hedger:  newHedger(gateway),
+		mirror:  mirror,
+		pool:    pool,
+	}
+
+	rewriter, err := newPathRewriter(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := newHeaderPolicy(mw.headers, gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	gp := &gatewayProxy{}
+
+	if gateway.GRPC.Descriptor != "" {
+		gp.transcoder, err = transcoder.New(gateway.GRPC.Descriptor, gateway.GRPC.MaxBodySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed load grpc descriptors of gateway %s: %v", gateway.Name, err)
+		}
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// scheme and host are set by transport for every attempt
+			headers.rewriteRequest(pr)
+
+			// path of transcoded call is name of grpc method
+			if rewriter != nil && stateOf(pr.In).call == nil {
+				setEscapedPath(pr.Out.URL, rewriter.rewrite(pr.In))
+			}
+
+			if gateway.GRPC.Web {
+				rewriteGRPCWeb(pr)
+			}
+		},
+		Transport:     transport,
+		FlushInterval: gateway.Stream.FlushInterval,
+		ModifyResponse: func(resp *http.Response) error {
+			if state := stateOf(resp.Request); state != nil {
+				headers.modifyResponse(resp, state.in)
+
+				if gateway.GRPC.Use && IsGRPC(state.in) {
+					mw.grpcResponse(resp, gateway.Name, state.in)
+				}
+
+				if state.call != nil {
+					return transcodeResponse(resp, state.call, gateway.GRPC.MaxBodySize)
+				}
+			}
+
+			if !stream.IsResponse(resp.StatusCode, resp.Header) {
+				return nil
+			}
+
+			if !mw.streams.track(resp, gateway.Name, gateway.Stream) {
+				return errDraining
+			}
+
+			// stream is limited by idle timeout and lifetime instead of request deadline
+			liftDeadline(resp.Request.Context())
+
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			status, reason := classifyError(r, err)
+
+			metrics.ErrorRequestTotal.WithLabelValues(r.URL.Path, reason).Inc()
+
+			// open breaker is expected state of target, so it is not logged
+			var openErr *breaker.OpenError
+			if errors.As(err, &openErr) {
+				w.Header().Set("Retry-After", strconv.Itoa(breaker.RetryAfter(openErr.Wait)))
+			} else {
+				mw.logger.Error("upstream request failed",
+					zap.String("prefix", gateway.Name),
+					zap.String("reason", reason),
+					zap.Error(err))
+			}
+
+			mw.writeError(w, r, gateway, status, reason)
+		},
+	}
+
+	gp.proxy = proxy
 
-		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: targetURL})
-		proxy.ServeHTTP(w, r)
+	if gateway.Stream.MaxConcurrent > 0 {
+		gp.streams = make(chan struct{}, gateway.Stream.MaxConcurrent)
 	}
+
+	return gp, nil
+}
+
+// writeError writes status of failed request, grpc clients get it as status of call
+func (mw *ProxyMW) writeError(w http.ResponseWriter, r *http.Request, gateway *config.Gateway, status int, reason string) {
+	if state := stateOf(r); state != nil && state.call != nil {
+		status, body := state.call.Error(grpcErrorCode(status, reason), reason, "")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(body)
+
+		return
+	}
+
+	if gateway.GRPC.Use && IsGRPC(r) {
+		mw.writeGRPCError(w, r, gateway.Name, grpcErrorCode(status, reason), reason)
+
+		return
+	}
+
+	w.WriteHeader(status)
+}
+
+// stateOf returns state of request passed by Middleware
+func stateOf(req *http.Request) *requestState {
+	state, _ := req.Context().Value(requestStateKey{}).(*requestState)
+
+	return state
+}
+
+// restMethod returns method which decides whether request is idempotent,
+// transcoded REST call is sent as grpc POST but keeps method of its rule
+func restMethod(r *http.Request) string {
+	if state := stateOf(r); state != nil && state.call != nil {
+		return state.call.Method()
+	}
+
+	return r.Method
+}
+
+// classifyError returns response status and metric reason for failed upstream request
+func classifyError(r *http.Request, err error) (int, string) {
+	var openErr *breaker.OpenError
+
+	switch {
+	case errors.As(err, &openErr):
+		return http.StatusServiceUnavailable, "circuit_open"
+	case errors.Is(err, errDraining):
+		return http.StatusServiceUnavailable, "draining"
+	case errors.Is(r.Context().Err(), context.Canceled):
+		return http.StatusBadGateway, "client_closed"
+	case isTimeout(err):
+		return http.StatusGatewayTimeout, "gateway_timeout"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return http.StatusBadGateway, "upstream_closed"
+	default:
+		return http.StatusBadGateway, "upstream_error"
+	}
+}
+
+// isTimeout returns true for exceeded deadline and network timeouts
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/breaker"
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/loadbalancer"
+	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/metrics"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+func newBenchUpstream(b *testing.B) string {
+	b.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":1,"name":"orion"}`))
+	}))
+	b.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// benchBurst is count of concurrent requests in one operation,
+// it is more than idle connections kept by default transport
+const benchBurst = 16
+
+func runBenchProxy(b *testing.B, handler http.Handler) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for b.Loop() {
+		var wg sync.WaitGroup
+
+		for range benchBurst {
+			wg.Go(func() {
+				req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+				rec := httptest.NewRecorder()
+
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != http.StatusOK {
+					b.Errorf("unexpected status %d", rec.Code)
+				}
+			})
+		}
+
+		wg.Wait()
+	}
+}
+
+// BenchmarkPerRequestProxy builds reverse proxy for every request
+// with default transport as proxy middleware did before
+func BenchmarkPerRequestProxy(b *testing.B) {
+	target := newBenchUpstream(b)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: target})
+		proxy.ServeHTTP(w, r)
+	})
+
+	runBenchProxy(b, handler)
+}
+
+// BenchmarkSharedProxy reuses reverse proxy of gateway and pooled transport of target
+func BenchmarkSharedProxy(b *testing.B) {
+	target := newBenchUpstream(b)
+
+	cfg := &config.Config{
+		Gateways: []config.Gateway{{
+			Prefix:  "/users",
+			Targets: []config.Target{{Url: target, Weight: 1}},
+			Timeout: config.TimeoutConfig{
+				Connect: config.DefaultConnectTimeout,
+				Idle:    config.DefaultIdleConnTimeout,
+			},
+			Transport: config.TransportConfig{
+				MaxIdleConnsPerHost: config.DefaultMaxIdleConns,
+				KeepAlive:           config.DefaultKeepAlive,
+			},
+		}},
+	}
+
+	mw, err := NewProxyMW(cfg, &logger.Logger{Logger: zap.NewNop()})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	handler := mw.Middleware(Upstream{
+		Gateway: &cfg.Gateways[0],
+		Select: func(r *http.Request) (string, loadbalancer.DoneFunc, error) {
+			return target, func(loadbalancer.DoneInfo) {}, nil
+		},
+	})
+
+	runBenchProxy(b, handler)
+}
+
+// errorCount returns failed requests of path counted with reason
+func errorCount(t *testing.T, path, reason string) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := metrics.ErrorRequestTotal.WithLabelValues(path, reason).Write(&m); err != nil {
+		t.Fatalf("failed read metric: %v", err)
+	}
+
+	return m.GetCounter().GetValue()
+}
+
+// newFullListener returns address of listener with full accept queue,
+// so new connections to it hang until dial timeout
+func newFullListener(t *testing.T) string {
+	t.Helper()
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("failed create socket: %v", err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatalf("failed bind socket: %v", err)
+	}
+
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatalf("failed listen: %v", err)
+	}
+
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatalf("failed get address: %v", err)
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	// the only slot of queue is taken by connection which is never accepted
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed fill accept queue: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return addr
+}
+
+func TestClassifyError(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		err        error
+		wantStatus int
+		wantReason string
+	}{
+		{name: "breaker open", err: fmt.Errorf("failed: %w", &breaker.OpenError{Wait: time.Second}), wantStatus: http.StatusServiceUnavailable, wantReason: "circuit_open"},
+		{name: "draining", err: errDraining, wantStatus: http.StatusServiceUnavailable, wantReason: "draining"},
+		{name: "client closed", ctx: canceled, err: context.Canceled, wantStatus: http.StatusBadGateway, wantReason: "client_closed"},
+		{name: "deadline", err: context.DeadlineExceeded, wantStatus: http.StatusGatewayTimeout, wantReason: "gateway_timeout"},
+		{name: "network timeout", err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, wantStatus: http.StatusGatewayTimeout, wantReason: "gateway_timeout"},
+		{name: "eof", err: io.EOF, wantStatus: http.StatusBadGateway, wantReason: "upstream_closed"},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, wantStatus: http.StatusBadGateway, wantReason: "upstream_closed"},
+		{name: "reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, wantStatus: http.StatusBadGateway, wantReason: "upstream_closed"},
+		{name: "broken pipe", err: &net.OpError{Op: "write", Err: syscall.EPIPE}, wantStatus: http.StatusBadGateway, wantReason: "upstream_closed"},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, wantStatus: http.StatusBadGateway, wantReason: "upstream_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.ctx != nil {
+				r = r.WithContext(tt.ctx)
+			}
+
+			status, reason := classifyError(r, tt.err)
+			if status != tt.wantStatus || reason != tt.wantReason {
+				t.Errorf("got %d %s, want %d %s", status, reason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+// headerCounter counts status writes of handler
+type headerCounter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (hc *headerCounter) WriteHeader(status int) {
+	hc.writes++
+	hc.ResponseRecorder.WriteHeader(status)
+}
+
+func TestBreakerOpenError(t *testing.T) {
+	gateway := newTestGateway()
+
+	mw, err := NewProxyMW(&config.Config{Gateways: []config.Gateway{*gateway}}, &logger.Logger{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("failed create proxy: %v", err)
+	}
+
+	// every target of gateway is rejected by its breaker
+	handler := mw.Middleware(Upstream{
+		Gateway: gateway,
+		Select: func(r *http.Request) (string, loadbalancer.DoneFunc, error) {
+			return "", nil, &breaker.OpenError{Wait: 1500 * time.Millisecond}
+		},
+	})
+
+	rec := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	if rec.writes != 1 {
+		t.Errorf("status is written %d times, want once", rec.writes)
+	}
+
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("retry-after %q, want %q", got, "2")
+	}
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	slow := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	// target reads request and closes connection without response
+	closing := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed hijack: %v", err)
+			return
+		}
+
+		conn.Close()
+	})
+
+	tests := []struct {
+		name    string
+		target  string
+		timeout config.TimeoutConfig
+		// deadline is set on request like handler does with request timeout
+		deadline   time.Duration
+		wantStatus int
+		wantReason string
+	}{
+		{name: "request deadline", target: slow, deadline: 50 * time.Millisecond, wantStatus: http.StatusGatewayTimeout, wantReason: "gateway_timeout"},
+		{name: "response header timeout", target: slow, timeout: config.TimeoutConfig{ResponseHeader: 50 * time.Millisecond}, wantStatus: http.StatusGatewayTimeout, wantReason: "gateway_timeout"},
+		{name: "connect timeout", target: newFullListener(t), timeout: config.TimeoutConfig{Connect: 50 * time.Millisecond}, wantStatus: http.StatusGatewayTimeout, wantReason: "gateway_timeout"},
+		{name: "closed by upstream", target: closing, wantStatus: http.StatusBadGateway, wantReason: "upstream_closed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(tt.target)
+			gateway.Timeout = tt.timeout
+
+			handler, _ := newTestProxy(t, gateway, config.RetryBudgetConfig{})
+
+			// every case counts its errors by own path
+			path := "/test/" + strings.ReplaceAll(tt.name, " ", "-")
+			before := errorCount(t, path, tt.wantReason)
+
+			ctx := context.Background()
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+
+			rec := httptest.NewRecorder()
+
+			started := time.Now()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if got := errorCount(t, path, tt.wantReason) - before; got != 1 {
+				t.Errorf("counted %v errors with reason %s, want 1", got, tt.wantReason)
+			}
+
+			// timeouts of gateway are shorter than slow target
+			if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+				t.Errorf("request took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		idle time.Duration
+		// wantConns is count of connections opened by two requests with pause between them
+		wantConns int
+	}{
+		{name: "connection is reused", idle: time.Minute, wantConns: 1},
+		{name: "idle connection is closed", idle: 20 * time.Millisecond, wantConns: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conns atomic.Int64
+
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			}))
+			server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			server.Start()
+			t.Cleanup(server.Close)
+
+			gateway := newTestGateway(strings.TrimPrefix(server.URL, "http://"))
+			gateway.Timeout = config.TimeoutConfig{Idle: tt.idle}
+			gateway.Transport = config.TransportConfig{MaxIdleConnsPerHost: 1}
+
+			handler, _ := newTestProxy(t, gateway, config.RetryBudgetConfig{})
+
+			for i := 0; i < 2; i++ {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status %d, want %d", rec.Code, http.StatusOK)
+				}
+
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			if got := conns.Load(); got != int64(tt.wantConns) {
+				t.Errorf("target got %d connections, want %d", got, tt.wantConns)
+			}
+		})
+	}
+}
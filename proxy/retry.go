@@ -0,0 +1,410 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/loadbalancer"
+	"github.com/osamikoyo/orion/stream"
+	"go.uber.org/zap"
+)
+
+// reselectAttempts is count of tries to get target different from failed ones
+const reselectAttempts = 3
+
+type (
+	retryPolicy struct {
+		cfg    config.RetryConfig
+		on     map[string]bool
+		status map[int]bool
+	}
+
+	// retryTransport sends every attempt of request to its own target,
+	// one transport serves all requests of gateway
+	retryTransport struct {
+		mw      *ProxyMW
+		gateway *config.Gateway
+		policy  *retryPolicy
+		hedger  *hedger
+		mirror  *mirror
+		pool    *transportPool
+	}
+
+	// attempt is result of request sent to one target
+	attempt struct {
+		target string
+		done   loadbalancer.DoneFunc
+		resp   *http.Response
+		info   loadbalancer.DoneInfo
+		cancel context.CancelFunc
+	}
+
+	// doneBody calls onClose when response body is closed by proxy
+	doneBody struct {
+		io.ReadCloser
+		once    sync.Once
+		onClose func()
+	}
+)
+
+func newRetryPolicy(cfg config.RetryConfig) *retryPolicy {
+	policy := &retryPolicy{
+		cfg:    cfg,
+		on:     make(map[string]bool),
+		status: make(map[int]bool),
+	}
+
+	for _, condition := range cfg.On {
+		if status, err := strconv.Atoi(condition); err == nil {
+			policy.status[status] = true
+
+			continue
+		}
+
+		policy.on[condition] = true
+	}
+
+	return policy
+}
+
+// enabled returns true when request may be retried at all
+func (rp *retryPolicy) enabled(r *http.Request) bool {
+	if rp.cfg.Attempts <= 1 {
+		return false
+	}
+
+	switch restMethod(r) {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return rp.cfg.NonIdempotent
+	}
+}
+
+// retryable returns true when result of attempt matches retry conditions
+func (rp *retryPolicy) retryable(parent context.Context, resp *http.Response, err error) bool {
+	// client is gone, there is nobody to retry for
+	if parent.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		if isTimeout(err) {
+			return rp.on["timeout"]
+		}
+
+		// request may be already processed by target when connection
+		// breaks after it was sent, so only failed dial is retried
+		return isConnectError(err) && rp.on["connect-error"]
+	}
+
+	return rp.status[resp.StatusCode]
+}
+
+// isConnectError returns true when connection to target was not established
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// backoff returns exponential delay with full jitter before retry
+func (rp *retryPolicy) backoff(n int) time.Duration {
+	delay := rp.cfg.BackoffBase << n
+	if delay <= 0 || delay > rp.cfg.BackoffMax {
+		delay = rp.cfg.BackoffMax
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return rand.N(delay)
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	state := stateOf(req)
+	if state == nil {
+		return nil, errors.New("upstream of request is not set")
+	}
+
+	up := state.up
+
+	hedge := rt.hedger.hedgeable(req)
+	if hedge {
+		rt.hedger.budget.request()
+	}
+
+	retries := rt.policy.enabled(req)
+
+	var body []byte
+	switch {
+	case state.call != nil && (retries || hedge):
+		// transcoded call is encoded in memory, so it is replayed whatever its size
+		buffered, err := io.ReadAll(req.Body)
+		req.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		body = buffered
+	case retries && req.Body != nil && req.Body != http.NoBody:
+		buffered, replayable, err := bufferBody(req, rt.policy.cfg.MaxBodySize)
+		if err != nil {
+			return nil, err
+		}
+
+		body = buffered
+		retries = replayable
+	}
+
+	attempts := 1
+	if retries {
+		attempts = rt.policy.cfg.Attempts
+	}
+
+	if shadow := rt.mirror.start(req, body); shadow != nil {
+		started := time.Now()
+		defer func() {
+			shadow.finish(resp, err, time.Since(started))
+		}()
+	}
+
+	var tried []string
+
+	for n := 0; ; n++ {
+		target, done, err := up.selectTarget(req, tried)
+		if err != nil {
+			up.finish(loadbalancer.DoneInfo{})
+
+			return nil, err
+		}
+
+		tried = append(tried, target)
+
+		var a *attempt
+		if hedge {
+			a = rt.hedge(up, req, target, done, &tried, body)
+		} else {
+			a = rt.try(req, target, done, body)
+		}
+
+		last := n+1 >= attempts ||
+			!rt.policy.retryable(req.Context(), a.resp, a.info.Err) ||
+			!rt.mw.budget.allowRetry()
+
+		if last {
+			if a.info.Err != nil {
+				info := settled(req, a.info)
+
+				a.cancel()
+				a.done(info)
+				up.finish(info)
+
+				return nil, a.info.Err
+			}
+
+			// target is released when body is copied to client
+			a.resp.Body = keepWriter(&doneBody{
+				ReadCloser: a.resp.Body,
+				onClose: func() {
+					a.cancel()
+					a.done(a.info)
+					up.finish(a.info)
+				},
+			}, a.resp.Body)
+
+			return a.resp, nil
+		}
+
+		a.discard(a.info)
+
+		rt.mw.logger.Warn("retrying upstream request",
+			zap.String("prefix", rt.gateway.Name),
+			zap.String("target", a.target),
+			zap.Int("attempt", n+1),
+			zap.Int("status", a.info.Status),
+			zap.Error(a.info.Err))
+
+		select {
+		case <-req.Context().Done():
+			up.finish(settled(req, loadbalancer.DoneInfo{Err: req.Context().Err()}))
+
+			return nil, req.Context().Err()
+		case <-time.After(rt.policy.backoff(n)):
+		}
+	}
+}
+
+// try sends one attempt of request to target
+func (rt *retryTransport) try(req *http.Request, target string, done loadbalancer.DoneFunc, body []byte) *attempt {
+	transport, err := rt.pool.get(target)
+	if err != nil {
+		return &attempt{
+			target: target,
+			done:   done,
+			cancel: func() {},
+			info:   loadbalancer.DoneInfo{Status: http.StatusBadGateway, Err: err},
+		}
+	}
+
+	// per try timeout of stream is lifted when response turns out to be stream
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if timeout := rt.policy.cfg.PerTryTimeout; timeout > 0 {
+		if stream.IsRequest(req) {
+			ctx, cancel = WithStreamDeadline(ctx, timeout)
+		} else {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+	}
+
+	// headers are only read by transport, so shallow copy is enough
+	out := req.WithContext(ctx)
+	url := *req.URL
+	url.Host = strings.ReplaceAll(transport.endpoint.Host, "{id}", routeParam(req, "id"))
+	url.Scheme = transport.endpoint.URLScheme()
+	out.URL = &url
+	out.Host = ""
+
+	// target of grpc call gets the rest of its deadline
+	if deadline, ok := ctx.Deadline(); ok && rt.gateway.GRPC.Use && IsGRPC(req) {
+		out.Header = req.Header.Clone()
+		out.Header.Set("Grpc-Timeout", encodeGRPCTimeout(time.Until(deadline)))
+	}
+
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.ContentLength = int64(len(body))
+	}
+
+	rt.mw.logger.Info("new api request", zap.String("target", target))
+
+	started := time.Now()
+	resp, err := transport.RoundTrip(out)
+
+	a := &attempt{
+		target: target,
+		done:   done,
+		resp:   resp,
+		cancel: cancel,
+		info: loadbalancer.DoneInfo{
+			Latency: time.Since(started),
+		},
+	}
+
+	if err != nil {
+		a.info.Status = http.StatusBadGateway
+		a.info.Err = err
+	} else {
+		a.info.Status = resp.StatusCode
+	}
+
+	return a
+}
+
+// settled returns info reported for failed request, request abandoned by client
+// is reported as skipped, so it is not counted against target and gateway
+func settled(req *http.Request, info loadbalancer.DoneInfo) loadbalancer.DoneInfo {
+	if errors.Is(req.Context().Err(), context.Canceled) {
+		return loadbalancer.DoneInfo{}
+	}
+
+	return info
+}
+
+// discard releases attempt which result is not used and reports info to its target
+func (a *attempt) discard(info loadbalancer.DoneInfo) {
+	if a.resp != nil {
+		io.Copy(io.Discard, io.LimitReader(a.resp.Body, 4<<10))
+		a.resp.Body.Close()
+	}
+
+	a.cancel()
+	a.done(info)
+}
+
+// selectTarget tries to get target which was not tried yet
+func (up *Upstream) selectTarget(req *http.Request, tried []string) (string, loadbalancer.DoneFunc, error) {
+	for i := 0; ; i++ {
+		target, done, err := up.Select(req)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if i+1 >= reselectAttempts || !slices.Contains(tried, target) {
+			return target, done, nil
+		}
+
+		done(loadbalancer.DoneInfo{})
+	}
+}
+
+func (up *Upstream) finish(info loadbalancer.DoneInfo) {
+	if up.Done != nil {
+		up.Done(info)
+	}
+}
+
+func (b *doneBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.onClose)
+
+	return err
+}
+
+// keepWriter returns wrapper which is also writer when body is writer,
+// body of upgraded connection must stay writable for reverse proxy
+func keepWriter(wrapper io.ReadCloser, body io.ReadCloser) io.ReadCloser {
+	writer, ok := body.(io.Writer)
+	if !ok {
+		return wrapper
+	}
+
+	return struct {
+		io.ReadCloser
+		io.Writer
+	}{wrapper, writer}
+}
+
+// bufferBody reads request body up to limit, when body is bigger it is
+// restored for the single attempt and false is returned
+func bufferBody(req *http.Request, limit int64) ([]byte, bool, error) {
+	if req.ContentLength > limit {
+		return nil, false, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(buf)) > limit {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+
+		return nil, false, nil
+	}
+
+	req.Body.Close()
+
+	return buf, true, nil
+}
@@ -0,0 +1,400 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/loadbalancer"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+// testUpstream sends attempts to targets in turn and stores reported results
+type testUpstream struct {
+	targets []string
+	next    int
+	// reports stores result reported to every target
+	reports map[string][]loadbalancer.DoneInfo
+	mu      sync.Mutex
+}
+
+func newTestTarget(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func newTestGateway(targets ...string) *config.Gateway {
+	gateway := &config.Gateway{Prefix: "/test"}
+
+	for _, target := range targets {
+		gateway.Targets = append(gateway.Targets, config.Target{Url: target, Weight: 1})
+	}
+
+	return gateway
+}
+
+// newTestProxy returns handler which proxies requests of gateway to its targets in turn
+func newTestProxy(t *testing.T, gateway *config.Gateway, budget config.RetryBudgetConfig) (http.Handler, *testUpstream) {
+	t.Helper()
+
+	cfg := &config.Config{RetryBudget: budget, Gateways: []config.Gateway{*gateway}}
+
+	mw, err := NewProxyMW(cfg, &logger.Logger{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("failed create proxy: %v", err)
+	}
+
+	tu := newTestUpstream(gateway)
+
+	return mw.Middleware(Upstream{Gateway: gateway, Select: tu.selectTarget}), tu
+}
+
+func newTestUpstream(gateway *config.Gateway) *testUpstream {
+	tu := &testUpstream{reports: make(map[string][]loadbalancer.DoneInfo)}
+	for _, target := range gateway.Targets {
+		tu.targets = append(tu.targets, target.Url)
+	}
+
+	return tu
+}
+
+func (tu *testUpstream) selectTarget(r *http.Request) (string, loadbalancer.DoneFunc, error) {
+	tu.mu.Lock()
+	defer tu.mu.Unlock()
+
+	target := tu.targets[tu.next%len(tu.targets)]
+	tu.next++
+
+	return target, func(info loadbalancer.DoneInfo) {
+		tu.mu.Lock()
+		defer tu.mu.Unlock()
+
+		tu.reports[target] = append(tu.reports[target], info)
+	}, nil
+}
+
+// statuses returns statuses reported to target
+func (tu *testUpstream) statuses(target string) []int {
+	tu.mu.Lock()
+	defer tu.mu.Unlock()
+
+	var statuses []int
+	for _, info := range tu.reports[target] {
+		statuses = append(statuses, info.Status)
+	}
+
+	return statuses
+}
+
+func TestRetryPolicyEnabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.RetryConfig
+		method string
+		want   bool
+	}{
+		{name: "disabled", cfg: config.RetryConfig{Attempts: 1}, method: http.MethodGet, want: false},
+		{name: "get", cfg: config.RetryConfig{Attempts: 3}, method: http.MethodGet, want: true},
+		{name: "put", cfg: config.RetryConfig{Attempts: 3}, method: http.MethodPut, want: true},
+		{name: "post", cfg: config.RetryConfig{Attempts: 3}, method: http.MethodPost, want: false},
+		{name: "non idempotent post", cfg: config.RetryConfig{Attempts: 3, NonIdempotent: true}, method: http.MethodPost, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newRetryPolicy(tt.cfg)
+
+			if got := rp.enabled(httptest.NewRequest(tt.method, "/test", nil)); got != tt.want {
+				t.Errorf("enabled %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		on     []string
+		parent context.Context
+		status int
+		err    error
+		want   bool
+	}{
+		{name: "status", on: []string{"503"}, status: 503, want: true},
+		{name: "other status", on: []string{"503"}, status: 500, want: false},
+		{name: "connect error", on: []string{"connect-error"}, err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, want: true},
+		{name: "refused", on: []string{"connect-error"}, err: fmt.Errorf("failed send request: %w", syscall.ECONNREFUSED), want: true},
+		{name: "connect error not retried", on: []string{"timeout"}, err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, want: false},
+		// request may be already processed by target
+		{name: "reset after write", on: []string{"connect-error"}, err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, want: false},
+		{name: "closed after write", on: []string{"connect-error"}, err: io.EOF, want: false},
+		{name: "unknown error", on: []string{"connect-error"}, err: errors.New("failed"), want: false},
+		{name: "timeout", on: []string{"timeout"}, err: context.DeadlineExceeded, want: true},
+		{name: "timeout not retried", on: []string{"connect-error"}, err: context.DeadlineExceeded, want: false},
+		{name: "client left", on: []string{"503"}, parent: canceled, status: 503, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newRetryPolicy(config.RetryConfig{Attempts: 3, On: tt.on})
+
+			parent := tt.parent
+			if parent == nil {
+				parent = context.Background()
+			}
+
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status}
+			}
+
+			if got := rp.retryable(parent, resp, tt.err); got != tt.want {
+				t.Errorf("retryable %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	rp := newRetryPolicy(config.RetryConfig{
+		BackoffBase: 10 * time.Millisecond,
+		BackoffMax:  50 * time.Millisecond,
+	})
+
+	tests := []struct {
+		n   int
+		max time.Duration
+	}{
+		{n: 0, max: 10 * time.Millisecond},
+		{n: 1, max: 20 * time.Millisecond},
+		{n: 2, max: 40 * time.Millisecond},
+		{n: 3, max: 50 * time.Millisecond},
+		{n: 70, max: 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if delay := rp.backoff(tt.n); delay < 0 || delay >= tt.max {
+				t.Fatalf("backoff of retry %d is %s, want in [0, %s)", tt.n, delay, tt.max)
+			}
+		}
+	}
+
+	if delay := newRetryPolicy(config.RetryConfig{}).backoff(1); delay != 0 {
+		t.Errorf("backoff without base is %s, want 0", delay)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.RetryBudgetConfig
+		requests int
+		want     int
+	}{
+		{name: "percent", cfg: config.RetryBudgetConfig{Percent: 20}, requests: 100, want: 20},
+		{name: "min per second", cfg: config.RetryBudgetConfig{Percent: 20, MinPerSecond: 1}, requests: 10, want: budgetBuckets},
+		{name: "percent over min", cfg: config.RetryBudgetConfig{Percent: 50, MinPerSecond: 1}, requests: 100, want: 50},
+		{name: "no requests", cfg: config.RetryBudgetConfig{Percent: 20}, requests: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb := newRetryBudget(tt.cfg)
+
+			for i := 0; i < tt.requests; i++ {
+				rb.request()
+			}
+
+			allowed := 0
+			for i := 0; i < tt.requests+100; i++ {
+				if rb.allowRetry() {
+					allowed++
+				}
+			}
+
+			if allowed != tt.want {
+				t.Errorf("allowed %d retries, want %d", allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAttempts(t *testing.T) {
+	failing := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	healthy := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		name    string
+		retry   config.RetryConfig
+		budget  config.RetryBudgetConfig
+		method  string
+		want    int
+		healthy []int
+	}{
+		{
+			name:    "retried on next target",
+			retry:   config.RetryConfig{Attempts: 2, On: []string{"503"}},
+			budget:  config.RetryBudgetConfig{Percent: 100},
+			method:  http.MethodGet,
+			want:    http.StatusOK,
+			healthy: []int{http.StatusOK},
+		},
+		{
+			name:   "status is not retried",
+			retry:  config.RetryConfig{Attempts: 2, On: []string{"502"}},
+			budget: config.RetryBudgetConfig{Percent: 100},
+			method: http.MethodGet,
+			want:   http.StatusServiceUnavailable,
+		},
+		{
+			name:   "post is not retried",
+			retry:  config.RetryConfig{Attempts: 2, On: []string{"503"}},
+			budget: config.RetryBudgetConfig{Percent: 100},
+			method: http.MethodPost,
+			want:   http.StatusServiceUnavailable,
+		},
+		{
+			name:   "budget is exhausted",
+			retry:  config.RetryConfig{Attempts: 2, On: []string{"503"}},
+			budget: config.RetryBudgetConfig{},
+			method: http.MethodGet,
+			want:   http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(failing, healthy)
+			gateway.Retry = tt.retry
+
+			handler, tu := newTestProxy(t, gateway, tt.budget)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/test", nil))
+
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d", rec.Code, tt.want)
+			}
+
+			if got := tu.statuses(failing); len(got) != 1 || got[0] != http.StatusServiceUnavailable {
+				t.Errorf("failing target got reports %v, want [503]", got)
+			}
+
+			if got := tu.statuses(healthy); len(got) != len(tt.healthy) {
+				t.Errorf("healthy target got reports %v, want %v", got, tt.healthy)
+			}
+		})
+	}
+}
+
+func TestRetryConnectError(t *testing.T) {
+	healthy := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	// target reads request and resets connection without response
+	reset := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed hijack: %v", err)
+			return
+		}
+
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	})
+
+	// address of closed listener refuses connections
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listen: %v", err)
+	}
+	refused := listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+		// retried is true when request must be sent to healthy target
+		retried bool
+	}{
+		{name: "refused", target: refused, want: http.StatusOK, retried: true},
+		{name: "reset after write", target: reset, want: http.StatusBadGateway, retried: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(tt.target, healthy)
+			gateway.Retry = config.RetryConfig{Attempts: 2, On: []string{"connect-error"}, MaxBodySize: 1 << 10}
+
+			handler, tu := newTestProxy(t, gateway, config.RetryBudgetConfig{Percent: 100})
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/test", strings.NewReader("update")))
+
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d", rec.Code, tt.want)
+			}
+
+			if got := tu.statuses(healthy); (len(got) > 0) != tt.retried {
+				t.Errorf("healthy target got reports %v, retried %v", got, tt.retried)
+			}
+		})
+	}
+}
+
+func TestSettled(t *testing.T) {
+	info := loadbalancer.DoneInfo{Status: http.StatusBadGateway, Err: errors.New("connection reset")}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancelExpired := context.WithTimeout(context.Background(), 0)
+	defer cancelExpired()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want loadbalancer.DoneInfo
+	}{
+		{name: "active", ctx: context.Background(), want: info},
+		{name: "canceled by client", ctx: canceled, want: loadbalancer.DoneInfo{}},
+		{name: "deadline exceeded", ctx: expired, want: info},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil).WithContext(tt.ctx)
+
+			if got := settled(req, info); got != tt.want {
+				t.Errorf("settled %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/router"
+)
+
+// templateVar matches {name} placeholder of path template
+var templateVar = regexp.MustCompile(`\{([^{}/]+)\}`)
+
+// pathRewriter changes path of request by rewrite rules of gateway
+type pathRewriter struct {
+	cfg   config.RewriteConfig
+	regex *regexp.Regexp
+}
+
+// newPathRewriter returns nil when gateway has no rewrite rules
+func newPathRewriter(gateway *config.Gateway) (*pathRewriter, error) {
+	cfg := gateway.Rewrite
+	if cfg == (config.RewriteConfig{}) {
+		return nil, nil
+	}
+
+	pr := &pathRewriter{
+		cfg: cfg,
+	}
+
+	if cfg.Regex != "" {
+		regex, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, err
+		}
+
+		pr.regex = regex
+	}
+
+	return pr, nil
+}
+
+// rewrite returns new escaped path of request, prefix matched by route is stripped,
+// rules are applied to escaped path, so encoded segments like %2F are kept
+func (pr *pathRewriter) rewrite(r *http.Request) string {
+	path := r.URL.EscapedPath()
+	match := router.FromContext(r.Context())
+
+	if pr.cfg.StripPrefix && match != nil {
+		path = trimEscapedPrefix(path, match.Prefix)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+
+	switch {
+	case pr.cfg.Path != "":
+		path = pr.render(match, path)
+	case pr.regex != nil:
+		path = pr.regex.ReplaceAllString(path, pr.cfg.Replacement)
+	}
+
+	if pr.cfg.AddPrefix != "" {
+		path = strings.TrimSuffix(pr.cfg.AddPrefix, "/") + path
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return path
+}
+
+// render fills path template with named groups of regex, route parameters and path itself
+func (pr *pathRewriter) render(match *router.Match, path string) string {
+	vars := map[string]string{
+		"path": path,
+	}
+
+	// parameters of route are decoded, so they are escaped back
+	if match != nil {
+		for name, value := range match.Params {
+			vars[name] = escapeSegments(value)
+		}
+	}
+
+	if pr.regex != nil {
+		// like regex replacement, template is applied only to matched paths
+		match := pr.regex.FindStringSubmatch(path)
+		if match == nil {
+			return path
+		}
+
+		for i, name := range pr.regex.SubexpNames() {
+			if name != "" {
+				vars[name] = match[i]
+			}
+		}
+	}
+
+	return templateVar.ReplaceAllStringFunc(pr.cfg.Path, func(placeholder string) string {
+		return vars[placeholder[1:len(placeholder)-1]]
+	})
+}
+
+// trimEscapedPrefix strips part of escaped path which is prefix once decoded
+func trimEscapedPrefix(escaped, prefix string) string {
+	// escaped prefix is from one to three times longer than decoded one
+	for i := len(prefix); i <= min(len(escaped), 3*len(prefix)); i++ {
+		if decoded, err := url.PathUnescape(escaped[:i]); err == nil && decoded == prefix {
+			return escaped[i:]
+		}
+	}
+
+	return escaped
+}
+
+// escapeSegments escapes every segment of path
+func escapeSegments(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// setEscapedPath sets path of url from escaped one, path which is not valid escape is taken as is
+func setEscapedPath(u *url.URL, escaped string) {
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		u.Path, u.RawPath = escaped, ""
+
+		return
+	}
+
+	u.Path, u.RawPath = path, escaped
+}
+
+// routeParam returns parameter of route which matched request
+func routeParam(r *http.Request, name string) string {
+	if match := router.FromContext(r.Context()); match != nil {
+		return match.Params[name]
+	}
+
+	return ""
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/router"
+)
+
+func TestPathRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		rewrite config.RewriteConfig
+		path    string
+		want    string
+	}{
+		{
+			name:    "strip prefix",
+			prefix:  "/users",
+			rewrite: config.RewriteConfig{StripPrefix: true},
+			path:    "/users/42",
+			want:    "/42",
+		},
+		{
+			name:    "strip the whole path",
+			prefix:  "/users",
+			rewrite: config.RewriteConfig{StripPrefix: true},
+			path:    "/users",
+			want:    "/",
+		},
+		{
+			name:    "strip prefix with param",
+			prefix:  "/tenants/{tenant}",
+			rewrite: config.RewriteConfig{StripPrefix: true},
+			path:    "/tenants/acme/orders",
+			want:    "/orders",
+		},
+		{
+			name:    "add prefix",
+			prefix:  "/users",
+			rewrite: config.RewriteConfig{StripPrefix: true, AddPrefix: "/api/v1/"},
+			path:    "/users/42",
+			want:    "/api/v1/42",
+		},
+		{
+			name:    "regex replacement",
+			prefix:  "/users",
+			rewrite: config.RewriteConfig{Regex: `^/users/(\d+)$`, Replacement: "/v2/user/$1"},
+			path:    "/users/42",
+			want:    "/v2/user/42",
+		},
+		{
+			name:    "regex does not match",
+			prefix:  "/users",
+			rewrite: config.RewriteConfig{Regex: `^/users/(\d+)$`, Replacement: "/v2/user/$1"},
+			path:    "/users/me",
+			want:    "/users/me",
+		},
+		{
+			name:   "template with regex groups",
+			prefix: "/users",
+			rewrite: config.RewriteConfig{
+				StripPrefix: true,
+				Regex:       `^/(?P<id>\d+)(?P<rest>/.*)?$`,
+				Path:        "/accounts/{id}/profile{rest}",
+			},
+			path: "/users/42/orders",
+			want: "/accounts/42/profile/orders",
+		},
+		{
+			name:    "template with route param",
+			prefix:  "/tenants/{tenant}",
+			rewrite: config.RewriteConfig{StripPrefix: true, Path: "/{tenant}/api{path}"},
+			path:    "/tenants/acme/orders",
+			want:    "/acme/api/orders",
+		},
+		{
+			name:    "strip prefix keeps encoded slash",
+			prefix:  "/files",
+			rewrite: config.RewriteConfig{StripPrefix: true},
+			path:    "/files/a%2Fb/c",
+			want:    "/a%2Fb/c",
+		},
+		{
+			name:    "regex keeps encoded slash",
+			prefix:  "/files",
+			rewrite: config.RewriteConfig{Regex: `^/files/(.*)$`, Replacement: "/v2/$1"},
+			path:    "/files/a%2Fb",
+			want:    "/v2/a%2Fb",
+		},
+		{
+			name:    "template escapes route param",
+			prefix:  "/tenants/{tenant}",
+			rewrite: config.RewriteConfig{StripPrefix: true, Path: "/{tenant}/api{path}"},
+			path:    "/tenants/ac%20me/orders",
+			want:    "/ac%20me/api/orders",
+		},
+		{
+			name:    "template keeps path not matched by regex",
+			prefix:  "/users",
+			rewrite: config.RewriteConfig{Regex: `^/users/(?P<id>\d+)$`, Path: "/accounts/{id}"},
+			path:    "/users/me",
+			want:    "/users/me",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := config.Gateway{Name: "test", Prefix: tt.prefix, Rewrite: tt.rewrite}
+
+			rtr, err := router.New([]config.Gateway{gateway})
+			if err != nil {
+				t.Fatalf("failed create router: %v", err)
+			}
+
+			pr, err := newPathRewriter(&gateway)
+			if err != nil {
+				t.Fatalf("failed create rewriter: %v", err)
+			}
+
+			r := httptest.NewRequest("GET", tt.path, nil)
+
+			match, ok := rtr.Match(r)
+			if !ok {
+				t.Fatalf("path %s is not matched", tt.path)
+			}
+
+			r = r.WithContext(router.NewContext(r.Context(), match))
+
+			if got := pr.rewrite(r); got != tt.want {
+				t.Errorf("rewrite %s = %s, want %s", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathRewriterDisabled(t *testing.T) {
+	pr, err := newPathRewriter(&config.Gateway{Prefix: "/users"})
+	if err != nil || pr != nil {
+		t.Errorf("got rewriter %v and error %v for gateway without rules", pr, err)
+	}
+
+	if _, err := newPathRewriter(&config.Gateway{Rewrite: config.RewriteConfig{Regex: "("}}); err == nil {
+		t.Errorf("invalid regex is accepted")
+	}
+}
+
+func TestRewriteEncodedPath(t *testing.T) {
+	received := make(chan string, 1)
+
+	target := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.RequestURI
+	})
+
+	gateway := newTestGateway(target)
+	gateway.Rewrite = config.RewriteConfig{AddPrefix: "/api"}
+
+	handler, _ := newTestProxy(t, gateway, config.RetryBudgetConfig{})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test/a%2Fb?q=1", nil))
+
+	// encoded slash stays part of segment
+	if got := <-received; got != "/api/test/a%2Fb?q=1" {
+		t.Errorf("target got %s, want %s", got, "/api/test/a%2Fb?q=1")
+	}
+}
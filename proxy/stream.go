@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/metrics"
+)
+
+type (
+	// streamTracker stores open streams of all gateways, so they can be drained on shutdown
+	streamTracker struct {
+		open     map[*streamBody]struct{}
+		draining bool
+		// empty is closed when the last stream is closed while draining
+		empty chan struct{}
+		mu    sync.Mutex
+	}
+
+	// streamBody is body of upgraded connection or streaming response,
+	// it is closed when stream is idle or lives longer than allowed
+	streamBody struct {
+		io.ReadCloser
+		// writer is set for upgraded connection, reverse proxy writes client data to it
+		writer  io.Writer
+		cfg     config.StreamConfig
+		gateway string
+		tracker *streamTracker
+		// active is unix nano time of the last traffic
+		active   atomic.Int64
+		idle     *time.Timer
+		lifetime *time.Timer
+		closed   bool
+		once     sync.Once
+		mu       sync.Mutex
+	}
+
+	// streamWriter passes data of client to upgraded connection
+	streamWriter struct {
+		sb *streamBody
+	}
+)
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{
+		open:  make(map[*streamBody]struct{}),
+		empty: make(chan struct{}),
+	}
+}
+
+// track wraps body of streaming response, false is returned while draining
+func (st *streamTracker) track(resp *http.Response, gateway string, cfg config.StreamConfig) bool {
+	sb := &streamBody{
+		ReadCloser: resp.Body,
+		cfg:        cfg,
+		gateway:    gateway,
+		tracker:    st,
+	}
+	sb.active.Store(time.Now().UnixNano())
+
+	st.mu.Lock()
+	if st.draining {
+		st.mu.Unlock()
+
+		return false
+	}
+	st.open[sb] = struct{}{}
+	st.mu.Unlock()
+
+	metrics.OpenStreams.WithLabelValues(gateway).Inc()
+
+	sb.mu.Lock()
+	if cfg.IdleTimeout > 0 {
+		sb.idle = time.AfterFunc(cfg.IdleTimeout, sb.checkIdle)
+	}
+	if cfg.MaxLifetime > 0 {
+		sb.lifetime = time.AfterFunc(cfg.MaxLifetime, func() { sb.Close() })
+	}
+	sb.mu.Unlock()
+
+	resp.Body = sb
+
+	if writer, ok := sb.ReadCloser.(io.Writer); ok {
+		// reverse proxy requires writable body of upgraded connection
+		sb.writer = writer
+		resp.Body = struct {
+			io.ReadCloser
+			io.Writer
+		}{sb, streamWriter{sb}}
+	}
+
+	return true
+}
+
+// isDraining returns true when new streams are not accepted
+func (st *streamTracker) isDraining() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.draining
+}
+
+// drain stops new streams and waits until open ones are closed,
+// streams still open when ctx is done are closed
+func (st *streamTracker) drain(ctx context.Context) error {
+	st.mu.Lock()
+	if !st.draining {
+		st.draining = true
+		if len(st.open) == 0 {
+			close(st.empty)
+		}
+	}
+	st.mu.Unlock()
+
+	select {
+	case <-st.empty:
+		return nil
+	case <-ctx.Done():
+	}
+
+	st.mu.Lock()
+	open := make([]*streamBody, 0, len(st.open))
+	for sb := range st.open {
+		open = append(open, sb)
+	}
+	st.mu.Unlock()
+
+	for _, sb := range open {
+		sb.Close()
+	}
+
+	return ctx.Err()
+}
+
+func (st *streamTracker) remove(sb *streamBody) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.open, sb)
+
+	if st.draining && len(st.open) == 0 {
+		close(st.empty)
+	}
+}
+
+func (sb *streamBody) Read(p []byte) (int, error) {
+	n, err := sb.ReadCloser.Read(p)
+	if n > 0 {
+		sb.active.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+func (sb *streamBody) Close() error {
+	err := sb.ReadCloser.Close()
+
+	sb.once.Do(func() {
+		sb.mu.Lock()
+		sb.closed = true
+		if sb.idle != nil {
+			sb.idle.Stop()
+		}
+		if sb.lifetime != nil {
+			sb.lifetime.Stop()
+		}
+		sb.mu.Unlock()
+
+		sb.tracker.remove(sb)
+		metrics.OpenStreams.WithLabelValues(sb.gateway).Dec()
+	})
+
+	return err
+}
+
+// checkIdle closes stream without traffic, reverse proxy then closes
+// connection of client, otherwise the check is scheduled again
+func (sb *streamBody) checkIdle() {
+	idle := time.Since(time.Unix(0, sb.active.Load()))
+	if idle >= sb.cfg.IdleTimeout {
+		sb.Close()
+
+		return
+	}
+
+	sb.mu.Lock()
+	if !sb.closed {
+		sb.idle.Reset(sb.cfg.IdleTimeout - idle)
+	}
+	sb.mu.Unlock()
+}
+
+func (sw streamWriter) Write(p []byte) (int, error) {
+	n, err := sw.sb.writer.Write(p)
+	if n > 0 {
+		sw.sb.active.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
@@ -0,0 +1,382 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/logger"
+	"go.uber.org/zap"
+)
+
+// newTestStreamProxy serves gateway by real server, so connections may be hijacked
+func newTestStreamProxy(t *testing.T, gateway *config.Gateway) (string, *ProxyMW) {
+	t.Helper()
+
+	mw, err := NewProxyMW(&config.Config{Gateways: []config.Gateway{*gateway}}, &logger.Logger{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("failed create proxy: %v", err)
+	}
+
+	tu := newTestUpstream(gateway)
+
+	return newTestTarget(t, mw.Middleware(Upstream{Gateway: gateway, Select: tu.selectTarget})), mw
+}
+
+// newEventTarget sends event every interval until request is canceled,
+// the first event is sent at once
+func newEventTarget(t *testing.T, interval time.Duration) string {
+	return newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		for {
+			io.WriteString(w, "data: event\n\n")
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	})
+}
+
+// openStream sends event stream request and reads its first event
+func openStream(t *testing.T, addr string) (*http.Response, error) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/test", nil)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		if err != nil || line != "data: event\n" {
+			t.Fatalf("first event is %q, %v", line, err)
+		}
+	}
+
+	return resp, nil
+}
+
+func TestStreamWebsocket(t *testing.T) {
+	target := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		rw.Flush()
+
+		// echo lines until client closes connection
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			rw.WriteString("echo " + line)
+			rw.Flush()
+		}
+	})
+
+	addr, _ := newTestStreamProxy(t, newTestGateway(target))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, "GET /test HTTP/1.1\r\nHost: orion\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	// data goes both ways over the same connection
+	for _, msg := range []string{"ping\n", "pong\n"} {
+		io.WriteString(conn, msg)
+
+		if line, err := reader.ReadString('\n'); err != nil || line != "echo "+msg {
+			t.Fatalf("got %q, %v, want %q", line, err, "echo "+msg)
+		}
+	}
+}
+
+func TestStreamFlushInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		// flushed is true when client gets first part before response is finished
+		flushed bool
+	}{
+		{name: "every write", interval: -1, flushed: true},
+		{name: "period", interval: 10 * time.Millisecond, flushed: true},
+		{name: "buffered", interval: 0, flushed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+
+			// response of known length is flushed by flush interval only
+			target := newTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "2")
+				w.Write([]byte("a"))
+				w.(http.Flusher).Flush()
+
+				select {
+				case <-release:
+				case <-time.After(time.Second):
+				}
+
+				w.Write([]byte("b"))
+			})
+
+			gateway := newTestGateway(target)
+			gateway.Stream.FlushInterval = tt.interval
+
+			addr, _ := newTestStreamProxy(t, gateway)
+
+			// headers of buffered response are sent with its end too
+			read := make(chan string, 1)
+			go func() {
+				resp, err := http.Get("http://" + addr + "/test")
+				if err != nil {
+					read <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+
+				buf := make([]byte, 1)
+				io.ReadFull(resp.Body, buf)
+				read <- string(buf)
+			}()
+
+			flushed := false
+
+			select {
+			case part := <-read:
+				flushed = part == "a"
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			close(release)
+
+			if !flushed {
+				if part := <-read; part != "a" {
+					t.Fatalf("got %q, want response", part)
+				}
+			}
+
+			if flushed != tt.flushed {
+				t.Errorf("first part flushed %v, want %v", flushed, tt.flushed)
+			}
+		})
+	}
+}
+
+func TestStreamMaxConcurrent(t *testing.T) {
+	events := newEventTarget(t, 10*time.Millisecond)
+
+	gateway := newTestGateway(events)
+	gateway.Stream.MaxConcurrent = 1
+
+	addr, _ := newTestStreamProxy(t, gateway)
+
+	first, err := openStream(t, addr)
+	if err != nil || first.StatusCode != http.StatusOK {
+		t.Fatalf("failed open stream: %v", err)
+	}
+
+	second, err := openStream(t, addr)
+	if err != nil {
+		t.Fatalf("failed send stream request: %v", err)
+	}
+	second.Body.Close()
+
+	if second.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status of stream over limit %d, want %d", second.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	// slot of closed stream is released
+	first.Body.Close()
+
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		third, err := openStream(t, addr)
+		if err != nil {
+			t.Fatalf("failed send stream request: %v", err)
+		}
+		third.Body.Close()
+
+		if third.StatusCode == http.StatusOK {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("slot of closed stream is not released, status %d", third.StatusCode)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   config.StreamConfig
+		interval time.Duration
+		// closed is true when stream must be closed by gateway
+		closed bool
+	}{
+		{name: "idle", stream: config.StreamConfig{IdleTimeout: 50 * time.Millisecond}, interval: time.Second, closed: true},
+		{name: "active", stream: config.StreamConfig{IdleTimeout: 50 * time.Millisecond}, interval: 10 * time.Millisecond, closed: false},
+		{name: "lifetime", stream: config.StreamConfig{MaxLifetime: 50 * time.Millisecond}, interval: 10 * time.Millisecond, closed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newTestGateway(newEventTarget(t, tt.interval))
+			gateway.Stream = tt.stream
+
+			addr, _ := newTestStreamProxy(t, gateway)
+
+			resp, err := openStream(t, addr)
+			if err != nil {
+				t.Fatalf("failed open stream: %v", err)
+			}
+			defer resp.Body.Close()
+
+			done := make(chan struct{})
+			go func() {
+				io.Copy(io.Discard, resp.Body)
+				close(done)
+			}()
+
+			closed := false
+
+			select {
+			case <-done:
+				closed = true
+			case <-time.After(300 * time.Millisecond):
+			}
+
+			if closed != tt.closed {
+				t.Errorf("stream closed %v, want %v", closed, tt.closed)
+			}
+		})
+	}
+}
+
+func TestStreamDrain(t *testing.T) {
+	tests := []struct {
+		name string
+		// closeFirst closes stream by client before drain timeout
+		closeFirst bool
+		wantErr    error
+	}{
+		{name: "streams closed by clients", closeFirst: true, wantErr: nil},
+		{name: "streams closed on timeout", closeFirst: false, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newEventTarget(t, 10*time.Millisecond)
+			addr, mw := newTestStreamProxy(t, newTestGateway(target))
+
+			resp, err := openStream(t, addr)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("failed open stream: %v", err)
+			}
+			defer resp.Body.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			drained := make(chan error, 1)
+			go func() {
+				drained <- mw.Drain(ctx)
+			}()
+
+			// new streams are rejected while draining, plain requests are still served
+			deadline := time.Now().Add(time.Second)
+
+			for !mw.streams.isDraining() {
+				if time.Now().After(deadline) {
+					t.Fatalf("proxy is not draining")
+				}
+
+				time.Sleep(time.Millisecond)
+			}
+
+			rejected, err := openStream(t, addr)
+			if err != nil {
+				t.Fatalf("failed send stream request: %v", err)
+			}
+			rejected.Body.Close()
+
+			if rejected.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("status of stream while draining %d, want %d", rejected.StatusCode, http.StatusServiceUnavailable)
+			}
+
+			if tt.closeFirst {
+				resp.Body.Close()
+			}
+
+			select {
+			case err := <-drained:
+				if err != tt.wantErr {
+					t.Errorf("drain error %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("drain is not finished")
+			}
+
+			if tt.closeFirst {
+				return
+			}
+
+			// open stream is closed by drain
+			closed := make(chan struct{})
+			go func() {
+				io.Copy(io.Discard, resp.Body)
+				close(closed)
+			}()
+
+			select {
+			case <-closed:
+			case <-time.After(time.Second):
+				t.Errorf("stream is not closed by drain")
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/stream"
+)
+
+type (
+	// targetTransport keeps pooled connections to one target
+	targetTransport struct {
+		*http.Transport
+		// upgrade speaks HTTP/1.1 to HTTP/2 targets, since upgrades like
+		// websocket are not possible over HTTP/2
+		upgrade    *http.Transport
+		endpoint   config.Endpoint
+		dnsRefresh time.Duration
+		// refreshed is unix nano time when idle connections were closed last time
+		refreshed atomic.Int64
+	}
+
+	// transportPool stores transport of every target of gateway,
+	// transports are created on first request and reused after it
+	transportPool struct {
+		gateway *config.Gateway
+		// tls is client tls config of https targets
+		tls        *tls.Config
+		transports map[string]*targetTransport
+		mu         sync.RWMutex
+	}
+)
+
+func newTransportPool(gateway *config.Gateway) (*transportPool, error) {
+	tlsCfg, err := gateway.TLS.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed build upstream tls of gateway %s: %v", gateway.Name, err)
+	}
+
+	return &transportPool{
+		gateway:    gateway,
+		tls:        tlsCfg,
+		transports: make(map[string]*targetTransport, len(gateway.AllTargets())),
+	}, nil
+}
+
+// get returns transport of target and creates it when there is no one
+func (tp *transportPool) get(target string) (*targetTransport, error) {
+	tp.mu.RLock()
+	transport, ok := tp.transports[target]
+	tp.mu.RUnlock()
+
+	if ok {
+		return transport, nil
+	}
+
+	endpoint, err := config.ParseEndpoint(target)
+	if err != nil {
+		return nil, err
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	if transport, ok := tp.transports[target]; ok {
+		return transport, nil
+	}
+
+	transport = newTargetTransport(endpoint, tp.tls, tp.gateway.Timeout, tp.gateway.Transport)
+	tp.transports[target] = transport
+
+	return transport, nil
+}
+
+// newTargetTransport creates transport with timeouts and pool settings of gateway
+func newTargetTransport(endpoint config.Endpoint, tlsCfg *tls.Config, timeout config.TimeoutConfig, cfg config.TransportConfig) *targetTransport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialer := &net.Dialer{
+		Timeout:   timeout.Connect,
+		KeepAlive: cfg.KeepAlive,
+	}
+
+	transport.DialContext = dialer.DialContext
+	if endpoint.Scheme == "unix" {
+		// host of request url is only a placeholder for socket
+		network, addr := endpoint.Addr()
+
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	// transport sets its protocols in tls config, so config of gateway is not shared
+	transport.TLSClientConfig = tlsCfg.Clone()
+	transport.ResponseHeaderTimeout = timeout.ResponseHeader
+	transport.IdleConnTimeout = timeout.Idle
+	// transport serves single target, so only per host limit matters
+	transport.MaxIdleConns = 0
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+
+	switch {
+	case endpoint.Scheme == "h2c":
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	case cfg.HTTP2:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	tt := &targetTransport{
+		Transport:  transport,
+		endpoint:   endpoint,
+		dnsRefresh: cfg.DNSRefresh,
+	}
+
+	if transport.Protocols != nil {
+		tt.upgrade = transport.Clone()
+		tt.upgrade.Protocols = new(http.Protocols)
+		tt.upgrade.Protocols.SetHTTP1(true)
+	}
+	tt.refreshed.Store(time.Now().UnixNano())
+
+	return tt
+}
+
+// CloseIdleConnections closes idle connections of both transports
+func (tt *targetTransport) CloseIdleConnections() {
+	tt.Transport.CloseIdleConnections()
+
+	if tt.upgrade != nil {
+		tt.upgrade.CloseIdleConnections()
+	}
+}
+
+func (tt *targetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if tt.dnsRefresh > 0 {
+		now := time.Now().UnixNano()
+		last := tt.refreshed.Load()
+
+		// new connections resolve name of target again
+		if now-last > int64(tt.dnsRefresh) && tt.refreshed.CompareAndSwap(last, now) {
+			tt.CloseIdleConnections()
+		}
+	}
+
+	if tt.upgrade != nil && stream.HasToken(req.Header.Values("Connection"), "upgrade") {
+		return tt.upgrade.RoundTrip(req)
+	}
+
+	return tt.Transport.RoundTrip(req)
+}
@@ -34,7 +34,7 @@ func (mw *RateLimitingMW) RateLimitMiddleware(next http.Handler) http.Handler {
 
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 
-			metrics.ErrorRequestTotal.WithLabelValues(r.URL.Path).Inc()
+			metrics.ErrorRequestTotal.WithLabelValues(r.URL.Path, "rate_limit").Inc()
 
 			return
 		}
@@ -0,0 +1,28 @@
+package router
+
+// segment is non empty segment of request path
+type segment struct {
+	value string
+	// end is offset of segment end in path
+	end int
+}
+
+// splitPath splits path like config.ParsePathPattern, so "/a//b/" has two segments
+func splitPath(path string) []segment {
+	var segments []segment
+
+	start := 0
+	for i := 0; i <= len(path); i++ {
+		if i < len(path) && path[i] != '/' {
+			continue
+		}
+
+		if i > start {
+			segments = append(segments, segment{value: path[start:i], end: i})
+		}
+
+		start = i + 1
+	}
+
+	return segments
+}
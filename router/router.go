@@ -0,0 +1,316 @@
+// package matches requests with routes of gateways
+package router
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/osamikoyo/orion/config"
+)
+
+// route kinds, routes of smaller kind are matched first
+const (
+	kindPath = iota
+	kindRegex
+	kindPrefix
+)
+
+type (
+	// Match is route which matched request
+	Match struct {
+		Gateway *config.Gateway
+		// Prefix is part of request path matched by route,
+		// it is the whole path for exact routes
+		Prefix string
+		// Params stores values of {name} segments and named groups of path regex
+		Params map[string]string
+	}
+
+	// Router matches requests in order of route priority and specificity
+	Router struct {
+		routes []*route
+	}
+
+	route struct {
+		gateway *config.Gateway
+		kind    int
+		pattern config.PathPattern
+		regex   *regexp.Regexp
+		hosts   []string
+		sni     []string
+		methods []string
+		headers map[string]string
+		query   map[string]string
+	}
+
+	matchKey struct{}
+)
+
+// New compiles routes of gateways, config must be validated before
+func New(gateways []config.Gateway) (*Router, error) {
+	routes := make([]*route, 0, len(gateways))
+
+	for i := range gateways {
+		rt, err := newRoute(&gateways[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed compile route of gateway %s: %v", gateways[i].Name, err)
+		}
+
+		routes = append(routes, rt)
+	}
+
+	// stable sort keeps order of config between equally specific routes
+	slices.SortStableFunc(routes, compareRoutes)
+
+	return &Router{
+		routes: routes,
+	}, nil
+}
+
+func newRoute(gateway *config.Gateway) (*route, error) {
+	match := &gateway.Match
+
+	rt := &route{
+		gateway: gateway,
+		methods: match.Methods,
+		query:   match.Query,
+		headers: make(map[string]string, len(match.Headers)),
+	}
+
+	for name, value := range match.Headers {
+		rt.headers[http.CanonicalHeaderKey(name)] = value
+	}
+
+	for _, host := range match.Hosts {
+		rt.hosts = append(rt.hosts, strings.ToLower(host))
+	}
+
+	for _, host := range match.SNI {
+		rt.sni = append(rt.sni, strings.ToLower(host))
+	}
+
+	var err error
+
+	switch {
+	case gateway.Prefix != "":
+		rt.kind = kindPrefix
+		rt.pattern, err = config.ParsePathPattern(gateway.Prefix)
+	case match.Path != "":
+		rt.kind = kindPath
+		rt.pattern, err = config.ParsePathPattern(match.Path)
+	default:
+		rt.kind = kindRegex
+		rt.regex, err = regexp.Compile("^(?:" + match.PathRegex + ")")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rt, nil
+}
+
+// Match returns the first route which matches request
+func (rtr *Router) Match(r *http.Request) (*Match, bool) {
+	path := r.URL.Path
+	segments := splitPath(path)
+
+	for _, rt := range rtr.routes {
+		if !rt.matchPredicates(r) {
+			continue
+		}
+
+		if m, ok := rt.matchPath(path, segments); ok {
+			return m, true
+		}
+	}
+
+	return nil, false
+}
+
+// NewContext returns context which carries match of request
+func NewContext(ctx context.Context, m *Match) context.Context {
+	return context.WithValue(ctx, matchKey{}, m)
+}
+
+// FromContext returns match stored by NewContext or nil
+func FromContext(ctx context.Context) *Match {
+	m, _ := ctx.Value(matchKey{}).(*Match)
+
+	return m
+}
+
+func (rt *route) matchPredicates(r *http.Request) bool {
+	if len(rt.methods) > 0 && !slices.Contains(rt.methods, r.Method) {
+		return false
+	}
+
+	if len(rt.hosts) > 0 && !matchHost(rt.hosts, requestHost(r)) {
+		return false
+	}
+
+	if len(rt.sni) > 0 && (r.TLS == nil || !matchHost(rt.sni, strings.ToLower(r.TLS.ServerName))) {
+		return false
+	}
+
+	for name, value := range rt.headers {
+		if !matchValue(r.Header.Values(name), value) {
+			return false
+		}
+	}
+
+	if len(rt.query) > 0 {
+		query := r.URL.Query()
+
+		for name, value := range rt.query {
+			if !matchValue(query[name], value) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (rt *route) matchPath(path string, segments []segment) (*Match, bool) {
+	if rt.kind == kindRegex {
+		groups := rt.regex.FindStringSubmatch(path)
+		if groups == nil {
+			return nil, false
+		}
+
+		params := make(map[string]string)
+		for i, name := range rt.regex.SubexpNames() {
+			if name != "" {
+				params[name] = groups[i]
+			}
+		}
+
+		return &Match{Gateway: rt.gateway, Prefix: groups[0], Params: params}, true
+	}
+
+	if len(segments) < len(rt.pattern) || rt.kind == kindPath && len(segments) != len(rt.pattern) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+
+	for i, ps := range rt.pattern {
+		switch {
+		case ps.Param != "":
+			params[ps.Param] = segments[i].value
+		case ps.Wildcard:
+		case ps.Literal != segments[i].value:
+			return nil, false
+		}
+	}
+
+	prefix := path
+	if rt.kind == kindPrefix {
+		prefix = ""
+		if len(rt.pattern) > 0 {
+			prefix = path[:segments[len(rt.pattern)-1].end]
+		}
+	}
+
+	return &Match{Gateway: rt.gateway, Prefix: prefix, Params: params}, true
+}
+
+// compareRoutes orders routes by priority, kind and specificity
+func compareRoutes(a, b *route) int {
+	return cmp.Or(
+		cmp.Compare(b.gateway.Match.Priority, a.gateway.Match.Priority),
+		cmp.Compare(a.kind, b.kind),
+		// more literal and longer paths are more specific
+		cmp.Compare(b.literals(), a.literals()),
+		cmp.Compare(len(b.pattern), len(a.pattern)),
+		cmp.Compare(a.wildcards(), b.wildcards()),
+		cmp.Compare(hostRank(b.hosts), hostRank(a.hosts)),
+		cmp.Compare(hostRank(b.sni), hostRank(a.sni)),
+		cmp.Compare(min(len(b.methods), 1), min(len(a.methods), 1)),
+		cmp.Compare(len(b.headers)+len(b.query), len(a.headers)+len(a.query)),
+	)
+}
+
+func (rt *route) literals() int {
+	count := 0
+
+	for _, ps := range rt.pattern {
+		if ps.Literal != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (rt *route) wildcards() int {
+	count := 0
+
+	for _, ps := range rt.pattern {
+		if ps.Wildcard {
+			count++
+		}
+	}
+
+	return count
+}
+
+// hostRank is 2 for routes with exact host, 1 for routes with only wildcards
+// and 0 for routes matching any host
+func hostRank(hosts []string) int {
+	rank := 0
+
+	for _, host := range hosts {
+		if strings.HasPrefix(host, "*.") {
+			rank = max(rank, 1)
+		} else {
+			rank = 2
+		}
+	}
+
+	return rank
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+
+			continue
+		}
+
+		if host == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestHost returns lowercase Host of request without port
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(host)
+}
+
+// matchValue returns true when one of values equals expected, "*" requires only presence
+func matchValue(values []string, expected string) bool {
+	if expected == "*" {
+		return len(values) > 0
+	}
+
+	return slices.Contains(values, expected)
+}
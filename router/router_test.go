@@ -0,0 +1,251 @@
+package router
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/osamikoyo/orion/config"
+)
+
+func newTestRouter(t *testing.T, gateways ...config.Gateway) *Router {
+	t.Helper()
+
+	rtr, err := New(gateways)
+	if err != nil {
+		t.Fatalf("failed create router: %v", err)
+	}
+
+	return rtr
+}
+
+func TestRouterSelection(t *testing.T) {
+	tests := []struct {
+		name     string
+		gateways []config.Gateway
+		request  func() *http.Request
+		want     string
+	}{
+		{
+			name: "longer prefix",
+			gateways: []config.Gateway{
+				{Name: "api", Prefix: "/api"},
+				{Name: "users", Prefix: "/api/users"},
+			},
+			request: func() *http.Request { return httptest.NewRequest("GET", "/api/users/1", nil) },
+			want:    "users",
+		},
+		{
+			name: "prefix matches whole segments",
+			gateways: []config.Gateway{
+				{Name: "api", Prefix: "/api"},
+				{Name: "users", Prefix: "/api/users"},
+			},
+			request: func() *http.Request { return httptest.NewRequest("GET", "/api/usersx", nil) },
+			want:    "api",
+		},
+		{
+			name: "exact path before prefix",
+			gateways: []config.Gateway{
+				{Name: "users", Prefix: "/users/me"},
+				{Name: "me", Match: config.MatchConfig{Path: "/users/me"}},
+			},
+			request: func() *http.Request { return httptest.NewRequest("GET", "/users/me", nil) },
+			want:    "me",
+		},
+		{
+			name: "regex before prefix",
+			gateways: []config.Gateway{
+				{Name: "users", Prefix: "/users"},
+				{Name: "numeric", Match: config.MatchConfig{PathRegex: `/users/\d+$`}},
+			},
+			request: func() *http.Request { return httptest.NewRequest("GET", "/users/42", nil) },
+			want:    "numeric",
+		},
+		{
+			name: "priority wins over specificity",
+			gateways: []config.Gateway{
+				{Name: "users", Prefix: "/api/users"},
+				{Name: "api", Prefix: "/api", Match: config.MatchConfig{Priority: 10}},
+			},
+			request: func() *http.Request { return httptest.NewRequest("GET", "/api/users/1", nil) },
+			want:    "api",
+		},
+		{
+			name: "literal before parameter",
+			gateways: []config.Gateway{
+				{Name: "param", Prefix: "/users/{id}"},
+				{Name: "literal", Prefix: "/users/me"},
+			},
+			request: func() *http.Request { return httptest.NewRequest("GET", "/users/me", nil) },
+			want:    "literal",
+		},
+		{
+			name: "exact host before wildcard",
+			gateways: []config.Gateway{
+				{Name: "any", Prefix: "/", Match: config.MatchConfig{Hosts: []string{"*.example.com"}}},
+				{Name: "api", Prefix: "/", Match: config.MatchConfig{Hosts: []string{"api.example.com"}}},
+			},
+			request: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Host = "API.example.com:8080"
+
+				return r
+			},
+			want: "api",
+		},
+		{
+			name: "wildcard host",
+			gateways: []config.Gateway{
+				{Name: "default", Prefix: "/"},
+				{Name: "sub", Prefix: "/", Match: config.MatchConfig{Hosts: []string{"*.example.com"}}},
+			},
+			request: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Host = "example.com"
+
+				return r
+			},
+			want: "default",
+		},
+		{
+			name: "method",
+			gateways: []config.Gateway{
+				{Name: "read", Prefix: "/users", Match: config.MatchConfig{Methods: []string{"GET"}}},
+				{Name: "write", Prefix: "/users", Match: config.MatchConfig{Methods: []string{"POST", "PUT"}}},
+			},
+			request: func() *http.Request { return httptest.NewRequest("PUT", "/users", nil) },
+			want:    "write",
+		},
+		{
+			name: "header",
+			gateways: []config.Gateway{
+				{Name: "stable", Prefix: "/"},
+				{Name: "beta", Prefix: "/", Match: config.MatchConfig{Headers: map[string]string{"x-beta": "*"}}},
+			},
+			request: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("X-Beta", "1")
+
+				return r
+			},
+			want: "beta",
+		},
+		{
+			name: "query",
+			gateways: []config.Gateway{
+				{Name: "stable", Prefix: "/"},
+				{Name: "v2", Prefix: "/", Match: config.MatchConfig{Query: map[string]string{"version": "2"}}},
+			},
+			request: func() *http.Request { return httptest.NewRequest("GET", "/?version=1", nil) },
+			want:    "stable",
+		},
+		{
+			name: "sni",
+			gateways: []config.Gateway{
+				{Name: "plain", Prefix: "/"},
+				{Name: "secure", Prefix: "/", Match: config.MatchConfig{SNI: []string{"secure.example.com"}}},
+			},
+			request: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.TLS = &tls.ConnectionState{ServerName: "secure.example.com"}
+
+				return r
+			},
+			want: "secure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rtr := newTestRouter(t, tt.gateways...)
+
+			m, ok := rtr.Match(tt.request())
+			if !ok {
+				t.Fatalf("request is not matched")
+			}
+
+			if m.Gateway.Name != tt.want {
+				t.Errorf("matched gateway %s, want %s", m.Gateway.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		gateway    config.Gateway
+		path       string
+		wantPrefix string
+		wantParams map[string]string
+	}{
+		{
+			name:       "prefix params",
+			gateway:    config.Gateway{Prefix: "/tenants/{tenant}/users/{id}"},
+			path:       "/tenants/acme/users/42/orders",
+			wantPrefix: "/tenants/acme/users/42",
+			wantParams: map[string]string{"tenant": "acme", "id": "42"},
+		},
+		{
+			name:       "wildcard",
+			gateway:    config.Gateway{Prefix: "/files/*"},
+			path:       "/files/a/b",
+			wantPrefix: "/files/a",
+			wantParams: map[string]string{},
+		},
+		{
+			name:       "exact path",
+			gateway:    config.Gateway{Match: config.MatchConfig{Path: "/users/{id}"}},
+			path:       "/users/42",
+			wantPrefix: "/users/42",
+			wantParams: map[string]string{"id": "42"},
+		},
+		{
+			name:       "regex groups",
+			gateway:    config.Gateway{Match: config.MatchConfig{PathRegex: `/v(?P<version>\d+)/`}},
+			path:       "/v2/users",
+			wantPrefix: "/v2/",
+			wantParams: map[string]string{"version": "2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rtr := newTestRouter(t, tt.gateway)
+
+			m, ok := rtr.Match(httptest.NewRequest("GET", tt.path, nil))
+			if !ok {
+				t.Fatalf("path %s is not matched", tt.path)
+			}
+
+			if m.Prefix != tt.wantPrefix {
+				t.Errorf("prefix %s, want %s", m.Prefix, tt.wantPrefix)
+			}
+
+			if len(m.Params) != len(tt.wantParams) {
+				t.Errorf("params %v, want %v", m.Params, tt.wantParams)
+			}
+
+			for name, want := range tt.wantParams {
+				if m.Params[name] != want {
+					t.Errorf("param %s is %q, want %q", name, m.Params[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouterNoMatch(t *testing.T) {
+	rtr := newTestRouter(t,
+		config.Gateway{Name: "users", Prefix: "/users"},
+		config.Gateway{Name: "me", Match: config.MatchConfig{Path: "/me"}},
+	)
+
+	for _, path := range []string{"/orders", "/me/settings", "/"} {
+		if m, ok := rtr.Match(httptest.NewRequest("GET", path, nil)); ok {
+			t.Errorf("path %s matched gateway %s", path, m.Gateway.Name)
+		}
+	}
+}
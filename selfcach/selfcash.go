@@ -27,6 +27,7 @@ func NewCache(logger *logger.Logger, defaultTTL, cleanupInterval time.Duration)
 		cacheMap:   make(map[string]item),
 		quit:       make(chan struct{}),
 		defaultTTL: defaultTTL,
+		logger:     logger,
 	}
 
 	go func() {
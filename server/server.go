@@ -2,73 +2,128 @@ package server
 
 import (
 	"context"
-	"time"
+	"errors"
+	"net/http"
+	"slices"
 
+	txhttp "github.com/corazawaf/coraza/v3/http"
 	"github.com/go-chi/chi/v5"
-	"github.com/osamikoyo/orion/auth"
-	"github.com/osamikoyo/orion/cache"
 	"github.com/osamikoyo/orion/config"
+	"github.com/osamikoyo/orion/handler"
+	"github.com/osamikoyo/orion/loadbalancer"
+	"github.com/osamikoyo/orion/logger"
+	"github.com/osamikoyo/orion/metrics"
 	"github.com/osamikoyo/orion/proxy"
-	"github.com/osamikoyo/orion/rate"
-	"github.com/osamikoyo/orion/selfcach"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/quic-go/quic-go/http3"
-	"github.com/rs/zerolog"
+	"go.uber.org/zap"
 )
 
 type Server struct {
 	router chi.Router
-	logger *zerolog.Logger
+	logger *logger.Logger
 	cfg    *config.Config
-	h3S    *http3.Server
+	lb     *loadbalancer.LoadBalancer
+	proxy  *proxy.ProxyMW
+	// h3S is used for http3 proto, httpS for the others
+	h3S   *http3.Server
+	httpS *http.Server
 }
 
-func NewServer(r chi.Router, logger *zerolog.Logger, cfg *config.Config) *Server {
-	cache := cache.NewCache(
-		selfcach.NewCache(10*time.Minute, 5*time.Hour),
-		logger,
-		cfg,
-	)
+// NewServer mounts gateways on router, returned cancel stops health checks of targets
+func NewServer(r chi.Router, logger *logger.Logger, cfg *config.Config) (*Server, context.CancelFunc, error) {
+	lb, cancel, err := loadbalancer.NewLoadBalancer(cfg, logger)
+	if err != nil {
+		logger.Error("failed create load balancer", zap.Error(err))
 
-	auth := auth.NewAuthMW(&cfg.AuthConfig, logger)
-	rl := rate.NewRateLimitingMiddleware(logger, &cfg.RateLimitingConfig)
-	proxy := proxy.NewProxyMW(logger)
+		return nil, nil, err
+	}
 
-	r.Route("/api", func(r chi.Router) {
-		for _, gateway := range cfg.Gateways {
-			route := r
+	proxy, err := proxy.NewProxyMW(cfg, logger)
+	if err != nil {
+		cancel()
 
-			if gateway.Auth {
-				route = route.With(auth.Middleware)
-			}
+		return nil, nil, err
+	}
 
-			if gateway.Cash {
-				route = route.With(cache.Middleware)
-			}
+	gatewayHandler, err := handler.NewHandler(proxy, lb, logger, cfg)
+	if err != nil {
+		cancel()
 
-			route.HandleFunc(gateway.Prefix+"/*", proxy.Middleware(gateway.Target))
-		}
-	})
+		logger.Error("failed create handler", zap.Error(err))
 
-	if cfg.RateLimitingConfig.Use {
-		r.Use(rl.RateLimitMiddleware)
+		return nil, nil, err
 	}
 
-	server := &http3.Server{
-		Addr:    cfg.Addr,
-		Handler: r,
+	var h http.Handler = gatewayHandler
+
+	if cfg.WAF.Use {
+		waf, err := newWaf(cfg, logger)
+		if err != nil {
+			cancel()
+
+			return nil, nil, err
+		}
+
+		h = txhttp.WrapHandler(waf, h)
 	}
 
-	return &Server{
+	metrics.InitMetrics()
+
+	r.Handle("/metrics", promhttp.Handler())
+	r.Handle("/*", h)
+
+	s := &Server{
 		router: r,
 		logger: logger,
 		cfg:    cfg,
-		h3S:    server,
+		lb:     lb,
+		proxy:  proxy,
+	}
+
+	// request deadline itself is applied by handler for every gateway,
+	// http3 server keeps default idle timeout of quic connections
+	// and http server only stops clients which are too slow to send headers
+	if cfg.Proto == "http3" {
+		s.h3S = &http3.Server{
+			Addr:    cfg.Addr,
+			Handler: r,
+		}
+	} else {
+		s.httpS = &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           r,
+			ReadHeaderTimeout: cfg.RequestTimeout,
+		}
+
+		// grpc clients without tls connect with HTTP/2 prior knowledge
+		if slices.ContainsFunc(cfg.Gateways, func(g config.Gateway) bool { return g.GRPC.Use }) {
+			s.httpS.Protocols = new(http.Protocols)
+			s.httpS.Protocols.SetHTTP1(true)
+			s.httpS.Protocols.SetHTTP2(true)
+			s.httpS.Protocols.SetUnencryptedHTTP2(true)
+		}
 	}
+
+	return s, cancel, nil
 }
 
 func (s *Server) Run() error {
-	if err := s.h3S.ListenAndServe(); err != nil {
-		s.logger.Error().Msgf("failed listen and serve on %s: %v", s.cfg.Addr, err)
+	var err error
+
+	switch {
+	case s.h3S != nil:
+		err = s.h3S.ListenAndServeTLS(s.cfg.TLS.Cert, s.cfg.TLS.Key)
+	case s.cfg.TLS.Cert != "":
+		err = s.httpS.ListenAndServeTLS(s.cfg.TLS.Cert, s.cfg.TLS.Key)
+	default:
+		err = s.httpS.ListenAndServe()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logger.Error("failed listen and serve",
+			zap.String("addr", s.cfg.Addr),
+			zap.Error(err))
 
 		return err
 	}
@@ -76,9 +131,50 @@ func (s *Server) Run() error {
 	return nil
 }
 
+// Reload applies settings of new config which can be changed
+// without restart, now they are weights of gateway pools
+func (s *Server) Reload(cfg *config.Config) error {
+	var errs []error
+
+	for i := range cfg.Gateways {
+		gateway := &cfg.Gateways[i]
+		if len(gateway.Pools) == 0 {
+			continue
+		}
+
+		if err := s.lb.SetPoolWeights(gateway.Name, gateway.PoolWeights()); err != nil {
+			s.logger.Error("failed reload weights of pools",
+				zap.String("gateway", gateway.Name),
+				zap.Error(err))
+
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Shutdown stops accepting connections and waits until requests and
+// streams are finished, streams still open when ctx is done are closed
 func (s *Server) Shutdown(ctx context.Context) error {
-	if err := s.h3S.Shutdown(ctx); err != nil {
-		s.logger.Error().Msgf("failed shutdown server: %v", err)
+	// hijacked connections are not tracked by http server, so streams are drained by proxy
+	drained := make(chan error, 1)
+	go func() {
+		drained <- s.proxy.Drain(ctx)
+	}()
+
+	var err error
+	if s.h3S != nil {
+		err = s.h3S.Shutdown(ctx)
+	} else {
+		err = s.httpS.Shutdown(ctx)
+	}
+
+	err = errors.Join(err, <-drained)
+
+	if err != nil {
+		s.logger.Error("failed shutdown server", zap.Error(err))
+
 		return err
 	}
 
@@ -0,0 +1,41 @@
+// package detects long-lived websocket and event stream traffic
+package stream
+
+import (
+	"net/http"
+	"strings"
+)
+
+// IsRequest returns true for websocket upgrade and event stream requests,
+// they are long-lived and request timeout is not applied to them
+func IsRequest(r *http.Request) bool {
+	if HasToken(r.Header.Values("Connection"), "upgrade") && r.Header.Get("Upgrade") != "" {
+		return true
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// IsResponse returns true for upgraded connections and responses without end
+func IsResponse(status int, header http.Header) bool {
+	if status == http.StatusSwitchingProtocols {
+		return true
+	}
+
+	mediaType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+
+	return strings.TrimSpace(mediaType) == "text/event-stream"
+}
+
+// HasToken returns true when comma separated header values contain token
+func HasToken(values []string, token string) bool {
+	for _, value := range values {
+		for part := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
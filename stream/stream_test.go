@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "plain", headers: map[string]string{"Accept": "application/json"}, want: false},
+		{name: "websocket", headers: map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket"}, want: true},
+		{name: "upgrade without protocol", headers: map[string]string{"Connection": "upgrade"}, want: false},
+		{name: "event stream", headers: map[string]string{"Accept": "text/event-stream"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/events", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			if got := IsRequest(req); got != tt.want {
+				t.Errorf("IsRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		want        bool
+	}{
+		{name: "json", status: http.StatusOK, contentType: "application/json", want: false},
+		{name: "switching protocols", status: http.StatusSwitchingProtocols, want: true},
+		{name: "event stream with charset", status: http.StatusOK, contentType: "text/event-stream; charset=utf-8", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Content-Type", tt.contentType)
+
+			if got := IsResponse(tt.status, header); got != tt.want {
+				t.Errorf("IsResponse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
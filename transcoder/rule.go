@@ -0,0 +1,168 @@
+package transcoder
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// httpRuleField is number of google.api.http extension of method options,
+// it is decoded from raw options, so generated annotations are not required
+const httpRuleField = 72295728
+
+// httpRule is google.api.HttpRule of method
+type httpRule struct {
+	method       string
+	pattern      string
+	body         string
+	responseBody string
+	additional   []httpRule
+}
+
+// methodRules returns http rules of method, nil is returned for methods without them
+func methodRules(md protoreflect.MethodDescriptor) ([]httpRule, error) {
+	opts := md.Options()
+	if opts == nil {
+		return nil, nil
+	}
+
+	// extension is unknown field or known one when annotations are linked in
+	raw, err := proto.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		raw = raw[n:]
+
+		if num != httpRuleField || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, raw)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			raw = raw[n:]
+
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(raw)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+
+		rule, err := parseHTTPRule(value)
+		if err != nil {
+			return nil, err
+		}
+
+		// body and response body of rule are not inherited by additional bindings
+		return append([]httpRule{rule}, rule.additional...), nil
+	}
+
+	return nil, nil
+}
+
+func parseHTTPRule(b []byte) (httpRule, error) {
+	var rule httpRule
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return rule, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return rule, protowire.ParseError(n)
+			}
+			b = b[n:]
+
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return rule, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case 2:
+			rule.method, rule.pattern = "GET", string(value)
+		case 3:
+			rule.method, rule.pattern = "PUT", string(value)
+		case 4:
+			rule.method, rule.pattern = "POST", string(value)
+		case 5:
+			rule.method, rule.pattern = "DELETE", string(value)
+		case 6:
+			rule.method, rule.pattern = "PATCH", string(value)
+		case 7:
+			rule.body = string(value)
+		case 8:
+			method, pattern, err := parseCustomPattern(value)
+			if err != nil {
+				return rule, err
+			}
+
+			rule.method, rule.pattern = method, pattern
+		case 11:
+			additional, err := parseHTTPRule(value)
+			if err != nil {
+				return rule, fmt.Errorf("failed parse additional binding: %v", err)
+			}
+
+			rule.additional = append(rule.additional, additional)
+		case 12:
+			rule.responseBody = string(value)
+		}
+	}
+
+	return rule, nil
+}
+
+// parseCustomPattern parses google.api.CustomHttpPattern
+func parseCustomPattern(b []byte) (string, string, error) {
+	var method, pattern string
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return "", "", protowire.ParseError(n)
+			}
+			b = b[n:]
+
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case 1:
+			method = string(value)
+		case 2:
+			pattern = string(value)
+		}
+	}
+
+	return method, pattern, nil
+}
@@ -0,0 +1,191 @@
+package transcoder
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type (
+	// template is compiled path template of http rule like /v1/{name=shelves/*}/books:list
+	template struct {
+		// segments are literals, "*" for one segment or "**" for the rest of path
+		segments []string
+		vars     []variable
+		verb     string
+	}
+
+	// variable binds segments [start, end) of path to field of request message
+	variable struct {
+		field string
+		start int
+		end   int
+	}
+)
+
+func parseTemplate(pattern string) (*template, error) {
+	path, ok := strings.CutPrefix(pattern, "/")
+	if !ok {
+		return nil, fmt.Errorf("path %s must start with /", pattern)
+	}
+
+	t := &template{}
+
+	// verb is after the last colon outside of variables
+	depth, colon := 0, -1
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				colon = i
+			}
+		}
+	}
+
+	if colon >= 0 {
+		t.verb = path[colon+1:]
+		path = path[:colon]
+	}
+
+	if path == "" {
+		return t, nil
+	}
+
+	for _, part := range splitTemplate(path) {
+		if !strings.HasPrefix(part, "{") {
+			if part == "" {
+				return nil, fmt.Errorf("path %s has empty segment", pattern)
+			}
+
+			t.segments = append(t.segments, part)
+
+			continue
+		}
+
+		inner, ok := strings.CutSuffix(part[1:], "}")
+		if !ok {
+			return nil, fmt.Errorf("path %s has unclosed variable", pattern)
+		}
+
+		field, segments, ok := strings.Cut(inner, "=")
+		if !ok {
+			segments = "*"
+		}
+
+		v := variable{field: field, start: len(t.segments)}
+
+		for _, segment := range strings.Split(segments, "/") {
+			if segment == "" {
+				return nil, fmt.Errorf("path %s has empty segment in variable %s", pattern, field)
+			}
+
+			t.segments = append(t.segments, segment)
+		}
+
+		v.end = len(t.segments)
+		t.vars = append(t.vars, v)
+	}
+
+	for i, segment := range t.segments {
+		if segment == "**" && i != len(t.segments)-1 {
+			return nil, fmt.Errorf("** must be the last segment of path %s", pattern)
+		}
+	}
+
+	return t, nil
+}
+
+// splitTemplate splits path by slashes which are not inside of variables
+func splitTemplate(path string) []string {
+	var parts []string
+
+	depth, start := 0, 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				parts = append(parts, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, path[start:])
+}
+
+// match returns values of variables when escaped path matches template
+func (t *template) match(path string) (map[string]string, bool) {
+	path = strings.TrimPrefix(path, "/")
+
+	if t.verb != "" {
+		var ok bool
+		if path, ok = strings.CutSuffix(path, ":"+t.verb); !ok {
+			return nil, false
+		}
+	}
+
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, false
+		}
+
+		parts[i] = unescaped
+	}
+
+	deep := len(t.segments) > 0 && t.segments[len(t.segments)-1] == "**"
+
+	fixed := len(t.segments)
+	if deep {
+		fixed--
+	}
+
+	if len(parts) < fixed || !deep && len(parts) != fixed {
+		return nil, false
+	}
+
+	for i, segment := range t.segments[:fixed] {
+		if segment == "*" && parts[i] == "" || segment != "*" && segment != parts[i] {
+			return nil, false
+		}
+	}
+
+	params := make(map[string]string, len(t.vars))
+
+	for _, v := range t.vars {
+		end := v.end
+		if deep && end == len(t.segments) {
+			end = len(parts)
+		}
+
+		params[v.field] = strings.Join(parts[v.start:end], "/")
+	}
+
+	return params, true
+}
+
+// literals returns count of literal segments, templates with more of them are matched first
+func (t *template) literals() int {
+	count := 0
+
+	for _, segment := range t.segments {
+		if segment != "*" && segment != "**" {
+			count++
+		}
+	}
+
+	return count
+}
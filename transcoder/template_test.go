@@ -0,0 +1,99 @@
+package transcoder
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestTemplateMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		want    map[string]string
+		// ok is false when path must not match
+		ok bool
+	}{
+		{name: "literal", pattern: "/v1/health", path: "/v1/health", want: map[string]string{}, ok: true},
+		{name: "literal mismatch", pattern: "/v1/health", path: "/v1/status", ok: false},
+		{name: "variable", pattern: "/v1/books/{id}", path: "/v1/books/42", want: map[string]string{"id": "42"}, ok: true},
+		{name: "empty variable", pattern: "/v1/books/{id}", path: "/v1/books/", ok: false},
+		{name: "extra segment", pattern: "/v1/books/{id}", path: "/v1/books/42/pages", ok: false},
+		{
+			name:    "variable with segments",
+			pattern: "/v1/{name=shelves/*/books/*}",
+			path:    "/v1/shelves/s1/books/b2",
+			want:    map[string]string{"name": "shelves/s1/books/b2"},
+			ok:      true,
+		},
+		{
+			name:    "variable with segments mismatch",
+			pattern: "/v1/{name=shelves/*/books/*}",
+			path:    "/v1/shelves/s1/authors/b2",
+			ok:      false,
+		},
+		{
+			name:    "rest of path",
+			pattern: "/v1/files/{path=**}",
+			path:    "/v1/files/a/b/c.txt",
+			want:    map[string]string{"path": "a/b/c.txt"},
+			ok:      true,
+		},
+		{name: "verb", pattern: "/v1/books/{id}:archive", path: "/v1/books/7:archive", want: map[string]string{"id": "7"}, ok: true},
+		{name: "missing verb", pattern: "/v1/books/{id}:archive", path: "/v1/books/7", ok: false},
+		{
+			// verb is after the last colon, earlier ones belong to segments
+			name:    "colon in literal before verb",
+			pattern: "/v1/books:batch/{id}:archive",
+			path:    "/v1/books:batch/7:archive",
+			want:    map[string]string{"id": "7"},
+			ok:      true,
+		},
+		{
+			name:    "escaped segment",
+			pattern: "/v1/users/{email}",
+			path:    "/v1/users/a%2Fb%40example.com",
+			want:    map[string]string{"email": "a/b@example.com"},
+			ok:      true,
+		},
+		{
+			name:    "several variables",
+			pattern: "/v1/shelves/{shelf}/books/{book.id}",
+			path:    "/v1/shelves/s1/books/9",
+			want:    map[string]string{"shelf": "s1", "book.id": "9"},
+			ok:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseTemplate(tt.pattern)
+			if err != nil {
+				t.Fatalf("failed parse template: %v", err)
+			}
+
+			got, ok := tmpl.match(tt.path)
+			if ok != tt.ok {
+				t.Fatalf("match %v, want %v", ok, tt.ok)
+			}
+
+			if ok && !maps.Equal(got, tt.want) {
+				t.Errorf("params %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"v1/books",
+		"/v1//books",
+		"/v1/{id",
+		"/v1/{path=**}/books",
+		"/v1/{name=shelves//books}",
+	} {
+		if _, err := parseTemplate(pattern); err == nil {
+			t.Errorf("invalid template %s is parsed", pattern)
+		}
+	}
+}
@@ -0,0 +1,611 @@
+// package transcodes REST calls with JSON bodies into unary grpc calls
+// by google.api.http annotations of compiled protobuf descriptors
+package transcoder
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	// details of errors are rendered with types of standard error details
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+type (
+	// Transcoder stores http bindings of unary methods of descriptor set
+	Transcoder struct {
+		bindings []*binding
+		resolver resolver
+		// maxBodySize limits JSON body of call
+		maxBodySize int64
+	}
+
+	// binding is one http rule of method
+	binding struct {
+		method protoreflect.MethodDescriptor
+		// path is path of grpc call like /package.Service/Method
+		path         string
+		httpMethod   string
+		template     *template
+		body         string
+		responseBody string
+	}
+
+	// Call is REST call transcoded into grpc one
+	Call struct {
+		t       *Transcoder
+		binding *binding
+	}
+
+	// Error is REST call which can't be transcoded
+	Error struct {
+		Code    codes.Code
+		Message string
+		// Status replaces http status mapped from code when it is set
+		Status int
+	}
+
+	// resolver finds types of descriptor set first and linked types after them
+	resolver []*protoregistry.Types
+)
+
+// New loads descriptor set compiled with imports, like protoc --include_imports does,
+// bodies of calls bigger than maxBodySize are rejected
+func New(path string, maxBodySize int64) (*Transcoder, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed read descriptor set: %v", err)
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(raw, set); err != nil {
+		return nil, fmt.Errorf("failed parse descriptor set: %v", err)
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("failed build descriptors: %v", err)
+	}
+
+	t := &Transcoder{maxBodySize: maxBodySize}
+	types := new(protoregistry.Types)
+
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		registerMessages(types, fd.Messages())
+
+		services := fd.Services()
+		for i := 0; i < services.Len() && err == nil; i++ {
+			err = t.addService(services.Get(i))
+		}
+
+		return err == nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.resolver = resolver{types, protoregistry.GlobalTypes}
+
+	// literal paths are more specific than variables
+	slices.SortStableFunc(t.bindings, func(a, b *binding) int {
+		return cmp.Or(
+			cmp.Compare(b.template.literals(), a.template.literals()),
+			cmp.Compare(len(b.template.segments), len(a.template.segments)),
+		)
+	})
+
+	return t, nil
+}
+
+func registerMessages(types *protoregistry.Types, messages protoreflect.MessageDescriptors) {
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		if md.IsMapEntry() {
+			continue
+		}
+
+		types.RegisterMessage(dynamicpb.NewMessageType(md))
+		registerMessages(types, md.Messages())
+	}
+}
+
+// addService adds bindings of unary methods of service, streaming methods are skipped
+func (t *Transcoder) addService(sd protoreflect.ServiceDescriptor) error {
+	methods := sd.Methods()
+
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			continue
+		}
+
+		rules, err := methodRules(md)
+		if err != nil {
+			return fmt.Errorf("failed parse http rule of method %s: %v", md.FullName(), err)
+		}
+
+		for _, rule := range rules {
+			b, err := newBinding(md, rule)
+			if err != nil {
+				return fmt.Errorf("invalid http rule of method %s: %v", md.FullName(), err)
+			}
+
+			t.bindings = append(t.bindings, b)
+		}
+	}
+
+	return nil
+}
+
+func newBinding(md protoreflect.MethodDescriptor, rule httpRule) (*binding, error) {
+	tmpl, err := parseTemplate(rule.pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	input := md.Input()
+
+	for _, v := range tmpl.vars {
+		if _, err := fieldPath(input, v.field); err != nil {
+			return nil, err
+		}
+	}
+
+	if rule.body != "" && rule.body != "*" && input.Fields().ByName(protoreflect.Name(rule.body)) == nil {
+		return nil, fmt.Errorf("unknown body field %s", rule.body)
+	}
+
+	if rule.responseBody != "" && md.Output().Fields().ByName(protoreflect.Name(rule.responseBody)) == nil {
+		return nil, fmt.Errorf("unknown response body field %s", rule.responseBody)
+	}
+
+	return &binding{
+		method:       md,
+		path:         "/" + string(md.Parent().FullName()) + "/" + string(md.Name()),
+		httpMethod:   rule.method,
+		template:     tmpl,
+		body:         rule.body,
+		responseBody: rule.responseBody,
+	}, nil
+}
+
+// Transcode returns grpc request of REST call, request message is built
+// from variables of path, body and query parameters of call
+func (t *Transcoder) Transcode(r *http.Request) (*http.Request, *Call, error) {
+	b, params := t.match(r)
+	if b == nil {
+		return nil, nil, &Error{Code: codes.NotFound, Message: "no method for " + r.Method + " " + r.URL.Path}
+	}
+
+	msg := dynamicpb.NewMessage(b.method.Input())
+
+	if b.body != "" && r.Body != nil {
+		data, err := io.ReadAll(io.LimitReader(r.Body, t.maxBodySize+1))
+		if err != nil {
+			return nil, nil, &Error{Code: codes.InvalidArgument, Message: "failed read body: " + err.Error()}
+		}
+
+		if int64(len(data)) > t.maxBodySize {
+			return nil, nil, &Error{
+				Code:    codes.ResourceExhausted,
+				Message: "request body is too large",
+				Status:  http.StatusRequestEntityTooLarge,
+			}
+		}
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			if b.body != "*" {
+				// body is value of field, so it is unmarshaled as object with this field
+				name := b.method.Input().Fields().ByName(protoreflect.Name(b.body)).JSONName()
+				data = slices.Concat([]byte(`{"`+name+`":`), data, []byte("}"))
+			}
+
+			opts := protojson.UnmarshalOptions{Resolver: t.resolver}
+			if err := opts.Unmarshal(data, msg); err != nil {
+				return nil, nil, &Error{Code: codes.InvalidArgument, Message: "invalid body: " + err.Error()}
+			}
+		}
+	}
+
+	for field, value := range params {
+		if err := setField(msg, field, []string{value}); err != nil {
+			return nil, nil, &Error{Code: codes.InvalidArgument, Message: err.Error()}
+		}
+	}
+
+	// fields which are not bound by path or body are read from query
+	if b.body != "*" {
+		for key, values := range r.URL.Query() {
+			if _, ok := params[key]; ok || b.body != "" && (key == b.body || strings.HasPrefix(key, b.body+".")) {
+				continue
+			}
+
+			if _, err := fieldPath(msg.Descriptor(), key); err != nil {
+				// unknown parameters like cache busters are ignored
+				continue
+			}
+
+			if err := setField(msg, key, values); err != nil {
+				return nil, nil, &Error{Code: codes.InvalidArgument, Message: err.Error()}
+			}
+		}
+	}
+
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, nil, &Error{Code: codes.Internal, Message: "failed encode request: " + err.Error()}
+	}
+
+	out := r.Clone(r.Context())
+	out.Method = http.MethodPost
+	out.URL.Path = b.path
+	out.URL.RawPath = ""
+	out.URL.RawQuery = ""
+	out.Header.Set("Content-Type", "application/grpc")
+	out.Header.Set("Te", "trailers")
+	out.Header.Del("Content-Length")
+
+	frame := encodeFrame(payload)
+	out.Body = io.NopCloser(bytes.NewReader(frame))
+	out.ContentLength = int64(len(frame))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(frame)), nil
+	}
+
+	return out, &Call{t: t, binding: b}, nil
+}
+
+// match returns binding of call and values of path variables
+func (t *Transcoder) match(r *http.Request) (*binding, map[string]string) {
+	path := r.URL.EscapedPath()
+
+	for _, b := range t.bindings {
+		if b.httpMethod != r.Method {
+			continue
+		}
+
+		if params, ok := b.template.match(path); ok {
+			return b, params
+		}
+	}
+
+	return nil, nil
+}
+
+// Method returns http method of rule which matched REST call,
+// it decides whether call is idempotent while grpc call is always POST
+func (c *Call) Method() string {
+	return c.binding.httpMethod
+}
+
+// Response returns JSON of grpc response message
+func (c *Call) Response(payload []byte) ([]byte, error) {
+	data, err := decodeFrame(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dynamicpb.NewMessage(c.binding.method.Output())
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("failed decode response: %v", err)
+	}
+
+	opts := protojson.MarshalOptions{Resolver: c.t.resolver, EmitUnpopulated: true}
+
+	body, err := opts.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed encode response: %v", err)
+	}
+
+	if c.binding.responseBody == "" {
+		return body, nil
+	}
+
+	// only value of response body field is returned
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("failed encode response: %v", err)
+	}
+
+	name := c.binding.method.Output().Fields().ByName(protoreflect.Name(c.binding.responseBody)).JSONName()
+
+	return fields[name], nil
+}
+
+// Error returns http status and JSON of failed call,
+// details is value of grpc-status-details-bin trailer
+func (c *Call) Error(code codes.Code, message string, details string) (int, []byte) {
+	return c.t.Error(code, message, details)
+}
+
+// Error returns http status and JSON of google.rpc.Status of failed call
+func (t *Transcoder) Error(code codes.Code, message string, details string) (int, []byte) {
+	st := &spb.Status{}
+
+	if details != "" {
+		raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(details, "="))
+		if err == nil && proto.Unmarshal(raw, st) != nil {
+			st.Reset()
+		}
+	}
+
+	st.Code = int32(code)
+	st.Message = message
+
+	opts := protojson.MarshalOptions{Resolver: t.resolver}
+
+	body, err := opts.Marshal(st)
+	if err != nil {
+		// details of unknown types are dropped
+		st.Details = nil
+		body, _ = opts.Marshal(st)
+	}
+
+	return HTTPStatus(code), body
+}
+
+// WriteError writes JSON of call which can't be transcoded
+func (t *Transcoder) WriteError(w http.ResponseWriter, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Code: codes.Internal, Message: err.Error()}
+	}
+
+	status, body := t.Error(e.Code, e.Message, "")
+	if e.Status != 0 {
+		status = e.Status
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// HTTPStatus maps grpc status to http one like grpc-gateway does it
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// encodeFrame puts message into uncompressed grpc frame
+func encodeFrame(payload []byte) []byte {
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+
+	return append(frame, payload...)
+}
+
+// decodeFrame returns message of unary response, empty body is empty message
+func decodeFrame(body []byte) ([]byte, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	if len(body) < 5 {
+		return nil, fmt.Errorf("grpc frame is truncated")
+	}
+
+	if body[0]&1 != 0 {
+		return nil, fmt.Errorf("compressed grpc messages are not supported")
+	}
+
+	size := binary.BigEndian.Uint32(body[1:5])
+	if uint64(len(body)-5) < uint64(size) {
+		return nil, fmt.Errorf("grpc frame is truncated")
+	}
+
+	return body[5 : 5+size], nil
+}
+
+// fieldPath returns fields of dotted path like book.author.name,
+// names may be proto or JSON ones
+func fieldPath(md protoreflect.MessageDescriptor, path string) ([]protoreflect.FieldDescriptor, error) {
+	names := strings.Split(path, ".")
+	fields := make([]protoreflect.FieldDescriptor, 0, len(names))
+
+	for i, name := range names {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+
+		if fd == nil {
+			return nil, fmt.Errorf("unknown field %s", path)
+		}
+
+		fields = append(fields, fd)
+
+		if i < len(names)-1 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return nil, fmt.Errorf("field %s is not message", path)
+			}
+
+			md = fd.Message()
+		}
+	}
+
+	return fields, nil
+}
+
+// setField sets field of message by path from string values,
+// the last value is used for singular fields
+func setField(msg protoreflect.Message, path string, values []string) error {
+	fields, err := fieldPath(msg.Descriptor(), path)
+	if err != nil {
+		return err
+	}
+
+	for _, fd := range fields[:len(fields)-1] {
+		msg = msg.Mutable(fd).Message()
+	}
+
+	fd := fields[len(fields)-1]
+
+	if fd.IsMap() {
+		return fmt.Errorf("map field %s can't be set from path or query", path)
+	}
+
+	if fd.IsList() {
+		list := msg.Mutable(fd).List()
+
+		for _, value := range values {
+			v, err := parseValue(fd, value, list.NewElement)
+			if err != nil {
+				return fmt.Errorf("invalid value of field %s: %v", path, err)
+			}
+
+			list.Append(v)
+		}
+
+		return nil
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	v, err := parseValue(fd, values[len(values)-1], func() protoreflect.Value { return msg.NewField(fd) })
+	if err != nil {
+		return fmt.Errorf("invalid value of field %s: %v", path, err)
+	}
+
+	msg.Set(fd, v)
+
+	return nil
+}
+
+// parseValue converts string to value of field kind, empty returns new message value
+func parseValue(fd protoreflect.FieldDescriptor, s string, empty func() protoreflect.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	default:
+		// well known types like timestamps and wrappers are parsed from JSON string
+		v := empty()
+		err := protojson.Unmarshal([]byte(strconv.Quote(s)), v.Message().Interface())
+
+		return v, err
+	}
+}
+
+func (r resolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	for _, types := range r {
+		if mt, err := types.FindMessageByName(name); err == nil {
+			return mt, nil
+		}
+	}
+
+	return nil, protoregistry.NotFound
+}
+
+func (r resolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	for _, types := range r {
+		if mt, err := types.FindMessageByURL(url); err == nil {
+			return mt, nil
+		}
+	}
+
+	return nil, protoregistry.NotFound
+}
+
+func (r resolver) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	for _, types := range r {
+		if xt, err := types.FindExtensionByName(name); err == nil {
+			return xt, nil
+		}
+	}
+
+	return nil, protoregistry.NotFound
+}
+
+func (r resolver) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	for _, types := range r {
+		if xt, err := types.FindExtensionByNumber(message, field); err == nil {
+			return xt, nil
+		}
+	}
+
+	return nil, protoregistry.NotFound
+}
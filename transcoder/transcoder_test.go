@@ -0,0 +1,362 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// rule encodes fields of google.api.HttpRule, values are strings or encoded messages
+func rule(fields ...any) []byte {
+	var b []byte
+
+	for i := 0; i < len(fields); i += 2 {
+		b = protowire.AppendTag(b, protowire.Number(fields[i].(int)), protowire.BytesType)
+
+		switch v := fields[i+1].(type) {
+		case string:
+			b = protowire.AppendString(b, v)
+		case []byte:
+			b = protowire.AppendBytes(b, v)
+		}
+	}
+
+	return b
+}
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+	fd := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Type:     typ.Enum(),
+		Label:    label.Enum(),
+	}
+
+	if typeName != "" {
+		fd.TypeName = proto.String(typeName)
+	}
+
+	return fd
+}
+
+func method(name, input, output string, httpRule []byte) *descriptorpb.MethodDescriptorProto {
+	opts := &descriptorpb.MethodOptions{}
+	opts.ProtoReflect().SetUnknown(protowire.AppendBytes(protowire.AppendTag(nil, httpRuleField, protowire.BytesType), httpRule))
+
+	return &descriptorpb.MethodDescriptorProto{
+		Name:       proto.String(name),
+		InputType:  proto.String(input),
+		OutputType: proto.String(output),
+		Options:    opts,
+	}
+}
+
+// newTestTranscoder writes descriptor set of library service and loads it
+func newTestTranscoder(t *testing.T, maxBodySize int64) *Transcoder {
+	t.Helper()
+
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		str      = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		i32      = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		i64      = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		boolean  = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		message  = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("library.proto"),
+		Package: proto.String("library"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Book"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, str, optional, ""),
+					field("pages", 2, i32, optional, ""),
+				},
+			},
+			{
+				Name: proto.String("GetBookRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("shelf", 1, str, optional, ""),
+					field("id", 2, i64, optional, ""),
+					field("full", 3, boolean, optional, ""),
+					field("tags", 4, str, repeated, ""),
+				},
+			},
+			{
+				Name: proto.String("CreateBookRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("shelf", 1, str, optional, ""),
+					field("book", 2, message, optional, ".library.Book"),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Library"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("GetBook", ".library.GetBookRequest", ".library.Book",
+					rule(2, "/v1/shelves/{shelf}/books/{id}", 11, rule(2, "/v1/books/{id}:name", 12, "name"))),
+				method("CreateBook", ".library.CreateBookRequest", ".library.Book",
+					rule(4, "/v1/shelves/{shelf}/books", 7, "book")),
+				method("UpdateBook", ".library.Book", ".library.Book",
+					rule(6, "/v1/{name=books/*}", 7, "*")),
+				method("ArchiveBook", ".library.GetBookRequest", ".library.Book",
+					rule(8, rule(1, "ARCHIVE", 2, "/v1/books/{id}"))),
+			},
+		}},
+	}
+
+	raw, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatalf("failed encode descriptor set: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "library.pb")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatalf("failed write descriptor set: %v", err)
+	}
+
+	tc, err := New(path, maxBodySize)
+	if err != nil {
+		t.Fatalf("failed create transcoder: %v", err)
+	}
+
+	return tc
+}
+
+// decodeRequest returns JSON of grpc request sent by transcoder
+func decodeRequest(t *testing.T, out *http.Request, call *Call) map[string]any {
+	t.Helper()
+
+	body, err := io.ReadAll(out.Body)
+	if err != nil {
+		t.Fatalf("failed read grpc request: %v", err)
+	}
+
+	payload, err := decodeFrame(body)
+	if err != nil {
+		t.Fatalf("failed decode grpc frame: %v", err)
+	}
+
+	msg := dynamicpb.NewMessage(call.binding.method.Input())
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		t.Fatalf("failed decode grpc request: %v", err)
+	}
+
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		t.Fatalf("failed encode grpc request: %v", err)
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("failed decode JSON of grpc request: %v", err)
+	}
+
+	return fields
+}
+
+func TestTranscode(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		// path is path of grpc call, want is JSON of its request
+		path string
+		want string
+	}{
+		{
+			name:   "path and query",
+			method: "GET",
+			target: "/v1/shelves/s1/books/42?full=true&tags=a&tags=b&_=123",
+			path:   "/library.Library/GetBook",
+			want:   `{"shelf":"s1","id":"42","full":true,"tags":["a","b"]}`,
+		},
+		{
+			name:   "additional binding",
+			method: "GET",
+			target: "/v1/books/7:name",
+			path:   "/library.Library/GetBook",
+			want:   `{"id":"7"}`,
+		},
+		{
+			name:   "body field",
+			method: "POST",
+			target: "/v1/shelves/s1/books",
+			body:   `{"name":"Go","pages":300}`,
+			path:   "/library.Library/CreateBook",
+			want:   `{"shelf":"s1","book":{"name":"Go","pages":300}}`,
+		},
+		{
+			name:   "query does not override body field",
+			method: "POST",
+			target: "/v1/shelves/s1/books?book.pages=1",
+			body:   `{"pages":300}`,
+			path:   "/library.Library/CreateBook",
+			want:   `{"shelf":"s1","book":{"pages":300}}`,
+		},
+		{
+			name:   "whole body and path variable",
+			method: "PATCH",
+			target: "/v1/books/go?pages=1",
+			body:   `{"name":"ignored","pages":10}`,
+			path:   "/library.Library/UpdateBook",
+			want:   `{"name":"books/go","pages":10}`,
+		},
+		{
+			name:   "custom method",
+			method: "ARCHIVE",
+			target: "/v1/books/9",
+			path:   "/library.Library/ArchiveBook",
+			want:   `{"id":"9"}`,
+		},
+	}
+
+	tc := newTestTranscoder(t, 1<<10)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+
+			out, call, err := tc.Transcode(r)
+			if err != nil {
+				t.Fatalf("failed transcode: %v", err)
+			}
+
+			if out.Method != http.MethodPost || out.URL.Path != tt.path || out.URL.RawQuery != "" {
+				t.Errorf("grpc request %s %s, want POST %s", out.Method, out.URL, tt.path)
+			}
+
+			if ct := out.Header.Get("Content-Type"); ct != "application/grpc" {
+				t.Errorf("content type %s, want application/grpc", ct)
+			}
+
+			var want map[string]any
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("invalid expected JSON: %v", err)
+			}
+
+			if got := decodeRequest(t, out, call); !reflect.DeepEqual(got, want) {
+				t.Errorf("grpc request %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTranscodeErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantCode   codes.Code
+		wantStatus int
+	}{
+		{name: "unknown path", method: "GET", target: "/v1/authors/1", wantCode: codes.NotFound, wantStatus: http.StatusNotFound},
+		{name: "unknown method", method: "DELETE", target: "/v1/shelves/s1/books/1", wantCode: codes.NotFound, wantStatus: http.StatusNotFound},
+		{name: "invalid path value", method: "GET", target: "/v1/shelves/s1/books/abc", wantCode: codes.InvalidArgument, wantStatus: http.StatusBadRequest},
+		{name: "invalid query value", method: "GET", target: "/v1/shelves/s1/books/1?full=maybe", wantCode: codes.InvalidArgument, wantStatus: http.StatusBadRequest},
+		{name: "invalid body", method: "POST", target: "/v1/shelves/s1/books", body: `{"pages":"many"}`, wantCode: codes.InvalidArgument, wantStatus: http.StatusBadRequest},
+		{
+			name:       "body is too large",
+			method:     "POST",
+			target:     "/v1/shelves/s1/books",
+			body:       `{"name":"` + strings.Repeat("x", 64) + `"}`,
+			wantCode:   codes.ResourceExhausted,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	tc := newTestTranscoder(t, 32)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := tc.Transcode(httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+
+			var te *Error
+			if !errors.As(err, &te) {
+				t.Fatalf("got error %v, want transcoder error", err)
+			}
+
+			if te.Code != tt.wantCode {
+				t.Errorf("code %s, want %s", te.Code, tt.wantCode)
+			}
+
+			rec := httptest.NewRecorder()
+			tc.WriteError(rec, err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			var st map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil || st["code"] != float64(tt.wantCode) {
+				t.Errorf("body %s is not status with code %d", rec.Body.String(), tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCallResponse(t *testing.T) {
+	tc := newTestTranscoder(t, 1<<10)
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{name: "whole message", target: "/v1/shelves/s1/books/1", want: `{"name":"Go","pages":300}`},
+		{name: "response body", target: "/v1/books/1:name", want: `"Go"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, call, err := tc.Transcode(httptest.NewRequest("GET", tt.target, nil))
+			if err != nil {
+				t.Fatalf("failed transcode: %v", err)
+			}
+
+			book := dynamicpb.NewMessage(call.binding.method.Output())
+			if err := protojson.Unmarshal([]byte(`{"name":"Go","pages":300}`), book); err != nil {
+				t.Fatalf("failed build response: %v", err)
+			}
+
+			payload, err := proto.Marshal(book)
+			if err != nil {
+				t.Fatalf("failed encode response: %v", err)
+			}
+
+			body, err := call.Response(encodeFrame(payload))
+			if err != nil {
+				t.Fatalf("failed transcode response: %v", err)
+			}
+
+			var got, want any
+			json.Unmarshal(body, &got)
+			json.Unmarshal([]byte(tt.want), &want)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("response %s, want %s", body, tt.want)
+			}
+		})
+	}
+}